@@ -0,0 +1,124 @@
+// AI-assisted code
+
+// Package client sends entries to and reads entries from LibreLog logsets
+// that are end-to-end encrypted. Entries are encrypted before they leave
+// the device with a key derived from a passphrase, and only decrypted after
+// they come back, so the server only ever holds envelopes.
+//
+//	key, err := client.DeriveKey(passphrase, logID)
+//	c := &client.Client{IngestURL: "http://127.0.0.1:9000", APIURL: "http://127.0.0.1:8080", Token: apiKey}
+//	err = c.Send(ctx, key, map[string]any{"heart_rate": 61})
+//	entries, err := c.Logs(ctx, key, 100, nil)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Client talks to an ingester and the web API with an API key.
+type Client struct {
+	IngestURL  string
+	APIURL     string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// Entry is a decrypted entry.
+type Entry struct {
+	RecvTime time.Time
+	Data     json.RawMessage
+}
+
+func (c *Client) do(req *http.Request) ([]byte, error) {
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &e) == nil && e.Error != "" {
+			return nil, fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, resp.Status, e.Error)
+		}
+		return nil, fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	return body, nil
+}
+
+// Send encrypts v with key and stores it in key's logset.
+func (c *Client) Send(ctx context.Context, key *Key, v interface{}) error {
+	env, err := key.Seal(v)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]interface{}{"log_set": key.LogID(), "data": env})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.IngestURL+"/ingest", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	_, err = c.do(req)
+	return err
+}
+
+// Logs fetches and decrypts up to limit of the newest entries in key's
+// logset, older than before if it's set. It fails on the first entry that
+// doesn't decrypt, such as one sent with another passphrase.
+func (c *Client) Logs(ctx context.Context, key *Key, limit int, before *time.Time) ([]Entry, error) {
+	q := url.Values{"limit": {strconv.Itoa(limit)}}
+	if before != nil {
+		q.Set("before", before.Format(time.RFC3339Nano))
+	}
+	u := c.APIURL + "/api/logsets/" + url.PathEscape(key.LogID()) + "/logs?" + q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var stored []struct {
+		RecvTime time.Time `json:"recv_time"`
+		Data     string    `json:"data"`
+	}
+	if err := json.Unmarshal(body, &stored); err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(stored))
+	for _, s := range stored {
+		var env Envelope
+		if err := json.Unmarshal([]byte(s.Data), &env); err != nil {
+			return nil, fmt.Errorf("entry at %s: not an envelope", s.RecvTime.Format(time.RFC3339Nano))
+		}
+		e := Entry{RecvTime: s.RecvTime}
+		if err := key.Open(&env, &e.Data); err != nil {
+			return nil, fmt.Errorf("entry at %s: %w", s.RecvTime.Format(time.RFC3339Nano), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
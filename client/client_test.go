@@ -0,0 +1,133 @@
+// AI-assisted code
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSealOpen(t *testing.T) {
+	key, err := DeriveKey("correct horse", "vitals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := key.Seal(map[string]int{"heart_rate": 61})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Alg != Alg || env.KeyID != key.ID() || len(env.Nonce) != 24 {
+		t.Errorf("envelope %+v", env)
+	}
+	if strings.Contains(string(env.Ciphertext), "heart_rate") {
+		t.Error("ciphertext holds the plaintext")
+	}
+
+	var got map[string]int
+	if err := key.Open(env, &got); err != nil || got["heart_rate"] != 61 {
+		t.Errorf("open: %v %v", got, err)
+	}
+
+	again, _ := DeriveKey("correct horse", "vitals")
+	if again.ID() != key.ID() {
+		t.Error("same passphrase and logset gave a different key")
+	}
+	if err := again.Open(env, &got); err != nil {
+		t.Errorf("open with re-derived key: %v", err)
+	}
+
+	wrong, _ := DeriveKey("battery staple", "vitals")
+	if err := wrong.Open(env, &got); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("wrong passphrase: %v", err)
+	}
+	other, _ := DeriveKey("correct horse", "journal")
+	if err := other.Open(env, &got); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("other logset: %v", err)
+	}
+
+	env.Ciphertext[0] ^= 1
+	if err := key.Open(env, &got); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("tampered: %v", err)
+	}
+
+	if _, err := DeriveKey("", "vitals"); err == nil {
+		t.Error("empty passphrase accepted")
+	}
+}
+
+func TestClient(t *testing.T) {
+	var stored []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/ingest":
+			var lo struct {
+				LogSet string          `json:"log_set"`
+				Data   json.RawMessage `json:"data"`
+			}
+			json.NewDecoder(r.Body).Decode(&lo)
+			if lo.LogSet != "vitals" {
+				http.Error(w, `{"error":"wrong logset"}`, http.StatusBadRequest)
+				return
+			}
+			stored = append(stored, string(lo.Data))
+			w.Write([]byte(`{"status":"ok"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/api/logsets/vitals/logs":
+			if r.URL.Query().Get("limit") != "10" {
+				t.Errorf("limit %q", r.URL.Query().Get("limit"))
+			}
+			var out []map[string]interface{}
+			for i := len(stored) - 1; i >= 0; i-- {
+				out = append(out, map[string]interface{}{
+					"recv_time": time.Date(2026, 1, 1, 0, 0, i, 0, time.UTC),
+					"data":      stored[i],
+				})
+			}
+			json.NewEncoder(w).Encode(out)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	key, err := DeriveKey("correct horse", "vitals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Client{IngestURL: srv.URL, APIURL: srv.URL, Token: "tok"}
+	ctx := context.Background()
+	for _, bpm := range []int{61, 64} {
+		if err := c.Send(ctx, key, map[string]int{"heart_rate": bpm}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if strings.Contains(stored[0], "heart_rate") {
+		t.Errorf("server saw plaintext: %s", stored[0])
+	}
+
+	entries, err := c.Logs(ctx, key, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || string(entries[0].Data) != `{"heart_rate":64}` || string(entries[1].Data) != `{"heart_rate":61}` {
+		t.Errorf("entries %+v", entries)
+	}
+
+	wrong, _ := DeriveKey("battery staple", "vitals")
+	if _, err := c.Logs(ctx, wrong, 10, nil); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("wrong passphrase: %v", err)
+	}
+
+	c.Token = "nope"
+	if err := c.Send(ctx, key, 1); err == nil || !strings.Contains(err.Error(), "invalid token") {
+		t.Errorf("bad token: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+// AI-assisted code
+package client
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Alg names how envelopes are made: XChaCha20-Poly1305 with a key derived
+// from a passphrase by Argon2id, using the parameters below. Changing any
+// of them changes every key, so they'd need a new Alg.
+const Alg = "xchacha20poly1305-argon2id"
+
+const (
+	argonTime    = 3
+	argonMemory  = 64 << 10 // KiB
+	argonThreads = 4
+)
+
+var (
+	// ErrWrongKey means an envelope was sealed with a different key: a
+	// different passphrase, or another logset's.
+	ErrWrongKey = errors.New("envelope was sealed with a different key")
+	// ErrDecrypt means an envelope didn't decrypt with the right key, so it
+	// was changed after it was sealed.
+	ErrDecrypt = errors.New("envelope doesn't decrypt")
+)
+
+// Envelope is what an encrypted logset stores for each entry. Nonce and
+// Ciphertext are base64 in JSON.
+type Envelope struct {
+	Alg        string `json:"alg"`
+	KeyID      string `json:"key_id"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Key encrypts and decrypts one logset's entries.
+type Key struct {
+	id    string
+	logID string
+	aead  cipher.AEAD
+}
+
+// DeriveKey derives a logset's key from a passphrase. The salt comes from
+// the log_id, so each logset gets its own key and any device with the
+// passphrase derives the same one. Deriving takes a moment and 64 MB of
+// memory on purpose; keep the Key rather than deriving it per entry.
+func DeriveKey(passphrase, logID string) (*Key, error) {
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+	if logID == "" {
+		return nil, errors.New("empty log_id")
+	}
+	salt := sha256.Sum256([]byte("librelog e2e salt\x00" + logID))
+	secret := argon2.IDKey([]byte(passphrase), salt[:], argonTime, argonMemory, argonThreads, chacha20poly1305.KeySize)
+	defer clear(secret)
+
+	aead, err := chacha20poly1305.NewX(secret)
+	if err != nil {
+		return nil, err
+	}
+	// The key id lets a wrong passphrase be told apart from a damaged
+	// entry. It's a MAC of nothing, so it says nothing about the key.
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("librelog key id"))
+	return &Key{
+		id:    hex.EncodeToString(mac.Sum(nil)[:8]),
+		logID: logID,
+		aead:  aead,
+	}, nil
+}
+
+// ID identifies the key in envelopes.
+func (k *Key) ID() string { return k.id }
+
+// LogID is the logset the key is for.
+func (k *Key) LogID() string { return k.logID }
+
+// Seal encrypts v, marshalled as JSON. The log_id is authenticated along
+// with it, so the envelope won't open in another logset.
+func (k *Key) Seal(v interface{}) (*Envelope, error) {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &Envelope{
+		Alg:        Alg,
+		KeyID:      k.id,
+		Nonce:      nonce,
+		Ciphertext: k.aead.Seal(nil, nonce, plaintext, []byte(k.logID)),
+	}, nil
+}
+
+// Open decrypts an envelope into v, as json.Unmarshal would.
+func (k *Key) Open(e *Envelope, v interface{}) error {
+	if e.Alg != Alg {
+		return fmt.Errorf("unsupported alg %q", e.Alg)
+	}
+	if e.KeyID != k.id {
+		return ErrWrongKey
+	}
+	if len(e.Nonce) != k.aead.NonceSize() {
+		return ErrDecrypt
+	}
+	plaintext, err := k.aead.Open(nil, e.Nonce, e.Ciphertext, []byte(k.logID))
+	if err != nil {
+		return ErrDecrypt
+	}
+	return json.Unmarshal(plaintext, v)
+}
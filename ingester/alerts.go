@@ -0,0 +1,394 @@
+// AI-assisted code
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+
+	"shared/atrest"
+)
+
+// Alert rules are managed by the web API and evaluated here. Every stored
+// entry is checked against its logset's rules, and a ticker handles windows
+// running out and silence rules. State changes are conditional updates on
+// alert_state, so when several ingesters run only one of them records each
+// firing or resolution; the others pick up the new state on their next tick.
+
+const (
+	alertTick = 15 * time.Second
+	// alertSeenWrite throttles last_seen writes for rules that match often.
+	alertSeenWrite = 15 * time.Second
+)
+
+type alertCondition struct {
+	Field string          `json:"field"`
+	Op    string          `json:"op"`
+	Value json.RawMessage `json:"value"`
+	value interface{}
+}
+
+type alertRule struct {
+	userID   gocql.UUID
+	logID    string
+	ruleID   gocql.UUID
+	name     string
+	kind     string
+	cond     *alertCondition
+	window   time.Duration
+	forDur   time.Duration
+	cooldown time.Duration
+}
+
+type alertState struct {
+	State     string
+	Since     time.Time
+	LastSeen  time.Time
+	LastFired time.Time
+	savedSeen time.Time
+}
+
+type logsetKey struct {
+	userID gocql.UUID
+	logID  string
+}
+
+var (
+	alertMu     sync.Mutex
+	alertRules  = map[logsetKey][]*alertRule{}
+	alertStates = map[gocql.UUID]*alertState{}
+)
+
+func alertNumber(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case json.Number:
+		f, err := x.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(x, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// match checks the condition against a decoded entry. Numbers and numeric
+// strings compare as numbers; entries without the field never match, except
+// that `exists` checks exactly that.
+func (c *alertCondition) match(v interface{}) bool {
+	f, ok := lookupPath(v, c.Field)
+	switch {
+	case c.Op == "exists":
+		return ok
+	case !ok:
+		return false
+	case c.Op == "contains":
+		s, ok1 := f.(string)
+		want, ok2 := c.value.(string)
+		return ok1 && ok2 && strings.Contains(s, want)
+	}
+
+	a, aNum := alertNumber(f)
+	b, bNum := alertNumber(c.value)
+	switch c.Op {
+	case "==", "!=":
+		equal := reflect.DeepEqual(f, c.value)
+		if aNum && bNum {
+			equal = a == b
+		}
+		return equal == (c.Op == "==")
+	}
+	if !aNum || !bNum {
+		return false
+	}
+	switch c.Op {
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	}
+	return false
+}
+
+// advance moves st along for one observation: matched says whether an
+// entry matched, and is nil on ticks. It returns "firing" or "resolved" when
+// the rule changes state that way.
+func (r *alertRule) advance(st *alertState, now time.Time, matched *bool) string {
+	if r.kind == "silence" {
+		if matched != nil {
+			if !*matched {
+				return ""
+			}
+			st.LastSeen = now
+			if st.State == "firing" {
+				st.State, st.Since = "ok", now
+				return "resolved"
+			}
+			return ""
+		}
+		if st.State == "ok" && now.Sub(st.LastSeen) > r.window && now.Sub(st.LastFired) >= r.cooldown {
+			st.State, st.Since, st.LastFired = "firing", now, now
+			return "firing"
+		}
+		return ""
+	}
+
+	breaching := st.State != "ok" && now.Sub(st.LastSeen) <= r.window
+	if matched != nil {
+		breaching = *matched
+		if *matched {
+			st.LastSeen = now
+		}
+	}
+	if !breaching {
+		event := ""
+		if st.State == "firing" {
+			event = "resolved"
+		}
+		if st.State != "ok" {
+			st.State, st.Since = "ok", now
+		}
+		return event
+	}
+	if st.State == "ok" {
+		st.State, st.Since = "pending", now
+	}
+	if st.State == "pending" && now.Sub(st.Since) >= r.forDur && now.Sub(st.LastFired) >= r.cooldown {
+		st.State, st.Since, st.LastFired = "firing", now, now
+		return "firing"
+	}
+	return ""
+}
+
+// decodeEntry decodes entry data for conditions, keeping numbers exact.
+// Entries that aren't JSON decode to nil.
+func decodeEntry(data []byte) interface{} {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if dec.Decode(&v) != nil {
+		return nil
+	}
+	return v
+}
+
+// evaluateAlerts runs a stored entry through its logset's rules. Entries that
+// aren't JSON only count for silence rules without a condition.
+func evaluateAlerts(userID gocql.UUID, logID string, data []byte) {
+	alertMu.Lock()
+	rules := alertRules[logsetKey{userID, logID}]
+	alertMu.Unlock()
+	if len(rules) == 0 {
+		return
+	}
+
+	v := decodeEntry(data)
+	now := time.Now()
+	for _, r := range rules {
+		matched := r.cond == nil || r.cond.match(v)
+		advanceAlert(r, now, &matched, data)
+	}
+}
+
+func advanceAlert(r *alertRule, now time.Time, matched *bool, data []byte) {
+	alertMu.Lock()
+	st := alertStates[r.ruleID]
+	if st == nil {
+		alertMu.Unlock()
+		return
+	}
+	old := *st
+	event := r.advance(st, now, matched)
+	next := *st
+	changed := next.State != old.State || !next.LastFired.Equal(old.LastFired)
+	writeSeen := changed || next.LastSeen.Sub(st.savedSeen) >= alertSeenWrite
+	if writeSeen {
+		st.savedSeen = next.LastSeen
+	}
+	alertMu.Unlock()
+
+	if !changed {
+		if writeSeen {
+			err := session.Query(
+				`UPDATE alert_state SET last_seen = ? WHERE user_id = ? AND log_id = ? AND rule_id = ? IF EXISTS`,
+				next.LastSeen, r.userID, r.logID, r.ruleID,
+			).Exec()
+			if err != nil {
+				log.Println("alert state:", err)
+			}
+		}
+		return
+	}
+
+	applied, err := session.Query(
+		`UPDATE alert_state SET state = ?, since = ?, last_seen = ?, last_fired = ? WHERE user_id = ? AND log_id = ? AND rule_id = ? IF state = ?`,
+		next.State, next.Since, next.LastSeen, next.LastFired, r.userID, r.logID, r.ruleID, old.State,
+	).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		log.Println("alert state:", err)
+	}
+	if err != nil || !applied {
+		// Another ingester got there first, or the write failed; take
+		// whatever is stored.
+		reloadAlertState(r)
+		return
+	}
+	if event != "" {
+		recordAlertEvent(r, event, now, data)
+	}
+}
+
+func reloadAlertState(r *alertRule) {
+	var st alertState
+	err := session.Query(
+		`SELECT state, since, last_seen, last_fired FROM alert_state WHERE user_id = ? AND log_id = ? AND rule_id = ?`,
+		r.userID, r.logID, r.ruleID,
+	).Scan(&st.State, &st.Since, &st.LastSeen, &st.LastFired)
+	alertMu.Lock()
+	defer alertMu.Unlock()
+	if err != nil {
+		delete(alertStates, r.ruleID)
+		return
+	}
+	st.savedSeen = st.LastSeen
+	alertStates[r.ruleID] = &st
+}
+
+// recordAlertEvent adds a firing or resolution to the logset's alert
+// history. data is the entry that caused it, if any.
+func recordAlertEvent(r *alertRule, state string, now time.Time, data []byte) {
+	log.Printf("alert %q (%s/%s) %s", r.name, r.userID, r.logID, state)
+	eventID := gocql.UUIDFromTime(now)
+	sealed, keyID, err := keyStore.Seal(r.userID, atrest.AlertEventAD(r.userID, r.logID, eventID), data)
+	if err == nil {
+		err = session.Query(
+			`INSERT INTO alert_history (user_id, log_id, event_id, rule_id, name, state, data, key_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			r.userID, r.logID, eventID, r.ruleID, r.name, state, sealed, keyID,
+		).Exec()
+	}
+	if err != nil {
+		log.Println("alert history:", err)
+	}
+
+	alert := map[string]interface{}{
+		"alert_id": r.ruleID.String(),
+		"name":     r.name,
+		"kind":     r.kind,
+		"state":    state,
+	}
+	if len(data) > 0 {
+		alert["data"] = string(data)
+	}
+	notifyWebhooks(r.userID, r.logID, "alert", map[string]interface{}{"alert": alert}, nil)
+	if state == "firing" {
+		go emailAlert(r, now, data)
+	}
+}
+
+func parseAlertCondition(s string) (*alertCondition, error) {
+	if s == "" {
+		return nil, nil
+	}
+	c := &alertCondition{}
+	if err := json.Unmarshal([]byte(s), c); err != nil {
+		return nil, err
+	}
+	if len(c.Value) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(c.Value))
+		dec.UseNumber()
+		if err := dec.Decode(&c.value); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// refreshAlerts reloads every rule and state. Rule tables are small, so
+// each ingester simply keeps all of them. A last_seen newer in memory than
+// in Cassandra is kept, since those writes are throttled.
+func refreshAlerts() error {
+	rules := map[logsetKey][]*alertRule{}
+	iter := session.Query(
+		`SELECT user_id, log_id, rule_id, name, kind, condition, window_seconds, for_seconds, cooldown_seconds FROM alert_rules`,
+	).Iter()
+	var r alertRule
+	var condition string
+	var window, forSec, cooldown int
+	for iter.Scan(&r.userID, &r.logID, &r.ruleID, &r.name, &r.kind, &condition, &window, &forSec, &cooldown) {
+		cond, err := parseAlertCondition(condition)
+		if err != nil {
+			log.Printf("alert %s: invalid condition: %v", r.ruleID, err)
+			continue
+		}
+		rule := r
+		rule.cond = cond
+		rule.window = time.Duration(window) * time.Second
+		rule.forDur = time.Duration(forSec) * time.Second
+		rule.cooldown = time.Duration(cooldown) * time.Second
+		key := logsetKey{r.userID, r.logID}
+		rules[key] = append(rules[key], &rule)
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	states := map[gocql.UUID]*alertState{}
+	iter = session.Query(
+		`SELECT rule_id, state, since, last_seen, last_fired FROM alert_state`,
+	).Iter()
+	var ruleID gocql.UUID
+	var st alertState
+	for iter.Scan(&ruleID, &st.State, &st.Since, &st.LastSeen, &st.LastFired) {
+		s := st
+		s.savedSeen = s.LastSeen
+		states[ruleID] = &s
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	alertMu.Lock()
+	defer alertMu.Unlock()
+	for id, s := range states {
+		if cur := alertStates[id]; cur != nil && cur.LastSeen.After(s.LastSeen) {
+			s.LastSeen = cur.LastSeen
+		}
+	}
+	alertRules, alertStates = rules, states
+	return nil
+}
+
+func startAlertEvaluator() {
+	if err := refreshAlerts(); err != nil {
+		log.Println("alerts:", err)
+	}
+	go func() {
+		for range time.Tick(alertTick) {
+			if err := refreshAlerts(); err != nil {
+				log.Println("alerts:", err)
+				continue
+			}
+			var rules []*alertRule
+			alertMu.Lock()
+			for _, rs := range alertRules {
+				rules = append(rules, rs...)
+			}
+			alertMu.Unlock()
+
+			now := time.Now()
+			for _, r := range rules {
+				advanceAlert(r, now, nil, nil)
+			}
+		}
+	}()
+}
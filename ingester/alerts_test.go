@@ -0,0 +1,82 @@
+// AI-assisted code
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestAlertCondition(t *testing.T) {
+	var entry interface{}
+	dec := json.NewDecoder(bytes.NewReader([]byte(`{"perc": 92.5, "host": "laptop", "disk": {"free": "12"}}`)))
+	dec.UseNumber()
+	if err := dec.Decode(&entry); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		cond string
+		want bool
+	}{
+		{`{"field": "perc", "op": ">", "value": 90}`, true},
+		{`{"field": "perc", "op": "<=", "value": 90}`, false},
+		{`{"field": "disk.free", "op": "<", "value": 20}`, true},
+		{`{"field": "host", "op": "==", "value": "laptop"}`, true},
+		{`{"field": "host", "op": "!=", "value": "laptop"}`, false},
+		{`{"field": "host", "op": "contains", "value": "lap"}`, true},
+		{`{"field": "host", "op": ">", "value": 1}`, false},
+		{`{"field": "swap", "op": "exists"}`, false},
+		{`{"field": "swap", "op": "!=", "value": 1}`, false},
+	}
+	for _, tt := range tests {
+		c, err := parseAlertCondition(tt.cond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.match(entry); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.cond, got, tt.want)
+		}
+	}
+}
+
+type alertStep struct {
+	at      time.Duration
+	matched *bool
+	event   string
+	state   string
+}
+
+func runAlertSteps(t *testing.T, r *alertRule, steps []alertStep) {
+	t.Helper()
+	start := time.Date(2025, 10, 13, 20, 0, 0, 0, time.UTC)
+	st := &alertState{State: "ok", LastSeen: start}
+	for i, s := range steps {
+		if ev := r.advance(st, start.Add(s.at), s.matched); ev != s.event || st.State != s.state {
+			t.Fatalf("%s step %d: got %q/%s, want %q/%s", r.kind, i, ev, st.State, s.event, s.state)
+		}
+	}
+}
+
+func TestAlertAdvance(t *testing.T) {
+	yes, no := true, false
+
+	runAlertSteps(t, &alertRule{kind: "threshold", window: 5 * time.Minute, forDur: time.Minute, cooldown: 10 * time.Minute}, []alertStep{
+		{0, &yes, "", "pending"},
+		{30 * time.Second, &yes, "", "pending"},
+		{time.Minute, nil, "firing", "firing"}, // still breaching on a tick
+		{2 * time.Minute, &no, "resolved", "ok"},
+		{3 * time.Minute, &yes, "", "pending"},
+		{5 * time.Minute, &yes, "", "pending"}, // cooldown holds it back
+		{11 * time.Minute, &yes, "firing", "firing"},
+		{17 * time.Minute, nil, "resolved", "ok"}, // window passed without entries
+	})
+
+	runAlertSteps(t, &alertRule{kind: "silence", window: 10 * time.Minute}, []alertStep{
+		{5 * time.Minute, nil, "", "ok"},
+		{11 * time.Minute, nil, "firing", "firing"},
+		{12 * time.Minute, &no, "", "firing"},
+		{13 * time.Minute, &yes, "resolved", "ok"},
+	})
+}
@@ -0,0 +1,38 @@
+// AI-assisted code
+package main
+
+import (
+	"log"
+	"time"
+
+	"shared/atrest"
+)
+
+// With MASTER_KEY set, entry data, alert history and webhook payloads are
+// sealed with shared/atrest before they're stored, and the data key's id
+// goes in the row's key_id column. Rows with no key_id are plain text.
+
+// rewrapInterval is how often ingesters look for data keys still wrapped
+// by an old master key. They also look on startup, so a rotation starts
+// as soon as they restart with the new key.
+const rewrapInterval = time.Hour
+
+// keyStore is nil until main sets it up; Enabled is false then.
+var keyStore *atrest.Store
+
+func startKeyRewrap() {
+	go func() {
+		for {
+			rewrapped, stuck, err := keyStore.Rewrap()
+			if err != nil {
+				log.Println("rewrap:", err)
+			}
+			if stuck > 0 {
+				log.Printf("rewrapped %d data keys; %d are still under old master keys", rewrapped, stuck)
+			} else if rewrapped > 0 && err == nil {
+				log.Printf("rewrapped %d data keys; all are now under master key %s", rewrapped, keyStore.CurrentMasterID())
+			}
+			time.Sleep(rewrapInterval)
+		}
+	}()
+}
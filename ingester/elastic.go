@@ -0,0 +1,232 @@
+// AI-assisted code
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Elasticsearch _bulk compatibility for Filebeat, Fluent Bit, Vector and
+// Logstash. Only index/create actions are supported; the index name picks
+// the logset and the document is stored as-is.
+
+// esVersion is what we claim to be in the GET / handshake. Clients pick
+// their request format by major version, and 8.x means no mapping types.
+const esVersion = "8.11.0"
+
+func esHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+}
+
+func esError(w http.ResponseWriter, status int, typ, reason string) {
+	esHeaders(w)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":  map[string]string{"type": typ, "reason": reason},
+		"status": status,
+	})
+}
+
+// esAuthenticate accepts `Authorization: ApiKey base64(id:key)` as used by
+// Elasticsearch clients, with the LibreLog token as the key, alongside the
+// usual Bearer and Basic forms.
+func esAuthenticate(w http.ResponseWriter, r *http.Request) (gocql.UUID, bool) {
+	if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "ApiKey "); ok {
+		if decoded, err := base64.StdEncoding.DecodeString(key); err == nil {
+			if _, k, found := strings.Cut(string(decoded), ":"); found {
+				key = k
+			}
+		}
+		r.Header.Set("Authorization", "Bearer "+key)
+	}
+	return authenticateBasic(w, r)
+}
+
+func esInfo(w http.ResponseWriter, r *http.Request) {
+	esHeaders(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":         "librelog",
+		"cluster_name": "librelog",
+		"cluster_uuid": "librelog",
+		"version": map[string]interface{}{
+			"number":                              esVersion,
+			"build_flavor":                        "default",
+			"lucene_version":                      "9.8.0",
+			"minimum_wire_compatibility_version":  "7.17.0",
+			"minimum_index_compatibility_version": "7.0.0",
+		},
+		"tagline": "You Know, for Search",
+	})
+}
+
+type esItemResult struct {
+	Index       string            `json:"_index"`
+	ID          string            `json:"_id"`
+	Version     int               `json:"_version,omitempty"`
+	Result      string            `json:"result,omitempty"`
+	Status      int               `json:"status"`
+	SeqNo       int               `json:"_seq_no"`
+	PrimaryTerm int               `json:"_primary_term"`
+	Error       map[string]string `json:"error,omitempty"`
+}
+
+// esEventTime is a document's @timestamp, or now if it has none.
+func esEventTime(doc []byte, now time.Time) time.Time {
+	var d struct {
+		Timestamp string `json:"@timestamp"`
+	}
+	if json.Unmarshal(doc, &d) == nil && d.Timestamp != "" {
+		if t, err := time.Parse(time.RFC3339Nano, d.Timestamp); err == nil {
+			return t
+		}
+	}
+	return now
+}
+
+// esAction is one action of a bulk request, with its document for index
+// and create.
+type esAction struct {
+	op, index, id string
+	doc           []byte
+}
+
+// esBulkError fails a whole bulk request, with an Elasticsearch error
+// type.
+type esBulkError struct {
+	typ, reason string
+}
+
+func (e *esBulkError) Error() string { return e.reason }
+
+// parseESBulk splits a bulk body into its actions. Documents aren't
+// checked here; a bad one only fails its own item.
+func parseESBulk(body []byte, defaultIndex string) ([]esAction, error) {
+	var actions []esAction
+	sc := bufio.NewScanner(bytes.NewReader(body))
+	sc.Buffer(make([]byte, 0, 64*1024), maxBodySize)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var meta map[string]struct {
+			Index string `json:"_index"`
+			ID    string `json:"_id"`
+		}
+		if err := json.Unmarshal(line, &meta); err != nil || len(meta) != 1 {
+			return nil, &esBulkError{"illegal_argument_exception", "malformed action/metadata line"}
+		}
+
+		var a esAction
+		for k, v := range meta {
+			a.op, a.index, a.id = k, v.Index, v.ID
+		}
+		if a.index == "" {
+			a.index = defaultIndex
+		}
+		if a.id == "" {
+			a.id = gocql.TimeUUID().String()
+		}
+
+		switch a.op {
+		case "index", "create":
+			if !sc.Scan() {
+				return nil, &esBulkError{"illegal_argument_exception", "missing document after action line"}
+			}
+			a.doc = bytes.Clone(bytes.TrimSpace(sc.Bytes()))
+		case "update":
+			// skip the partial document that follows
+			sc.Scan()
+		}
+		actions = append(actions, a)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, &esBulkError{"parse_exception", err.Error()}
+	}
+	return actions, nil
+}
+
+func ingestESBulk(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	userID, ok := esAuthenticate(w, r)
+	if !ok {
+		return
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		esError(w, http.StatusBadRequest, "parse_exception", "invalid request body")
+		return
+	}
+
+	actions, err := parseESBulk(body, r.PathValue("index"))
+	if err != nil {
+		e := err.(*esBulkError)
+		esError(w, http.StatusBadRequest, e.typ, e.reason)
+		return
+	}
+
+	var items []map[string]esItemResult
+	hasErrors := false
+	for _, a := range actions {
+		res := esItemResult{Index: a.index, ID: a.id}
+
+		switch a.op {
+		case "index", "create":
+			switch {
+			case a.index == "":
+				res.Status = http.StatusBadRequest
+				res.Error = map[string]string{"type": "action_request_validation_exception", "reason": "index is missing"}
+			case !json.Valid(a.doc):
+				res.Status = http.StatusBadRequest
+				res.Error = map[string]string{"type": "document_parsing_exception", "reason": "failed to parse document"}
+			default:
+				logID, err := resolveLogset(userID, a.index)
+				if err == nil {
+					err = insertLog(userID, logID, listenerDedup.next(logID, esEventTime(a.doc, time.Now())), a.doc)
+				}
+				if refused(err) {
+					res.Status = http.StatusBadRequest
+					res.Error = map[string]string{"type": "document_parsing_exception", "reason": err.Error()}
+				} else if err != nil {
+					log.Println("es insert error:", err)
+					res.Status = http.StatusInternalServerError
+					res.Error = map[string]string{"type": "exception", "reason": "insert error"}
+				} else {
+					res.Status = http.StatusCreated
+					res.Result = "created"
+					res.Version = 1
+					res.PrimaryTerm = 1
+				}
+			}
+		default:
+			res.Status = http.StatusBadRequest
+			res.Error = map[string]string{"type": "illegal_argument_exception", "reason": a.op + " is not supported, entries are append-only"}
+		}
+
+		if res.Error != nil {
+			hasErrors = true
+		}
+		items = append(items, map[string]esItemResult{a.op: res})
+	}
+
+	if items == nil {
+		items = []map[string]esItemResult{}
+	}
+	esHeaders(w)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"took":   time.Since(start).Milliseconds(),
+		"errors": hasErrors,
+		"items":  items,
+	})
+}
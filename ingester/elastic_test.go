@@ -0,0 +1,89 @@
+// AI-assisted code
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseESBulk(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		index   string
+		want    []esAction
+		errType string
+	}{
+		{
+			name: "index and create",
+			body: `{"index": {"_index": "app", "_id": "1"}}` + "\n" + `{"msg": "one"}` + "\n\n" +
+				`{"create": {}}` + "\n" + `  {"msg": "two"}  ` + "\n",
+			index: "default",
+			want: []esAction{
+				{op: "index", index: "app", id: "1", doc: []byte(`{"msg": "one"}`)},
+				{op: "create", index: "default", doc: []byte(`{"msg": "two"}`)},
+			},
+		},
+		{
+			// Bad documents and unsupported actions only fail their item.
+			name: "per-item problems",
+			body: `{"index": {"_id": "1"}}` + "\n" + `not json` + "\n" +
+				`{"update": {"_index": "app", "_id": "1"}}` + "\n" + `{"doc": {}}` + "\n" +
+				`{"delete": {"_index": "app", "_id": "2"}}` + "\n",
+			want: []esAction{
+				{op: "index", id: "1", doc: []byte(`not json`)},
+				{op: "update", index: "app", id: "1"},
+				{op: "delete", index: "app", id: "2"},
+			},
+		},
+		{name: "empty", body: "\n\n"},
+		{name: "malformed action", body: `{"index": ` + "\n", errType: "illegal_argument_exception"},
+		{name: "two actions on a line", body: `{"index": {}, "create": {}}` + "\n{}\n", errType: "illegal_argument_exception"},
+		{name: "missing document", body: `{"index": {"_index": "app"}}`, errType: "illegal_argument_exception"},
+	}
+	for _, tt := range tests {
+		got, err := parseESBulk([]byte(tt.body), tt.index)
+		if tt.errType != "" {
+			var be *esBulkError
+			if !errors.As(err, &be) || be.typ != tt.errType {
+				t.Errorf("%s: %v, want %s", tt.name, err, tt.errType)
+			}
+			continue
+		}
+		if err != nil || len(got) != len(tt.want) {
+			t.Errorf("%s: %d actions, %v", tt.name, len(got), err)
+			continue
+		}
+		for i, a := range got {
+			w := tt.want[i]
+			if w.id == "" && a.id != "" {
+				// Generated ids are only checked for being there.
+				w.id = a.id
+			}
+			if a.op != w.op || a.index != w.index || a.id != w.id || string(a.doc) != string(w.doc) {
+				t.Errorf("%s: action %d = %+v, want %+v", tt.name, i, a, w)
+			}
+		}
+	}
+}
+
+func TestESEventTime(t *testing.T) {
+	now := time.Date(2025, 10, 13, 20, 0, 0, 0, time.UTC)
+	tests := []struct {
+		doc  string
+		want time.Time
+	}{
+		{`{"@timestamp": "2025-10-13T19:59:58.25Z", "msg": "x"}`, time.Date(2025, 10, 13, 19, 59, 58, 250000000, time.UTC)},
+		{`{"@timestamp": "2025-10-13T21:59:58+02:00"}`, time.Date(2025, 10, 13, 19, 59, 58, 0, time.UTC)},
+		{`{"msg": "no timestamp"}`, now},
+		{`{"@timestamp": "yesterday"}`, now},
+		{`{"@timestamp": 1760385600}`, now},
+		{`not json`, now},
+	}
+	for _, tt := range tests {
+		if got := esEventTime([]byte(tt.doc), now); !got.Equal(tt.want) {
+			t.Errorf("%s: %v, want %v", tt.doc, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+// AI-assisted code
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+// Encrypted logsets only take entries their clients encrypted, wrapped in
+// an envelope:
+//
+//	{"alg": "...", "key_id": "...", "nonce": "<base64>", "ciphertext": "<base64>"}
+//
+// The envelope is stored as it was sent. It's checked for shape only, so a
+// client that forgot to encrypt is refused instead of leaking plaintext;
+// nothing else may ride along in it.
+
+var errNotEnvelope = errors.New("entries for an encrypted logset must be encrypted envelopes")
+
+type envelope struct {
+	Alg        string `json:"alg"`
+	KeyID      string `json:"key_id"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+func checkEnvelope(data []byte) error {
+	var e envelope
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if dec.Decode(&e) != nil || dec.More() {
+		return errNotEnvelope
+	}
+	if e.Alg == "" || e.KeyID == "" || e.Nonce == "" || e.Ciphertext == "" {
+		return errNotEnvelope
+	}
+	if _, err := base64.StdEncoding.DecodeString(e.Nonce); err != nil {
+		return errNotEnvelope
+	}
+	if _, err := base64.StdEncoding.DecodeString(e.Ciphertext); err != nil {
+		return errNotEnvelope
+	}
+	return nil
+}
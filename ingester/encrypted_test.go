@@ -0,0 +1,27 @@
+// AI-assisted code
+package main
+
+import "testing"
+
+func TestCheckEnvelope(t *testing.T) {
+	tests := []struct {
+		data string
+		ok   bool
+	}{
+		{`{"alg": "xchacha20poly1305-argon2id", "key_id": "3f2a", "nonce": "AAEC", "ciphertext": "c2VjcmV0"}`, true},
+		{`{"perc": 93.1}`, false},
+		{`{"alg": "a", "key_id": "k", "nonce": "AAEC", "ciphertext": "c2VjcmV0", "perc": 93.1}`, false},
+		{`{"alg": "a", "key_id": "k", "nonce": "AAEC", "ciphertext": "not base64!"}`, false},
+		{`{"alg": "a", "key_id": "", "nonce": "AAEC", "ciphertext": "c2VjcmV0"}`, false},
+		{`"plain text"`, false},
+		{`{"alg": "a", "key_id": "k", "nonce": "AAEC", "ciphertext": "c2VjcmV0"} {}`, false},
+	}
+	for _, tt := range tests {
+		if err := checkEnvelope([]byte(tt.data)); (err == nil) != tt.ok {
+			t.Errorf("%s: got %v", tt.data, err)
+		}
+	}
+	if !refused(errNotEnvelope) {
+		t.Error("envelope errors aren't refusals")
+	}
+}
@@ -0,0 +1,285 @@
+// AI-assisted code
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Every stored entry's fields are noted in memory and flushed to the
+// logset's field catalog every 15 seconds. Counts are counters and types a
+// set, so flushes from several ingesters add up. first_seen, min_value and
+// max_value only move through conditional updates, which are rare once a
+// field's range is known.
+//
+// Paths join object keys with dots; array elements add [] to their array's
+// path, so {"tags": [{"k": 1}]} gives tags, tags[] and tags[].k.
+
+const (
+	fieldFlush = 15 * time.Second
+	// maxCatalogFields stops entries with generated keys from growing a
+	// catalog without bound.
+	maxCatalogFields = 500
+	maxFieldDepth    = 16
+)
+
+type fieldStats struct {
+	types     map[string]bool
+	count     int64
+	first     time.Time
+	last      time.Time
+	hasNumber bool
+	min, max  float64
+}
+
+// storedField is what the catalog is known to hold for a field.
+type storedField struct {
+	min, max *float64
+}
+
+var (
+	fieldMu      sync.Mutex
+	fieldPending = map[logsetKey]map[string]*fieldStats{}
+	// fieldStored is only touched by the flush loop, and only keeps the
+	// logsets flushed last time.
+	fieldStored = map[logsetKey]map[string]*storedField{}
+)
+
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case json.Number, float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
+
+// collectFields adds the fields under v to stats.
+func collectFields(stats map[string]*fieldStats, prefix string, v interface{}, depth int, now time.Time) {
+	if depth > maxFieldDepth {
+		return
+	}
+	note := func(path string, child interface{}) {
+		st := stats[path]
+		if st == nil {
+			if len(stats) >= maxCatalogFields {
+				return
+			}
+			st = &fieldStats{types: map[string]bool{}, first: now, last: now}
+			stats[path] = st
+		}
+		st.types[jsonType(child)] = true
+		st.count++
+		if now.Before(st.first) {
+			st.first = now
+		}
+		if now.After(st.last) {
+			st.last = now
+		}
+		if f, ok := alertNumber(child); ok && jsonType(child) == "number" {
+			if !st.hasNumber || f < st.min {
+				st.min = f
+			}
+			if !st.hasNumber || f > st.max {
+				st.max = f
+			}
+			st.hasNumber = true
+		}
+		collectFields(stats, path, child, depth+1, now)
+	}
+
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, child := range x {
+			path := k
+			if prefix != "" {
+				path = prefix + "." + k
+			}
+			note(path, child)
+		}
+	case []interface{}:
+		if prefix == "" {
+			return
+		}
+		for _, child := range x {
+			note(prefix+"[]", child)
+		}
+	}
+}
+
+// recordFields notes a stored entry's fields. Entries that aren't JSON
+// objects have none. Fields are seen at the entry's recv_time, so
+// first_seen is never later than the oldest entry recorded with the field.
+func recordFields(userID gocql.UUID, logID string, recvTime time.Time, data []byte) {
+	v, ok := decodeEntry(data).(map[string]interface{})
+	if !ok {
+		return
+	}
+	key := logsetKey{userID, logID}
+	fieldMu.Lock()
+	defer fieldMu.Unlock()
+	stats := fieldPending[key]
+	if stats == nil {
+		stats = map[string]*fieldStats{}
+		fieldPending[key] = stats
+	}
+	collectFields(stats, "", v, 0, recvTime)
+}
+
+// storedFields loads what the catalog holds for a logset the first time
+// it's flushed.
+func storedFields(key logsetKey) (map[string]*storedField, error) {
+	if stored := fieldStored[key]; stored != nil {
+		return stored, nil
+	}
+
+	stored := map[string]*storedField{}
+	iter := session.Query(
+		`SELECT path, min_value, max_value FROM logset_fields WHERE user_id = ? AND log_id = ?`,
+		key.userID, key.logID,
+	).Iter()
+	var path string
+	var min, max *float64
+	for iter.Scan(&path, &min, &max) {
+		stored[path] = &storedField{min: min, max: max}
+		min, max = nil, nil
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	fieldStored[key] = stored
+	return stored, nil
+}
+
+// casFieldBound moves min_value or max_value to value if it's beyond the
+// stored one, returning the stored bound afterwards.
+func casFieldBound(key logsetKey, path, col string, known *float64, value float64) *float64 {
+	beyond := func(a, b float64) bool {
+		if col == "min_value" {
+			return a < b
+		}
+		return a > b
+	}
+	for i := 0; i < 3; i++ {
+		if known != nil && !beyond(value, *known) {
+			return known
+		}
+		var q *gocql.Query
+		if known == nil {
+			q = session.Query(
+				`UPDATE logset_fields SET `+col+` = ? WHERE user_id = ? AND log_id = ? AND path = ? IF `+col+` = null`,
+				value, key.userID, key.logID, path,
+			)
+		} else {
+			q = session.Query(
+				`UPDATE logset_fields SET `+col+` = ? WHERE user_id = ? AND log_id = ? AND path = ? IF `+col+` = ?`,
+				value, key.userID, key.logID, path, *known,
+			)
+		}
+		cur := map[string]interface{}{}
+		applied, err := q.MapScanCAS(cur)
+		if err != nil {
+			log.Println("field catalog:", err)
+			return known
+		}
+		if applied {
+			return &value
+		}
+		known = nil
+		if f, ok := cur[col].(float64); ok {
+			known = &f
+		}
+	}
+	return known
+}
+
+func flushFieldStats(key logsetKey, path string, st *fieldStats, sf *storedField, isNew bool) error {
+	types := make([]string, 0, len(st.types))
+	for t := range st.types {
+		types = append(types, t)
+	}
+	err := session.Query(
+		`UPDATE logset_fields SET types = types + ?, last_seen = ? WHERE user_id = ? AND log_id = ? AND path = ?`,
+		types, st.last, key.userID, key.logID, path,
+	).Exec()
+	if err != nil {
+		return err
+	}
+	err = session.Query(
+		`UPDATE logset_field_counts SET entries = entries + ? WHERE user_id = ? AND log_id = ? AND path = ?`,
+		st.count, key.userID, key.logID, path,
+	).Exec()
+	if err != nil {
+		return err
+	}
+
+	if isNew {
+		_, err := session.Query(
+			`UPDATE logset_fields SET first_seen = ? WHERE user_id = ? AND log_id = ? AND path = ? IF first_seen = null`,
+			st.first, key.userID, key.logID, path,
+		).MapScanCAS(map[string]interface{}{})
+		if err != nil {
+			return err
+		}
+	}
+	if st.hasNumber {
+		sf.min = casFieldBound(key, path, "min_value", sf.min, st.min)
+		sf.max = casFieldBound(key, path, "max_value", sf.max, st.max)
+	}
+	return nil
+}
+
+// flushFields writes out everything noted since the last flush. Stats that
+// fail to write are dropped; the catalog is a summary, not a ledger.
+func flushFields() {
+	fieldMu.Lock()
+	pending := fieldPending
+	fieldPending = map[logsetKey]map[string]*fieldStats{}
+	fieldMu.Unlock()
+
+	for key := range fieldStored {
+		if pending[key] == nil {
+			delete(fieldStored, key)
+		}
+	}
+	for key, paths := range pending {
+		stored, err := storedFields(key)
+		if err != nil {
+			log.Println("field catalog:", err)
+			continue
+		}
+		for path, st := range paths {
+			sf := stored[path]
+			isNew := sf == nil
+			if isNew {
+				if len(stored) >= maxCatalogFields {
+					continue
+				}
+				sf = &storedField{}
+				stored[path] = sf
+			}
+			if err := flushFieldStats(key, path, st, sf, isNew); err != nil {
+				log.Println("field catalog:", err)
+			}
+		}
+	}
+}
+
+func startFieldCatalog() {
+	go func() {
+		for range time.Tick(fieldFlush) {
+			flushFields()
+		}
+	}()
+}
@@ -0,0 +1,73 @@
+// AI-assisted code
+package main
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestCollectFields(t *testing.T) {
+	t1 := time.Date(2025, 10, 13, 20, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Minute)
+	stats := map[string]*fieldStats{}
+	for i, entry := range []string{
+		`{"perc": 93.1, "host": "laptop", "tags": [{"k": 1}, {"k": "x"}], "disk": {"free": null}}`,
+		`{"perc": "high", "host": "nas", "tags": []}`,
+		`{"perc": -2}`,
+	} {
+		// Entries needn't arrive in time order.
+		now := t2
+		if i == 1 {
+			now = t1
+		}
+		collectFields(stats, "", decodeEntry([]byte(entry)), 0, now)
+	}
+
+	got := map[string]string{}
+	for path, st := range stats {
+		var types []string
+		for ty := range st.types {
+			types = append(types, ty)
+		}
+		sort.Strings(types)
+		s := fmt.Sprintf("%v n=%d", types, st.count)
+		if st.hasNumber {
+			s += fmt.Sprintf(" %g..%g", st.min, st.max)
+		}
+		got[path] = s
+	}
+	want := map[string]string{
+		"perc":      "[number string] n=3 -2..93.1",
+		"host":      "[string] n=2",
+		"tags":      "[array] n=2",
+		"tags[]":    "[object] n=2",
+		"tags[].k":  "[number string] n=2 1..1",
+		"disk":      "[object] n=1",
+		"disk.free": "[null] n=1",
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %v", got)
+	}
+	for path, w := range want {
+		if got[path] != w {
+			t.Errorf("%s: got %q, want %q", path, got[path], w)
+		}
+	}
+	if st := stats["perc"]; !st.first.Equal(t1) || !st.last.Equal(t2) {
+		t.Errorf("perc seen %v..%v", st.first, st.last)
+	}
+}
+
+func TestCollectFieldsLimit(t *testing.T) {
+	stats := map[string]*fieldStats{}
+	entry := map[string]interface{}{}
+	for i := 0; i < maxCatalogFields+50; i++ {
+		entry[fmt.Sprint("k", i)] = i
+	}
+	collectFields(stats, "", entry, 0, time.Now())
+	if len(stats) != maxCatalogFields {
+		t.Errorf("%d fields, want %d", len(stats), maxCatalogFields)
+	}
+}
@@ -0,0 +1,385 @@
+// AI-assisted code
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gocql/gocql"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Fluentd Forward protocol listeners for Fluent Bit and Fluentd's forward
+// output. Message, Forward, PackedForward and CompressedPackedForward modes
+// are accepted, and chunks are acknowledged once stored. The tag is the
+// logset.
+//
+// The listener's token is the shared key: senders prove they know it with
+// the HELO/PING/PONG handshake before sending anything. `handshake=false`
+// skips that for trusted networks, like the syslog listeners.
+
+const maxForwardSize = 16 << 20
+
+// fluentEventTime is Forward's EventTime extension: big-endian seconds and
+// nanoseconds.
+type fluentEventTime time.Time
+
+func (t *fluentEventTime) MarshalMsgpack() ([]byte, error) {
+	b := make([]byte, 8)
+	tm := time.Time(*t)
+	binary.BigEndian.PutUint32(b, uint32(tm.Unix()))
+	binary.BigEndian.PutUint32(b[4:], uint32(tm.Nanosecond()))
+	return b, nil
+}
+
+func (t *fluentEventTime) UnmarshalMsgpack(b []byte) error {
+	if len(b) != 8 {
+		return errors.New("invalid EventTime")
+	}
+	sec := binary.BigEndian.Uint32(b)
+	nsec := binary.BigEndian.Uint32(b[4:])
+	*t = fluentEventTime(time.Unix(int64(sec), int64(nsec)))
+	return nil
+}
+
+func init() {
+	msgpack.RegisterExt(0, (*fluentEventTime)(nil))
+}
+
+// forwardTime converts an entry time, which is either EventTime or integer
+// (sometimes float) seconds. Decoders use loose interface decoding, so all
+// numbers arrive as int64, uint64 or float64.
+func forwardTime(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case *fluentEventTime:
+		return time.Time(*t), true
+	case fluentEventTime:
+		return time.Time(t), true
+	case int64:
+		return time.Unix(t, 0), true
+	case uint64:
+		return time.Unix(int64(t), 0), true
+	case float64:
+		return time.UnixMicro(int64(t * 1e6)), true
+	}
+	return time.Time{}, false
+}
+
+// forwardString accepts msgpack str or bin, as Fluentd uses both.
+func forwardString(v interface{}) (string, bool) {
+	switch s := v.(type) {
+	case string:
+		return s, true
+	case []byte:
+		return string(s), true
+	}
+	return "", false
+}
+
+// jsonSafe converts binary strings in a record to text so they don't end up
+// base64 encoded.
+func jsonSafe(v interface{}) interface{} {
+	switch x := v.(type) {
+	case []byte:
+		if utf8.Valid(x) {
+			return string(x)
+		}
+	case map[string]interface{}:
+		for k, e := range x {
+			x[k] = jsonSafe(e)
+		}
+	case []interface{}:
+		for i, e := range x {
+			x[i] = jsonSafe(e)
+		}
+	case *fluentEventTime:
+		return time.Time(*x)
+	}
+	return v
+}
+
+type forwardEntry struct {
+	Time   time.Time
+	Record map[string]interface{}
+}
+
+// decodeForwardEntries decodes a PackedForward stream of [time, record]
+// arrays, gunzipping it first for CompressedPackedForward.
+func decodeForwardEntries(b []byte, compressed bool) ([]forwardEntry, error) {
+	var r io.Reader = bytes.NewReader(b)
+	if compressed {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = io.LimitReader(gz, maxForwardSize)
+	}
+
+	dec := msgpack.NewDecoder(r)
+	dec.UseLooseInterfaceDecoding(true)
+	var entries []forwardEntry
+	for {
+		v, err := dec.DecodeInterface()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		e, err := parseForwardEntry(v)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+}
+
+func parseForwardEntry(v interface{}) (forwardEntry, error) {
+	arr, ok := v.([]interface{})
+	if !ok || len(arr) < 2 {
+		return forwardEntry{}, errors.New("invalid entry")
+	}
+	t, ok := forwardTime(arr[0])
+	if !ok {
+		return forwardEntry{}, errors.New("invalid entry time")
+	}
+	rec, ok := arr[1].(map[string]interface{})
+	if !ok {
+		return forwardEntry{}, errors.New("invalid record")
+	}
+	return forwardEntry{t, rec}, nil
+}
+
+// parseForwardMessage decodes one event stream message in any of the four
+// modes and returns its tag, entries and options.
+func parseForwardMessage(v interface{}) (string, []forwardEntry, map[string]interface{}, error) {
+	arr, ok := v.([]interface{})
+	if !ok || len(arr) < 2 {
+		return "", nil, nil, errors.New("invalid message")
+	}
+	tag, ok := forwardString(arr[0])
+	if !ok || tag == "" {
+		return "", nil, nil, errors.New("invalid tag")
+	}
+
+	var entries []forwardEntry
+	var opts map[string]interface{}
+	switch second := arr[1].(type) {
+	case []interface{}:
+		// Forward: [tag, [[time, record], ...], option]
+		for _, e := range second {
+			entry, err := parseForwardEntry(e)
+			if err != nil {
+				return "", nil, nil, err
+			}
+			entries = append(entries, entry)
+		}
+		if len(arr) > 2 {
+			opts, _ = arr[2].(map[string]interface{})
+		}
+	case []byte, string:
+		// PackedForward and CompressedPackedForward: [tag, entries, option]
+		if len(arr) > 2 {
+			opts, _ = arr[2].(map[string]interface{})
+		}
+		packed, _ := forwardString(second)
+		compressed, _ := forwardString(opts["compressed"])
+		var err error
+		entries, err = decodeForwardEntries([]byte(packed), compressed == "gzip")
+		if err != nil {
+			return "", nil, nil, err
+		}
+	default:
+		// Message: [tag, time, record, option]
+		if len(arr) < 3 {
+			return "", nil, nil, errors.New("invalid message")
+		}
+		entry, err := parseForwardEntry(arr[1:3])
+		if err != nil {
+			return "", nil, nil, err
+		}
+		entries = append(entries, entry)
+		if len(arr) > 3 {
+			opts, _ = arr[3].(map[string]interface{})
+		}
+	}
+	return tag, entries, opts, nil
+}
+
+func storeForward(userID gocql.UUID, tag string, entries []forwardEntry) error {
+	logID, err := resolveLogset(userID, tag)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		data, err := json.Marshal(jsonSafe(e.Record))
+		if err != nil {
+			return err
+		}
+		if e.Time.Unix() <= 0 {
+			e.Time = time.Now()
+		}
+		err = insertLog(userID, logID, listenerDedup.next(logID, e.Time), data)
+		if refused(err) {
+			// Fluentd would resend the chunk forever; drop the entry.
+			log.Println("forward:", err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func forwardDigest(salt, hostname string, nonce []byte, key string) string {
+	h := sha512.New()
+	h.Write([]byte(salt))
+	h.Write([]byte(hostname))
+	h.Write(nonce)
+	h.Write([]byte(key))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// forwardHandshake runs the shared-key handshake: HELO with a nonce, the
+// client's PING proving it knows the key, and our PONG proving we do.
+func forwardHandshake(dec *msgpack.Decoder, enc *msgpack.Encoder, key, hostname string) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	err := enc.Encode([]interface{}{"HELO", map[string]interface{}{
+		"nonce":     nonce,
+		"auth":      "",
+		"keepalive": true,
+	}})
+	if err != nil {
+		return err
+	}
+
+	v, err := dec.DecodeInterface()
+	if err != nil {
+		return err
+	}
+	ping, ok := v.([]interface{})
+	if !ok || len(ping) < 4 {
+		return errors.New("invalid PING")
+	}
+	var fields [4]string
+	for i := range fields {
+		fields[i], _ = forwardString(ping[i])
+	}
+	if fields[0] != "PING" {
+		return errors.New("invalid PING")
+	}
+	clientHost, salt, digest := fields[1], fields[2], fields[3]
+
+	want := forwardDigest(salt, clientHost, nonce, key)
+	if subtle.ConstantTimeCompare([]byte(digest), []byte(want)) != 1 {
+		enc.Encode([]interface{}{"PONG", false, "shared_key mismatch", hostname, ""})
+		return errors.New("shared key mismatch from " + clientHost)
+	}
+	return enc.Encode([]interface{}{"PONG", true, "", hostname, forwardDigest(salt, hostname, nonce, key)})
+}
+
+func startForwardListeners(specs []listenerSpec) error {
+	for _, spec := range specs {
+		if spec.opts.Get("token") == "" {
+			return fmt.Errorf("forward: %s://%s needs a token", spec.network, spec.addr)
+		}
+		ln, err := net.Listen("tcp", spec.addr)
+		if err != nil {
+			return err
+		}
+		switch spec.network {
+		case "tcp":
+		case "tls":
+			cfg, err := spec.tlsConfig()
+			if err != nil {
+				return err
+			}
+			ln = tls.NewListener(ln, cfg)
+		default:
+			return fmt.Errorf("forward: unsupported listener %q", spec.network)
+		}
+		go serveForward(spec, ln)
+		log.Printf("forward listening on %s://%s", spec.network, spec.addr)
+	}
+	return nil
+}
+
+func serveForward(spec listenerSpec, ln net.Listener) {
+	hostname, _ := os.Hostname()
+	for {
+		c, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Println("forward accept:", err)
+			continue
+		}
+		go func() {
+			defer c.Close()
+			if err := handleForwardConn(spec, c, hostname); err != nil && err != io.EOF {
+				log.Println("forward:", err)
+			}
+		}()
+	}
+}
+
+func handleForwardConn(spec listenerSpec, c net.Conn, hostname string) error {
+	token := spec.opts.Get("token")
+	dec := msgpack.NewDecoder(bufio.NewReader(idleConn{c}))
+	dec.UseLooseInterfaceDecoding(true)
+	enc := msgpack.NewEncoder(c)
+
+	if spec.opts.Get("handshake") != "false" {
+		if err := forwardHandshake(dec, enc, token, hostname); err != nil {
+			return err
+		}
+	}
+	userID, err := authenticateCached(token)
+	if err != nil {
+		return errors.New("invalid token")
+	}
+
+	for {
+		v, err := dec.DecodeInterface()
+		if err != nil {
+			return err
+		}
+		tag, entries, opts, err := parseForwardMessage(v)
+		if err != nil {
+			return err
+		}
+		// Without an ack the sender retries the chunk, so a failed insert
+		// just drops the connection. Entries stored before the failure
+		// may then be stored twice.
+		if err := storeForward(userID, tag, entries); err != nil {
+			return err
+		}
+		if chunk, ok := forwardString(opts["chunk"]); ok && chunk != "" {
+			if err := enc.Encode(map[string]string{"ack": chunk}); err != nil {
+				return err
+			}
+		}
+	}
+}
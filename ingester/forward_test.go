@@ -0,0 +1,152 @@
+// AI-assisted code
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestParseForwardMessage(t *testing.T) {
+	et := fluentEventTime(time.Unix(1760385600, 5))
+	var packed bytes.Buffer
+	enc := msgpack.NewEncoder(&packed)
+	if err := enc.Encode([]interface{}{&et, map[string]interface{}{"log": []byte("one")}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode([]interface{}{1760385601, map[string]interface{}{"log": "two"}}); err != nil {
+		t.Fatal(err)
+	}
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(packed.Bytes())
+	w.Close()
+
+	tests := []struct {
+		name    string
+		msg     []interface{}
+		entries int
+		chunk   string
+	}{
+		{"message", []interface{}{"app", 1760385600, map[string]interface{}{"log": "one"}}, 1, ""},
+		{"forward", []interface{}{"app", []interface{}{
+			[]interface{}{&et, map[string]interface{}{"log": "one"}},
+		}, map[string]interface{}{"chunk": "c1"}}, 1, "c1"},
+		{"packed forward", []interface{}{"app", packed.Bytes()}, 2, ""},
+		{"compressed packed forward", []interface{}{"app", gz.Bytes(),
+			map[string]interface{}{"compressed": "gzip", "chunk": "c2"}}, 2, "c2"},
+	}
+	for _, tt := range tests {
+		b, err := msgpack.Marshal(tt.msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec := msgpack.NewDecoder(bytes.NewReader(b))
+		dec.UseLooseInterfaceDecoding(true)
+		v, err := dec.DecodeInterface()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tag, entries, opts, err := parseForwardMessage(v)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if tag != "app" || len(entries) != tt.entries {
+			t.Fatalf("%s: got tag %q and %d entries", tt.name, tag, len(entries))
+		}
+		if chunk, _ := forwardString(opts["chunk"]); chunk != tt.chunk {
+			t.Errorf("%s: chunk = %q, want %q", tt.name, chunk, tt.chunk)
+		}
+		if got := entries[0].Time; !got.Equal(time.Unix(1760385600, 0)) && !got.Equal(time.Unix(1760385600, 5)) {
+			t.Errorf("%s: time = %v", tt.name, got)
+		}
+		if rec := jsonSafe(entries[0].Record).(map[string]interface{}); rec["log"] != "one" {
+			t.Errorf("%s: record = %v", tt.name, rec)
+		}
+	}
+}
+
+// forwardClient runs the sender's side of the handshake and returns the
+// server's PONG.
+func forwardClient(t *testing.T, c net.Conn, key string) []interface{} {
+	t.Helper()
+	dec := msgpack.NewDecoder(c)
+	enc := msgpack.NewEncoder(c)
+
+	var helo []interface{}
+	if err := dec.Decode(&helo); err != nil {
+		t.Fatal(err)
+	}
+	if len(helo) != 2 || helo[0] != "HELO" {
+		t.Fatalf("unexpected HELO %v", helo)
+	}
+	opts, _ := helo[1].(map[string]interface{})
+	nonce, _ := opts["nonce"].([]byte)
+	if len(nonce) == 0 {
+		t.Fatalf("HELO without nonce: %v", helo)
+	}
+
+	ping := []interface{}{"PING", "client", "salt", forwardDigest("salt", "client", nonce, key), "", ""}
+	if err := enc.Encode(ping); err != nil {
+		t.Fatal(err)
+	}
+	var pong []interface{}
+	if err := dec.Decode(&pong); err != nil {
+		t.Fatal(err)
+	}
+	if len(pong) != 5 || pong[0] != "PONG" {
+		t.Fatalf("unexpected PONG %v", pong)
+	}
+	if pong[1] == true && pong[4] != forwardDigest("salt", "server", nonce, key) {
+		t.Fatalf("server digest doesn't prove it knows the key: %v", pong)
+	}
+	return pong
+}
+
+func TestForwardHandshake(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		clientKey string
+		ok        bool
+	}{
+		{"matching key", "token", true},
+		{"wrong key", "guess", false},
+	} {
+		// A real socket rather than net.Pipe: msgpack issues zero-length
+		// writes, which block on a pipe until the other side reads.
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan error, 1)
+		go func() {
+			server, err := ln.Accept()
+			if err != nil {
+				done <- err
+				return
+			}
+			defer server.Close()
+			done <- forwardHandshake(msgpack.NewDecoder(server), msgpack.NewEncoder(server), "token", "server")
+		}()
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pong := forwardClient(t, client, tt.clientKey)
+		client.Close()
+		ln.Close()
+		err = <-done
+
+		if pong[1] != tt.ok {
+			t.Errorf("%s: PONG auth result = %v, want %v", tt.name, pong[1], tt.ok)
+		}
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: handshake error = %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+// AI-assisted code
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GELF listeners for Docker's gelf logging driver and Graylog senders.
+// UDP datagrams may be chunked and zlib or gzip compressed; TCP messages are
+// null-delimited and uncompressed.
+//
+// Listeners take the usual token and logset options, plus logset_field to
+// route by a message field such as `_container_name`. Senders can also set
+// `_librelog_token` and `_librelog_logset`, which are removed before storage.
+
+// Chunked messages are reassembled from unauthenticated UDP, so both each
+// message and everything pending are capped.
+const (
+	maxGELFSize         = 1 << 20
+	maxGELFChunks       = 128
+	gelfChunkTimeout    = 5 * time.Second
+	maxGELFPending      = 1000
+	maxGELFPendingBytes = 32 << 20
+)
+
+var gelfChunkMagic = []byte{0x1e, 0x0f}
+
+type gelfMessage struct {
+	Host         string                 `json:"host"`
+	ShortMessage string                 `json:"short_message"`
+	FullMessage  string                 `json:"full_message,omitempty"`
+	Level        *int                   `json:"level,omitempty"`
+	Severity     string                 `json:"severity,omitempty"`
+	Timestamp    *time.Time             `json:"timestamp,omitempty"`
+	Fields       map[string]interface{} `json:"fields,omitempty"`
+}
+
+// parseGELF decodes a GELF payload. Additional fields keep their values but
+// lose the leading underscore.
+func parseGELF(b []byte) (gelfMessage, error) {
+	var m gelfMessage
+	var raw map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return m, err
+	}
+
+	m.Host, _ = raw["host"].(string)
+	m.ShortMessage, _ = raw["short_message"].(string)
+	m.FullMessage, _ = raw["full_message"].(string)
+	if n, ok := raw["level"].(json.Number); ok {
+		if level, err := strconv.Atoi(n.String()); err == nil {
+			m.Level = &level
+			if level >= 0 && level < len(syslogSeverities) {
+				m.Severity = syslogSeverities[level]
+			}
+		}
+	}
+	if n, ok := raw["timestamp"].(json.Number); ok {
+		if ts, err := strconv.ParseFloat(n.String(), 64); err == nil && ts > 0 {
+			t := time.UnixMicro(int64(ts * 1e6)).UTC()
+			m.Timestamp = &t
+		}
+	}
+	for k, v := range raw {
+		if len(k) > 1 && k[0] == '_' {
+			if m.Fields == nil {
+				m.Fields = map[string]interface{}{}
+			}
+			m.Fields[k[1:]] = v
+		}
+	}
+	return m, nil
+}
+
+// field looks up a GELF field by its wire name, e.g. `host` or
+// `_container_name`. Additional fields can be named without the underscore.
+func (m gelfMessage) field(name string) string {
+	switch name {
+	case "host":
+		return m.Host
+	case "short_message":
+		return m.ShortMessage
+	}
+	v, ok := m.Fields[strings.TrimPrefix(name, "_")]
+	if !ok {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+// decompressGELF undoes zlib or gzip compression, recognised by their magic
+// bytes. Anything else is returned as-is.
+func decompressGELF(b []byte) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	switch {
+	case len(b) > 2 && b[0] == 0x1f && b[1] == 0x8b:
+		r, err = gzip.NewReader(bytes.NewReader(b))
+	case len(b) > 2 && b[0] == 0x78:
+		r, err = zlib.NewReader(bytes.NewReader(b))
+	default:
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, maxGELFSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxGELFSize {
+		return nil, errors.New("message too long")
+	}
+	return out, nil
+}
+
+// gelfChunks reassembles chunked UDP messages. Each UDP listener has its own
+// and only touches it from its read loop, so there's no locking.
+type gelfChunks struct {
+	pending   map[[8]byte]*gelfPending
+	bytes     int
+	lastSweep time.Time
+}
+
+type gelfPending struct {
+	parts    [][]byte
+	received int
+	size     int
+	first    time.Time
+}
+
+func (c *gelfChunks) drop(id [8]byte) {
+	if p := c.pending[id]; p != nil {
+		c.bytes -= p.size
+		delete(c.pending, id)
+	}
+}
+
+// add stores one chunk and returns the reassembled message once all of its
+// chunks have arrived. Incomplete messages are dropped after
+// gelfChunkTimeout.
+func (c *gelfChunks) add(b []byte, now time.Time) ([]byte, error) {
+	if len(b) < 12 {
+		return nil, errors.New("short chunk")
+	}
+	var id [8]byte
+	copy(id[:], b[2:10])
+	seq, count := int(b[10]), int(b[11])
+	if count == 0 || count > maxGELFChunks || seq >= count {
+		return nil, fmt.Errorf("invalid chunk %d/%d", seq, count)
+	}
+
+	if c.pending == nil {
+		c.pending = map[[8]byte]*gelfPending{}
+	}
+	if now.Sub(c.lastSweep) > time.Second {
+		for k, p := range c.pending {
+			if now.Sub(p.first) > gelfChunkTimeout {
+				c.drop(k)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	p := c.pending[id]
+	if p == nil {
+		if len(c.pending) >= maxGELFPending {
+			return nil, errors.New("too many incomplete messages")
+		}
+		p = &gelfPending{parts: make([][]byte, count), first: now}
+		c.pending[id] = p
+	}
+	if len(p.parts) != count {
+		c.drop(id)
+		return nil, errors.New("chunk count mismatch")
+	}
+	if p.parts[seq] == nil {
+		chunk := b[12:]
+		if p.size+len(chunk) > maxGELFSize {
+			c.drop(id)
+			return nil, errors.New("chunked message too long")
+		}
+		if c.bytes+len(chunk) > maxGELFPendingBytes {
+			return nil, errors.New("too many incomplete messages")
+		}
+		p.parts[seq] = bytes.Clone(chunk)
+		p.received++
+		p.size += len(chunk)
+		c.bytes += len(chunk)
+	}
+	if p.received < count {
+		return nil, nil
+	}
+
+	c.drop(id)
+	return bytes.Join(p.parts, nil), nil
+}
+
+// ingestGELF stores one complete, decompressed GELF message. Senders often
+// send whole-second timestamps, so times go through listenerDedup.
+func ingestGELF(spec listenerSpec, raw []byte) error {
+	m, err := parseGELF(raw)
+	if err != nil {
+		return err
+	}
+
+	token := spec.opts.Get("token")
+	if t := m.field("_librelog_token"); t != "" {
+		token = t
+	}
+	logset := m.field("_librelog_logset")
+	if logset == "" && spec.opts.Get("logset_field") != "" {
+		logset = m.field(spec.opts.Get("logset_field"))
+	}
+	if logset == "" {
+		logset = spec.opts.Get("logset")
+	}
+	if logset == "" {
+		logset = "gelf"
+	}
+	delete(m.Fields, "librelog_token")
+	delete(m.Fields, "librelog_logset")
+	if token == "" {
+		return errors.New("no token")
+	}
+
+	userID, err := authenticateCached(token)
+	if err != nil {
+		return errors.New("invalid token")
+	}
+	logID, err := resolveLogset(userID, logset)
+	if err != nil {
+		return err
+	}
+	if len(m.Fields) == 0 {
+		m.Fields = nil
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	t := time.Now()
+	if m.Timestamp != nil {
+		t = *m.Timestamp
+	}
+	return insertLog(userID, logID, listenerDedup.next(logID, t), data)
+}
+
+func startGELFListeners(specs []listenerSpec) error {
+	for _, spec := range specs {
+		switch spec.network {
+		case "udp":
+			conn, err := net.ListenPacket("udp", spec.addr)
+			if err != nil {
+				return err
+			}
+			go serveGELFUDP(spec, conn)
+		case "tcp", "tls":
+			ln, err := net.Listen("tcp", spec.addr)
+			if err != nil {
+				return err
+			}
+			if spec.network == "tls" {
+				cfg, err := spec.tlsConfig()
+				if err != nil {
+					return err
+				}
+				ln = tls.NewListener(ln, cfg)
+			}
+			go serveGELFStream(spec, ln)
+		default:
+			return fmt.Errorf("gelf: unsupported listener %q", spec.network)
+		}
+		log.Printf("gelf listening on %s://%s", spec.network, spec.addr)
+	}
+	return nil
+}
+
+func serveGELFUDP(spec listenerSpec, conn net.PacketConn) {
+	buf := make([]byte, 64*1024)
+	var chunks gelfChunks
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Println("gelf read:", err)
+			continue
+		}
+
+		msg := buf[:n]
+		if bytes.HasPrefix(msg, gelfChunkMagic) {
+			msg, err = chunks.add(msg, time.Now())
+			if err != nil {
+				log.Println("gelf:", err)
+				continue
+			}
+			if msg == nil {
+				continue
+			}
+		}
+		if msg, err = decompressGELF(msg); err == nil {
+			err = ingestGELF(spec, msg)
+		}
+		if err != nil {
+			log.Println("gelf:", err)
+		}
+	}
+}
+
+func serveGELFStream(spec listenerSpec, ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Println("gelf accept:", err)
+			continue
+		}
+		go func() {
+			defer c.Close()
+			sc := bufio.NewScanner(idleConn{c})
+			sc.Buffer(make([]byte, 0, 64*1024), maxGELFSize)
+			sc.Split(splitNull)
+			for sc.Scan() {
+				msg := bytes.TrimSpace(sc.Bytes())
+				if len(msg) == 0 {
+					continue
+				}
+				if err := ingestGELF(spec, msg); err != nil {
+					log.Println("gelf:", err)
+				}
+			}
+			if err := sc.Err(); err != nil {
+				log.Println("gelf read:", err)
+			}
+		}()
+	}
+}
+
+// splitNull is a bufio.SplitFunc for null-delimited messages.
+func splitNull(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,178 @@
+// AI-assisted code
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGELF(t *testing.T) {
+	level := 3
+	stamp := time.Date(2025, 10, 13, 20, 0, 0, 250000000, time.UTC)
+	tests := []struct {
+		name    string
+		raw     string
+		want    gelfMessage
+		wantErr bool
+	}{
+		{
+			name: "full",
+			raw:  `{"version": "1.1", "host": "nas", "short_message": "disk low", "full_message": "details", "level": 3, "timestamp": 1760385600.25, "_container_name": "db", "_free": 5}`,
+			want: gelfMessage{Host: "nas", ShortMessage: "disk low", FullMessage: "details", Level: &level, Severity: "err",
+				Timestamp: &stamp, Fields: map[string]interface{}{"container_name": "db", "free": json.Number("5")}},
+		},
+		{
+			// Messages without a usable timestamp are stored at receive
+			// time.
+			name: "no timestamp",
+			raw:  `{"host": "nas", "short_message": "x", "_": "not a field"}`,
+			want: gelfMessage{Host: "nas", ShortMessage: "x"},
+		},
+		{name: "zero timestamp", raw: `{"short_message": "x", "timestamp": 0}`, want: gelfMessage{ShortMessage: "x"}},
+		{name: "string timestamp", raw: `{"short_message": "x", "timestamp": "1760385600"}`, want: gelfMessage{ShortMessage: "x"}},
+		{
+			name: "level out of range",
+			raw:  `{"short_message": "x", "level": 9}`,
+			want: gelfMessage{ShortMessage: "x", Level: func() *int { l := 9; return &l }()},
+		},
+		{name: "not json", raw: `{"short_message": `, wantErr: true},
+		{name: "not an object", raw: `["x"]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseGELF([]byte(tt.raw))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: parsed as %+v", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", tt.name, got, tt.want)
+		}
+	}
+
+	m, _ := parseGELF([]byte(`{"host": "nas", "_container_name": "db", "_n": 5}`))
+	for name, want := range map[string]string{"host": "nas", "_container_name": "db", "container_name": "db", "_n": "5", "_missing": ""} {
+		if got := m.field(name); got != want {
+			t.Errorf("field %s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestDecompressGELF(t *testing.T) {
+	msg := []byte(`{"short_message": "x"}`)
+	var gz, zl bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(msg)
+	gw.Close()
+	zw := zlib.NewWriter(&zl)
+	zw.Write(msg)
+	zw.Close()
+	var big bytes.Buffer
+	bw := gzip.NewWriter(&big)
+	bw.Write(bytes.Repeat([]byte("a"), maxGELFSize+1))
+	bw.Close()
+
+	tests := []struct {
+		name    string
+		in      []byte
+		wantErr bool
+	}{
+		{"plain", msg, false},
+		{"gzip", gz.Bytes(), false},
+		{"zlib", zl.Bytes(), false},
+		{"corrupt gzip", append([]byte{0x1f, 0x8b}, "nonsense"...), true},
+		{"truncated zlib", zl.Bytes()[:zl.Len()/2], true},
+		{"too long once decompressed", big.Bytes(), true},
+	}
+	for _, tt := range tests {
+		got, err := decompressGELF(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: decompressed to %d bytes", tt.name, len(got))
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(got, msg) {
+			t.Errorf("%s: %q, %v", tt.name, got, err)
+		}
+	}
+}
+
+// gelfChunk builds one chunk of message id.
+func gelfChunk(id byte, seq, count int, data string) []byte {
+	b := append([]byte{}, gelfChunkMagic...)
+	b = append(b, id, 0, 0, 0, 0, 0, 0, 0, byte(seq), byte(count))
+	return append(b, data...)
+}
+
+func TestGELFChunks(t *testing.T) {
+	var c gelfChunks
+	now := time.Now()
+
+	// Chunks can arrive out of order and twice.
+	for _, ch := range [][]byte{gelfChunk(1, 2, 3, "c"), gelfChunk(1, 0, 3, "a"), gelfChunk(1, 0, 3, "a")} {
+		if out, err := c.add(ch, now); out != nil || err != nil {
+			t.Fatalf("partial message: %q, %v", out, err)
+		}
+	}
+	if out, err := c.add(gelfChunk(1, 1, 3, "b"), now); string(out) != "abc" || err != nil {
+		t.Errorf("reassembled %q, %v", out, err)
+	}
+	if len(c.pending) != 0 || c.bytes != 0 {
+		t.Errorf("%d pending, %d bytes after reassembly", len(c.pending), c.bytes)
+	}
+
+	for name, ch := range map[string][]byte{
+		"short":        gelfChunk(2, 0, 1, "")[:11],
+		"zero count":   gelfChunk(2, 0, 0, "x"),
+		"seq past end": gelfChunk(2, 2, 2, "x"),
+		"too many":     gelfChunk(2, 0, maxGELFChunks+1, "x"),
+	} {
+		if _, err := c.add(ch, now); err == nil {
+			t.Errorf("%s chunk accepted", name)
+		}
+	}
+
+	c.add(gelfChunk(3, 0, 2, "a"), now)
+	if _, err := c.add(gelfChunk(3, 1, 3, "b"), now); err == nil {
+		t.Error("chunk count mismatch accepted")
+	}
+	if len(c.pending) != 0 {
+		t.Error("mismatched message still pending")
+	}
+
+	// Incomplete messages are dropped after the timeout, so a late chunk
+	// starts over.
+	c.add(gelfChunk(4, 0, 2, "a"), now)
+	later := now.Add(gelfChunkTimeout + 2*time.Second)
+	if out, err := c.add(gelfChunk(4, 1, 2, "b"), later); out != nil || err != nil {
+		t.Errorf("late chunk: %q, %v", out, err)
+	}
+	if c.bytes != 1 {
+		t.Errorf("%d bytes pending, want 1", c.bytes)
+	}
+}
+
+func TestSplitNull(t *testing.T) {
+	sc := bufio.NewScanner(strings.NewReader("{\"a\":1}\x00{\"b\":2}\x00{\"c\":3}"))
+	sc.Split(splitNull)
+	var got []string
+	for sc.Scan() {
+		got = append(got, sc.Text())
+	}
+	if want := []string{`{"a":1}`, `{"b":2}`, `{"c":3}`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("messages %q, want %q", got, want)
+	}
+}
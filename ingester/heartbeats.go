@@ -0,0 +1,227 @@
+// AI-assisted code
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Heartbeat logsets expect an entry at least every interval. Entries mark
+// them up; a ticker marks them late once the interval has passed and down
+// once the grace period has passed too. Like alert states, transitions are
+// conditional updates, so only one ingester reports each of them.
+
+const (
+	heartbeatTick = 15 * time.Second
+	// heartbeatEntryWrite throttles last_entry writes for busy logsets.
+	heartbeatEntryWrite = 15 * time.Second
+)
+
+type heartbeat struct {
+	interval   time.Duration
+	grace      time.Duration
+	status     string
+	lastEntry  time.Time
+	savedEntry time.Time
+}
+
+var (
+	heartbeatMu sync.Mutex
+	heartbeats  = map[logsetKey]*heartbeat{}
+)
+
+var heartbeatRank = map[string]int{"up": 0, "late": 1, "down": 2}
+
+// heartbeatStatus is what a heartbeat's status should be at now. Heartbeats
+// that have never had an entry stay new.
+func heartbeatStatus(lastEntry time.Time, interval, grace time.Duration, now time.Time) string {
+	switch since := now.Sub(lastEntry); {
+	case lastEntry.IsZero():
+		return "new"
+	case since <= interval:
+		return "up"
+	case since <= interval+grace:
+		return "late"
+	}
+	return "down"
+}
+
+// recordHeartbeat marks a heartbeat logset up after an entry arrives.
+func recordHeartbeat(userID gocql.UUID, logID string, now time.Time) {
+	key := logsetKey{userID, logID}
+	heartbeatMu.Lock()
+	h := heartbeats[key]
+	if h == nil {
+		heartbeatMu.Unlock()
+		return
+	}
+	h.lastEntry = now
+	old := h.status
+	write := old != "up" || now.Sub(h.savedEntry) >= heartbeatEntryWrite
+	if write {
+		h.savedEntry = now
+	}
+	snapshot := *h
+	heartbeatMu.Unlock()
+	if !write {
+		return
+	}
+
+	if old != "up" {
+		applied, err := session.Query(
+			`UPDATE heartbeats SET status = 'up', since = ?, last_entry = ? WHERE user_id = ? AND log_id = ? IF status = ?`,
+			now, now, userID, logID, old,
+		).MapScanCAS(map[string]interface{}{})
+		if err != nil {
+			log.Println("heartbeat:", err)
+			return
+		}
+		if applied {
+			heartbeatMu.Lock()
+			h.status = "up"
+			heartbeatMu.Unlock()
+			if old != "new" {
+				notifyHeartbeat(key, snapshot, "up", old)
+			}
+			return
+		}
+		// Another ingester changed the status first; just keep the
+		// entry time.
+	}
+	_, err := session.Query(
+		`UPDATE heartbeats SET last_entry = ? WHERE user_id = ? AND log_id = ? IF EXISTS`,
+		now, userID, logID,
+	).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		log.Println("heartbeat:", err)
+	}
+}
+
+// checkHeartbeats marks heartbeats late or down once their entries are
+// overdue. Only entries bring a heartbeat back up.
+func checkHeartbeats(now time.Time) {
+	heartbeatMu.Lock()
+	due := map[logsetKey]heartbeat{}
+	for key, h := range heartbeats {
+		due[key] = *h
+	}
+	heartbeatMu.Unlock()
+
+	for key, h := range due {
+		want := heartbeatStatus(h.lastEntry, h.interval, h.grace, now)
+		if h.status == "new" || heartbeatRank[want] <= heartbeatRank[h.status] {
+			continue
+		}
+		applied, err := session.Query(
+			`UPDATE heartbeats SET status = ?, since = ? WHERE user_id = ? AND log_id = ? IF status = ?`,
+			want, now, key.userID, key.logID, h.status,
+		).MapScanCAS(map[string]interface{}{})
+		if err != nil {
+			log.Println("heartbeat:", err)
+			continue
+		}
+		if !applied {
+			continue
+		}
+		heartbeatMu.Lock()
+		if cur := heartbeats[key]; cur != nil && cur.status == h.status {
+			cur.status = want
+		}
+		heartbeatMu.Unlock()
+		notifyHeartbeat(key, h, want, h.status)
+	}
+}
+
+// notifyHeartbeat sends a status change to the logset's webhooks and the
+// user's email targets.
+func notifyHeartbeat(key logsetKey, h heartbeat, status, previous string) {
+	log.Printf("heartbeat %s/%s %s", key.userID, key.logID, status)
+	body := map[string]interface{}{
+		"status":           status,
+		"previous":         previous,
+		"interval_seconds": int(h.interval / time.Second),
+		"grace_seconds":    int(h.grace / time.Second),
+	}
+	if !h.lastEntry.IsZero() {
+		body["last_entry"] = h.lastEntry
+	}
+	notifyWebhooks(key.userID, key.logID, "heartbeat", map[string]interface{}{"heartbeat": body}, nil)
+	go emailHeartbeat(key, h, status)
+}
+
+func emailHeartbeat(key logsetKey, h heartbeat, status string) {
+	if smtpConfigFromEnv() == nil {
+		return
+	}
+	name := key.logID
+	session.Query(
+		`SELECT name FROM logs_meta WHERE user_id = ? AND log_id = ?`, key.userID, key.logID,
+	).Scan(&name)
+
+	var body strings.Builder
+	switch status {
+	case "up":
+		fmt.Fprintf(&body, "The heartbeat %q is up again.\n\n", name)
+	case "late":
+		fmt.Fprintf(&body, "The heartbeat %q is late: no entry in the last %s.\n\n", name, h.interval)
+	default:
+		fmt.Fprintf(&body, "The heartbeat %q is down: no entry in the last %s.\n\n", name, h.interval+h.grace)
+	}
+	fmt.Fprintf(&body, "Logset: %s\n", key.logID)
+	if !h.lastEntry.IsZero() {
+		fmt.Fprintf(&body, "Last entry: %s\n", h.lastEntry.UTC().Format(time.RFC1123))
+	}
+	emailNotice(key.userID, "heartbeat", fmt.Sprintf("LibreLog heartbeat %s: %s", status, name), body.String())
+}
+
+// refreshHeartbeats reloads every heartbeat. A last entry newer in memory
+// than in Cassandra is kept, since those writes are throttled.
+func refreshHeartbeats() error {
+	loaded := map[logsetKey]*heartbeat{}
+	iter := session.Query(
+		`SELECT user_id, log_id, interval_seconds, grace_seconds, status, last_entry FROM heartbeats`,
+	).Iter()
+	var key logsetKey
+	var interval, grace int
+	var h heartbeat
+	for iter.Scan(&key.userID, &key.logID, &interval, &grace, &h.status, &h.lastEntry) {
+		hb := h
+		hb.interval = time.Duration(interval) * time.Second
+		hb.grace = time.Duration(grace) * time.Second
+		hb.savedEntry = hb.lastEntry
+		loaded[key] = &hb
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	heartbeatMu.Lock()
+	defer heartbeatMu.Unlock()
+	for key, h := range loaded {
+		if cur := heartbeats[key]; cur != nil && cur.lastEntry.After(h.lastEntry) {
+			h.lastEntry = cur.lastEntry
+		}
+	}
+	heartbeats = loaded
+	return nil
+}
+
+func startHeartbeatMonitor() {
+	if err := refreshHeartbeats(); err != nil {
+		log.Println("heartbeats:", err)
+	}
+	go func() {
+		for range time.Tick(heartbeatTick) {
+			if err := refreshHeartbeats(); err != nil {
+				log.Println("heartbeats:", err)
+				continue
+			}
+			checkHeartbeats(time.Now())
+		}
+	}()
+}
@@ -0,0 +1,36 @@
+// AI-assisted code
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeartbeatStatus(t *testing.T) {
+	last := time.Date(2025, 10, 13, 20, 0, 0, 0, time.UTC)
+	interval, grace := time.Hour, 10*time.Minute
+
+	tests := []struct {
+		after time.Duration
+		want  string
+	}{
+		{0, "up"},
+		{time.Hour, "up"},
+		{time.Hour + time.Second, "late"},
+		{time.Hour + 10*time.Minute, "late"},
+		{time.Hour + 10*time.Minute + time.Second, "down"},
+		{48 * time.Hour, "down"},
+	}
+	for _, tt := range tests {
+		if got := heartbeatStatus(last, interval, grace, last.Add(tt.after)); got != tt.want {
+			t.Errorf("after %s: got %s, want %s", tt.after, got, tt.want)
+		}
+	}
+
+	if got := heartbeatStatus(time.Time{}, interval, grace, last); got != "new" {
+		t.Errorf("no entries: got %s, want new", got)
+	}
+	if got := heartbeatStatus(last, interval, 0, last.Add(time.Hour+time.Second)); got != "down" {
+		t.Errorf("no grace: got %s, want down", got)
+	}
+}
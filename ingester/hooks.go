@@ -0,0 +1,297 @@
+// AI-assisted code
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Inbound webhooks, created per logset in the web API. POST /hook/{hook_id}
+// stores a JSON or form body as-is. A hook with a secret only accepts
+// requests signed with HMAC-SHA256, either GitHub style (`sha256=<hex>`, or
+// bare hex or base64) or Stripe style (`t=<unix>,v1=<hex>` over
+// `<t>.<body>`).
+
+// stripeTolerance bounds the age of Stripe-style signatures, which are
+// replayable otherwise.
+const stripeTolerance = 5 * time.Minute
+
+type hook struct {
+	userID          gocql.UUID
+	logID           string
+	secret          string
+	signatureHeader string
+	timestampPath   string
+}
+
+type hookCacheEntry struct {
+	hook    *hook
+	expires time.Time
+}
+
+// maxHookMisses caps how many unknown hook IDs are remembered. Hook URLs
+// are public, so anyone can make misses up.
+const maxHookMisses = 1000
+
+var (
+	hookMu     sync.Mutex
+	hookCache  = map[string]hookCacheEntry{}
+	hookMisses = map[string]time.Time{}
+)
+
+// lookupHook returns the hook, or nil if there's no such hook.
+func lookupHook(hookID string) (*hook, error) {
+	now := time.Now()
+	hookMu.Lock()
+	e, ok := hookCache[hookID]
+	missed, isMiss := hookMisses[hookID]
+	hookMu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.hook, nil
+	}
+	if isMiss && now.Before(missed) {
+		return nil, nil
+	}
+
+	h := &hook{}
+	err := session.Query(
+		`SELECT user_id, log_id, secret, signature_header, timestamp_path FROM hooks WHERE hook_id = ?`, hookID,
+	).Scan(&h.userID, &h.logID, &h.secret, &h.signatureHeader, &h.timestampPath)
+	if errors.Is(err, gocql.ErrNotFound) {
+		h = nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	hookMu.Lock()
+	defer hookMu.Unlock()
+	if h == nil {
+		if len(hookMisses) >= maxHookMisses {
+			for k, expires := range hookMisses {
+				if now.After(expires) {
+					delete(hookMisses, k)
+				}
+			}
+		}
+		if len(hookMisses) < maxHookMisses {
+			hookMisses[hookID] = now.Add(logsetCacheTTL)
+		}
+		return nil, nil
+	}
+	if len(hookCache) > 10000 {
+		for k, e := range hookCache {
+			if now.After(e.expires) {
+				delete(hookCache, k)
+			}
+		}
+	}
+	delete(hookMisses, hookID)
+	hookCache[hookID] = hookCacheEntry{h, now.Add(logsetCacheTTL)}
+	return h, nil
+}
+
+// verifyHookSignature checks a signature header value against the body.
+func verifyHookSignature(secret, header string, body []byte, now time.Time) bool {
+	if header == "" {
+		return false
+	}
+
+	if strings.Contains(header, "v1=") {
+		var ts string
+		var sigs []string
+		for _, part := range strings.Split(header, ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch k {
+			case "t":
+				ts = v
+			case "v1":
+				sigs = append(sigs, v)
+			}
+		}
+		sec, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil || now.Sub(time.Unix(sec, 0)).Abs() > stripeTolerance {
+			return false
+		}
+		want := hookMAC(secret, []byte(ts+"."), body)
+		for _, s := range sigs {
+			if got, err := hex.DecodeString(s); err == nil && hmac.Equal(got, want) {
+				return true
+			}
+		}
+		return false
+	}
+
+	sig := strings.TrimPrefix(header, "sha256=")
+	want := hookMAC(secret, body)
+	if got, err := hex.DecodeString(sig); err == nil && hmac.Equal(got, want) {
+		return true
+	}
+	got, err := base64.StdEncoding.DecodeString(sig)
+	return err == nil && hmac.Equal(got, want)
+}
+
+func hookMAC(secret string, parts ...[]byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	for _, p := range parts {
+		mac.Write(p)
+	}
+	return mac.Sum(nil)
+}
+
+// decodeHookBody turns a form or JSON body into the stored data and its
+// decoded value. Repeated form keys become arrays.
+func decodeHookBody(contentType string, body []byte) ([]byte, interface{}, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/x-www-form-urlencoded" {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, nil, err
+		}
+		fields := map[string]interface{}{}
+		for k, v := range form {
+			if len(v) == 1 {
+				fields[k] = v[0]
+			} else {
+				fields[k] = v
+			}
+		}
+		data, err := json.Marshal(fields)
+		return data, fields, err
+	}
+
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, nil, err
+	}
+	if dec.More() {
+		return nil, nil, errors.New("trailing data")
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, body); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), v, nil
+}
+
+// lookupPath follows a dotted path like `data.object.created` or
+// `commits.0.timestamp` through decoded JSON.
+func lookupPath(v interface{}, path string) (interface{}, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch x := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = x[key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(x) {
+				return nil, false
+			}
+			v = x[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// hookTime converts a timestamp field: RFC 3339 strings, or Unix seconds or
+// milliseconds as numbers or numeric strings.
+func hookTime(v interface{}) (time.Time, bool) {
+	var s string
+	switch x := v.(type) {
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, x); err == nil {
+			return t, true
+		}
+		s = x
+	case json.Number:
+		s = x.String()
+	default:
+		return time.Time{}, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f <= 0 || math.IsInf(f, 0) {
+		return time.Time{}, false
+	}
+	if f > 1e12 {
+		return time.UnixMilli(int64(f)), true
+	}
+	return time.UnixMicro(int64(f * 1e6)), true
+}
+
+func ingestHook(w http.ResponseWriter, r *http.Request) {
+	h, err := lookupHook(r.PathValue("hook_id"))
+	if err != nil {
+		log.Println("hook lookup:", err)
+		http.Error(w, `{"error":"lookup error"}`, http.StatusServiceUnavailable)
+		return
+	}
+	if h == nil {
+		http.Error(w, `{"error":"unknown hook"}`, http.StatusNotFound)
+		return
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		http.Error(w, `{"error":"invalid body"}`, http.StatusBadRequest)
+		return
+	}
+	if h.secret != "" && !verifyHookSignature(h.secret, r.Header.Get(h.signatureHeader), body, time.Now()) {
+		http.Error(w, `{"error":"invalid signature"}`, http.StatusUnauthorized)
+		return
+	}
+
+	data, v, err := decodeHookBody(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		http.Error(w, `{"error":"body must be JSON or a form"}`, http.StatusBadRequest)
+		return
+	}
+
+	// A missing or unreadable timestamp falls back to the receive time
+	// rather than losing the event.
+	t := time.Now()
+	if h.timestampPath != "" {
+		if f, ok := lookupPath(v, h.timestampPath); ok {
+			if ts, ok := hookTime(f); ok {
+				t = ts
+			}
+		}
+	}
+
+	warnings, err := insertLogChecked(h.userID, h.logID, listenerDedup.next(h.logID, t), data)
+	if writeSchemaError(w, err) {
+		return
+	}
+	if errors.Is(err, errNotEnvelope) {
+		http.Error(w, `{"error":"`+errNotEnvelope.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Println("hook insert error:", err)
+		http.Error(w, `{"error":"insert error"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(schemaResult(warnings))
+}
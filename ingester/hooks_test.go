@@ -0,0 +1,78 @@
+// AI-assisted code
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestVerifyHookSignature(t *testing.T) {
+	body := []byte(`{"action":"opened"}`)
+	now := time.Unix(1760385600, 0)
+	sign := func(msg string) []byte {
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write([]byte(msg))
+		return mac.Sum(nil)
+	}
+	sig := sign(string(body))
+	stripe := func(ts int64) string {
+		return fmt.Sprintf("t=%d,v1=%x", ts, sign(fmt.Sprintf("%d.%s", ts, body)))
+	}
+
+	tests := []struct {
+		name, header string
+		ok           bool
+	}{
+		{"github", "sha256=" + hex.EncodeToString(sig), true},
+		{"bare hex", hex.EncodeToString(sig), true},
+		{"base64", base64.StdEncoding.EncodeToString(sig), true},
+		{"stripe", stripe(now.Unix()), true},
+		{"stripe with old secret", stripe(now.Unix()) + ",v1=00ff", true},
+		{"stripe replayed", stripe(now.Add(-time.Hour).Unix()), false},
+		{"wrong signature", "sha256=" + hex.EncodeToString(sign("other")), false},
+		{"missing", "", false},
+	}
+	for _, tt := range tests {
+		if got := verifyHookSignature("s3cret", tt.header, body, now); got != tt.ok {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.ok)
+		}
+	}
+}
+
+func TestHookTimestamp(t *testing.T) {
+	_, v, err := decodeHookBody("application/json", []byte(`{"data": {"created": 1760385600}, "commits": [{"timestamp": "2025-10-13T20:00:00Z"}], "ms": 1760385600123}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want time.Time
+	}{
+		{"data.created", time.Unix(1760385600, 0)},
+		{"commits.0.timestamp", time.Date(2025, 10, 13, 20, 0, 0, 0, time.UTC)},
+		{"ms", time.UnixMilli(1760385600123)},
+	}
+	for _, tt := range tests {
+		f, ok := lookupPath(v, tt.path)
+		if !ok {
+			t.Errorf("%s: not found", tt.path)
+			continue
+		}
+		if got, ok := hookTime(f); !ok || !got.Equal(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.path, got, tt.want)
+		}
+	}
+	if _, ok := lookupPath(v, "commits.1.timestamp"); ok {
+		t.Error("out of range index found")
+	}
+
+	data, _, err := decodeHookBody("application/x-www-form-urlencoded", []byte("a=1&b=2&b=3"))
+	if err != nil || string(data) != `{"a":"1","b":["2","3"]}` {
+		t.Errorf("form body: %s, %v", data, err)
+	}
+}
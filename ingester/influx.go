@@ -0,0 +1,238 @@
+// AI-assisted code
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// InfluxDB line protocol writes (v2 /api/v2/write and v1 /write) for
+// Telegraf, Home Assistant and friends. The measurement is the logset,
+// fields go under "fields" and tags under "tags".
+
+type influxPoint struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+	Time        *time.Time
+}
+
+// splitEscaped splits s on sep outside of backslash escapes and, when
+// quotes is set, outside of double-quoted strings.
+func splitEscaped(s string, sep byte, quotes bool) []string {
+	var parts []string
+	inQuote := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quotes && s[i] == '"':
+			inQuote = !inQuote
+		case s[i] == sep && !inQuote:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+var influxUnescaper = strings.NewReplacer(`\,`, ",", `\=`, "=", `\ `, " ", `\\`, `\`)
+
+func parseInfluxFieldValue(v string) (interface{}, error) {
+	if strings.HasPrefix(v, `"`) {
+		if len(v) < 2 || !strings.HasSuffix(v, `"`) {
+			return nil, errors.New("unterminated string")
+		}
+		return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(v[1 : len(v)-1]), nil
+	}
+	switch v {
+	case "t", "T", "true", "True", "TRUE":
+		return true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return false, nil
+	}
+	if n, ok := strings.CutSuffix(v, "i"); ok {
+		return strconv.ParseInt(n, 10, 64)
+	}
+	if n, ok := strings.CutSuffix(v, "u"); ok {
+		return strconv.ParseUint(n, 10, 64)
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err == nil && (math.IsNaN(f) || math.IsInf(f, 0)) {
+		return nil, errors.New("NaN and Inf are not supported")
+	}
+	return f, err
+}
+
+// parseInfluxLine parses one line of line protocol. unit is the duration
+// of one timestamp tick for the request's precision.
+func parseInfluxLine(line string, unit time.Duration) (influxPoint, error) {
+	var p influxPoint
+	// Quotes are literal in the measurement and tags, so only the field
+	// set and timestamp are split with quoted strings in mind.
+	sections := splitEscaped(line, ' ', false)
+	nonEmpty := []string{sections[0]}
+	for _, s := range splitEscaped(strings.Join(sections[1:], " "), ' ', true) {
+		if s != "" {
+			nonEmpty = append(nonEmpty, s)
+		}
+	}
+	if len(nonEmpty) < 2 || len(nonEmpty) > 3 {
+		return p, errors.New("expected measurement, fields and optional timestamp")
+	}
+
+	key := splitEscaped(nonEmpty[0], ',', false)
+	p.Measurement = influxUnescaper.Replace(key[0])
+	if p.Measurement == "" {
+		return p, errors.New("missing measurement")
+	}
+	for _, t := range key[1:] {
+		kv := splitEscaped(t, '=', false)
+		if len(kv) != 2 || kv[0] == "" {
+			return p, fmt.Errorf("invalid tag %q", t)
+		}
+		if p.Tags == nil {
+			p.Tags = map[string]string{}
+		}
+		p.Tags[influxUnescaper.Replace(kv[0])] = influxUnescaper.Replace(kv[1])
+	}
+
+	p.Fields = map[string]interface{}{}
+	for _, f := range splitEscaped(nonEmpty[1], ',', true) {
+		kv := splitEscaped(f, '=', true)
+		if len(kv) != 2 || kv[0] == "" {
+			return p, fmt.Errorf("invalid field %q", f)
+		}
+		v, err := parseInfluxFieldValue(kv[1])
+		if err != nil {
+			return p, fmt.Errorf("invalid field %q: %v", f, err)
+		}
+		p.Fields[influxUnescaper.Replace(kv[0])] = v
+	}
+
+	if len(nonEmpty) == 3 {
+		ts, err := strconv.ParseInt(nonEmpty[2], 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("invalid timestamp %q", nonEmpty[2])
+		}
+		t := time.Unix(0, ts*int64(unit))
+		p.Time = &t
+	}
+	return p, nil
+}
+
+// influxPrecision maps both the v2 (ns, us, ms, s) and v1 (n, u, ms, s,
+// m, h) precision names to a tick duration.
+func influxPrecision(p string) (time.Duration, bool) {
+	switch p {
+	case "", "ns", "n":
+		return time.Nanosecond, true
+	case "us", "u":
+		return time.Microsecond, true
+	case "ms":
+		return time.Millisecond, true
+	case "s":
+		return time.Second, true
+	case "m":
+		return time.Minute, true
+	case "h":
+		return time.Hour, true
+	}
+	return 0, false
+}
+
+func influxError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"code": code, "message": msg})
+}
+
+// influxAuthenticate accepts Influx's `Authorization: Token ...` header and
+// v1's u/p query parameters alongside Bearer and Basic auth.
+func influxAuthenticate(w http.ResponseWriter, r *http.Request) (gocql.UUID, bool) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Token "); ok {
+		r.Header.Set("Authorization", "Bearer "+token)
+	} else if p := r.URL.Query().Get("p"); p != "" && r.Header.Get("Authorization") == "" {
+		r.Header.Set("Authorization", "Bearer "+p)
+	}
+	return authenticateBasic(w, r)
+}
+
+func ingestInflux(w http.ResponseWriter, r *http.Request) {
+	userID, ok := influxAuthenticate(w, r)
+	if !ok {
+		return
+	}
+
+	unit, ok := influxPrecision(r.URL.Query().Get("precision"))
+	if !ok {
+		influxError(w, http.StatusBadRequest, "invalid", "invalid precision")
+		return
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		influxError(w, http.StatusBadRequest, "invalid", "invalid body")
+		return
+	}
+
+	var points []influxPoint
+	for i, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p, err := parseInfluxLine(line, unit)
+		if err != nil {
+			influxError(w, http.StatusBadRequest, "invalid", fmt.Sprintf("line %d: %v", i+1, err))
+			return
+		}
+		points = append(points, p)
+	}
+
+	for _, p := range points {
+		logID, err := resolveLogset(userID, p.Measurement)
+		if err != nil {
+			log.Println("influx logset:", err)
+			influxError(w, http.StatusInternalServerError, "internal error", "logset error")
+			return
+		}
+
+		entry := map[string]interface{}{"fields": p.Fields}
+		if len(p.Tags) > 0 {
+			entry["tags"] = p.Tags
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			influxError(w, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+
+		t := time.Now()
+		if p.Time != nil {
+			t = *p.Time
+		}
+		err = insertLog(userID, logID, listenerDedup.next(logID, t), data)
+		if refused(err) {
+			influxError(w, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+		if err != nil {
+			log.Println("influx insert error:", err)
+			influxError(w, http.StatusInternalServerError, "internal error", "insert error")
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,99 @@
+// AI-assisted code
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseInfluxLine(t *testing.T) {
+	at := func(ns int64) *time.Time {
+		t := time.Unix(0, ns)
+		return &t
+	}
+	tests := []struct {
+		line    string
+		unit    time.Duration
+		want    influxPoint
+		wantErr bool
+	}{
+		{
+			line: `cpu,host=nas,region=eu\ west usage=93.1,cores=8i,count=3u,ok=t,name="a \"b\", c" 1760385600000000000`,
+			unit: time.Nanosecond,
+			want: influxPoint{Measurement: "cpu",
+				Tags:   map[string]string{"host": "nas", "region": "eu west"},
+				Fields: map[string]interface{}{"usage": 93.1, "cores": int64(8), "count": uint64(3), "ok": true, "name": `a "b", c`},
+				Time:   at(1760385600000000000)},
+		},
+		{
+			// No timestamp: the handler stores the point at receive time.
+			line: `my\ room,sensor\=id=1 temp=21.5`,
+			unit: time.Nanosecond,
+			want: influxPoint{Measurement: "my room", Tags: map[string]string{"sensor=id": "1"},
+				Fields: map[string]interface{}{"temp": 21.5}},
+		},
+		{
+			line: `temp value=F 1760385600`,
+			unit: time.Second,
+			want: influxPoint{Measurement: "temp", Fields: map[string]interface{}{"value": false}, Time: at(1760385600000000000)},
+		},
+		{
+			line: `"quoted",tag="x" f=1 1760385600123`,
+			unit: time.Millisecond,
+			want: influxPoint{Measurement: `"quoted"`, Tags: map[string]string{"tag": `"x"`},
+				Fields: map[string]interface{}{"f": 1.0}, Time: at(1760385600123000000)},
+		},
+		{line: `cpu`, wantErr: true},
+		{line: `cpu usage=1 2 3`, wantErr: true},
+		{line: `,host=a usage=1`, wantErr: true},
+		{line: `cpu,host usage=1`, wantErr: true},
+		{line: `cpu usage`, wantErr: true},
+		{line: `cpu =1`, wantErr: true},
+		{line: `cpu usage="open`, wantErr: true},
+		{line: `cpu usage=1.5i`, wantErr: true},
+		{line: `cpu usage=-1u`, wantErr: true},
+		{line: `cpu usage=NaN`, wantErr: true},
+		{line: `cpu usage=+Inf`, wantErr: true},
+		{line: `cpu usage=high`, wantErr: true},
+		{line: `cpu usage=1 yesterday`, wantErr: true},
+	}
+	for _, tt := range tests {
+		unit := tt.unit
+		if unit == 0 {
+			unit = time.Nanosecond
+		}
+		got, err := parseInfluxLine(tt.line, unit)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: parsed as %+v", tt.line, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.line, err)
+			continue
+		}
+		if (got.Time == nil) != (tt.want.Time == nil) || got.Time != nil && !got.Time.Equal(*tt.want.Time) {
+			t.Errorf("%s: time = %v, want %v", tt.line, got.Time, tt.want.Time)
+		}
+		got.Time, tt.want.Time = nil, nil
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestInfluxPrecision(t *testing.T) {
+	for p, want := range map[string]time.Duration{
+		"": time.Nanosecond, "n": time.Nanosecond, "us": time.Microsecond, "u": time.Microsecond,
+		"ms": time.Millisecond, "s": time.Second, "m": time.Minute, "h": time.Hour,
+	} {
+		if got, ok := influxPrecision(p); !ok || got != want {
+			t.Errorf("%q: %v, %v", p, got, ok)
+		}
+	}
+	if _, ok := influxPrecision("d"); ok {
+		t.Error("precision d accepted")
+	}
+}
@@ -0,0 +1,157 @@
+// AI-assisted code
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Non-HTTP inputs are configured with space-separated listener specs such as
+// `udp://:5514?logset=router&token=...`. The query string carries the
+// per-listener options.
+
+type listenerSpec struct {
+	network string
+	addr    string
+	opts    url.Values
+}
+
+func parseListeners(env string) ([]listenerSpec, error) {
+	var specs []listenerSpec
+	for _, raw := range strings.Fields(env) {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", raw, err)
+		}
+		if u.Host == "" {
+			return nil, fmt.Errorf("%s: missing address", raw)
+		}
+		specs = append(specs, listenerSpec{network: u.Scheme, addr: u.Host, opts: u.Query()})
+	}
+	return specs, nil
+}
+
+// tlsConfig loads the cert and key named by the spec's cert/key options.
+func (s listenerSpec) tlsConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(s.opts.Get("cert"), s.opts.Get("key"))
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// Stream listeners drop connections that go quiet for idleTimeout, so dead
+// or stalled peers don't hold goroutines and buffers forever. Senders
+// reconnect when they have something to send.
+const idleTimeout = 5 * time.Minute
+
+// idleConn pushes the read deadline back before every read.
+type idleConn struct {
+	net.Conn
+}
+
+func (c idleConn) Read(b []byte) (int, error) {
+	c.SetReadDeadline(time.Now().Add(idleTimeout))
+	return c.Conn.Read(b)
+}
+
+// streamDeduper hands out distinct timestamps per logset. recv_time is the
+// clustering key and Cassandra keeps only milliseconds, so two entries
+// landing on the same millisecond would overwrite each other. It is safe
+// for concurrent use and forgets timestamps handed out more than
+// dedupWindow ago so it doesn't grow forever. That goes by when they were handed out, not by the
+// timestamps themselves, since senders can backfill old entries.
+// All inputs share listenerDedup, since they can write to the same
+// logset.
+type streamDeduper struct {
+	mu        sync.Mutex
+	used      map[string]map[int64]time.Time
+	lastPrune time.Time
+}
+
+const dedupWindow = time.Minute
+
+var listenerDedup streamDeduper
+
+func (d *streamDeduper) next(logID string, t time.Time) time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.used == nil {
+		d.used = map[string]map[int64]time.Time{}
+	}
+	now := time.Now()
+	if now.Sub(d.lastPrune) > dedupWindow {
+		cutoff := now.Add(-dedupWindow)
+		for id, used := range d.used {
+			for ms, at := range used {
+				if at.Before(cutoff) {
+					delete(used, ms)
+				}
+			}
+			if len(used) == 0 {
+				delete(d.used, id)
+			}
+		}
+		d.lastPrune = now
+	}
+
+	t = t.Truncate(time.Millisecond)
+	used := d.used[logID]
+	if used == nil {
+		used = map[int64]time.Time{}
+		d.used[logID] = used
+	}
+	for _, ok := used[t.UnixMilli()]; ok; _, ok = used[t.UnixMilli()] {
+		t = t.Add(time.Millisecond)
+	}
+	used[t.UnixMilli()] = now
+	return t
+}
+
+// Listener inputs authenticate every message, so token lookups are cached
+// briefly. A revoked token stops working within authCacheTTL.
+const authCacheTTL = time.Minute
+
+type authCacheEntry struct {
+	userID  gocql.UUID
+	err     error
+	expires time.Time
+}
+
+var (
+	authMu    sync.Mutex
+	authCache = map[string]authCacheEntry{}
+)
+
+func authenticateCached(token string) (gocql.UUID, error) {
+	now := time.Now()
+	authMu.Lock()
+	e, ok := authCache[token]
+	authMu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.userID, e.err
+	}
+
+	userID, err := authenticateToken(token)
+	if err != nil && err != gocql.ErrNotFound {
+		return userID, err
+	}
+	authMu.Lock()
+	if len(authCache) > 10000 {
+		for k, e := range authCache {
+			if now.After(e.expires) {
+				delete(authCache, k)
+			}
+		}
+	}
+	authCache[token] = authCacheEntry{userID, err, now.Add(authCacheTTL)}
+	authMu.Unlock()
+	return userID, err
+}
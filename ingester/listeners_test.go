@@ -0,0 +1,30 @@
+// AI-assisted code
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStreamDeduper(t *testing.T) {
+	var d streamDeduper
+	// A backfilled entry an hour old, sent twice a minute apart: the prune
+	// in between goes by when the first was handed out, so it's kept.
+	old := time.Now().Add(-time.Hour)
+	first := d.next("logs", old)
+	d.lastPrune = time.Now().Add(-2 * dedupWindow)
+	if second := d.next("logs", old); !second.After(first) {
+		t.Errorf("second entry at %v, first at %v", second, first)
+	}
+	if other := d.next("other", old); !other.Equal(first) {
+		t.Errorf("other logset shifted to %v", other)
+	}
+
+	for ms := range d.used["logs"] {
+		d.used["logs"][ms] = time.Now().Add(-2 * dedupWindow)
+	}
+	d.lastPrune = time.Time{}
+	if again := d.next("logs", old); !again.Equal(first) {
+		t.Errorf("expired timestamp still taken: got %v, want %v", again, first)
+	}
+}
@@ -0,0 +1,212 @@
+// AI-assisted code
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+
+	"shared/pipeline"
+	"shared/redact"
+)
+
+// Protocol adapters (syslog, Loki, ...) name logsets with whatever the
+// sender has: a label, an index, a tag. resolveLogset maps that reference to
+// a log_id, accepting either an existing log_id or a logset name, and
+// creates a logset when no name matches so new streams show up in the UI.
+
+const logsetCacheTTL = time.Minute
+
+type logsetCacheKey struct {
+	userID gocql.UUID
+	ref    string
+}
+
+type logsetCacheEntry struct {
+	logID   string
+	expires time.Time
+}
+
+var (
+	logsetMu    sync.Mutex
+	logsetCache = map[logsetCacheKey]logsetCacheEntry{}
+	// logsetLookups holds a channel for each reference being looked up,
+	// closed when the lookup is done. Other callers wait for it rather than
+	// query too, so a new name doesn't create two logsets.
+	logsetLookups = map[logsetCacheKey]chan struct{}{}
+)
+
+func resolveLogset(userID gocql.UUID, ref string) (string, error) {
+	key := logsetCacheKey{userID, ref}
+
+	var done chan struct{}
+	for done == nil {
+		logsetMu.Lock()
+		if e, ok := logsetCache[key]; ok && time.Now().Before(e.expires) {
+			logsetMu.Unlock()
+			return e.logID, nil
+		}
+		wait, busy := logsetLookups[key]
+		if !busy {
+			done = make(chan struct{})
+			logsetLookups[key] = done
+		}
+		logsetMu.Unlock()
+		if busy {
+			<-wait
+		}
+	}
+
+	logID, err := lookupLogset(userID, ref)
+
+	now := time.Now()
+	logsetMu.Lock()
+	delete(logsetLookups, key)
+	close(done)
+	if err == nil {
+		if len(logsetCache) > 10000 {
+			for k, e := range logsetCache {
+				if now.After(e.expires) {
+					delete(logsetCache, k)
+				}
+			}
+		}
+		logsetCache[key] = logsetCacheEntry{logID, now.Add(logsetCacheTTL)}
+	}
+	logsetMu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	return logID, nil
+}
+
+func lookupLogset(userID gocql.UUID, ref string) (string, error) {
+	iter := session.Query(
+		`SELECT log_id, name FROM logs_meta WHERE user_id = ?`, userID,
+	).Iter()
+
+	var logID, name, byName string
+	for iter.Scan(&logID, &name) {
+		if logID == ref {
+			iter.Close()
+			return logID, nil
+		}
+		if name == ref && byName == "" {
+			byName = logID
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return "", err
+	}
+	if byName != "" {
+		return byName, nil
+	}
+
+	logID = gocql.TimeUUID().String()
+	err := session.Query(
+		`INSERT INTO logs_meta (user_id, log_id, name, description) VALUES (?, ?, ?, ?)`,
+		userID, logID, ref, "",
+	).Exec()
+	return logID, err
+}
+
+// logsetSettings holds what the ingester needs from a logset's settings in
+// logs_meta.data, compiled.
+type logsetSettings struct {
+	schema     *jsonschema.Schema
+	schemaMode string
+	pipeline   *pipeline.Pipeline
+	redactor   *redact.Redactor
+	encrypted  bool
+	// refuse is set when entries can't be stored safely: the redaction
+	// rules don't compile, or the settings couldn't be read at all.
+	refuse  bool
+	expires time.Time
+}
+
+var (
+	settingsMu    sync.Mutex
+	settingsCache = map[logsetKey]logsetSettings{}
+)
+
+// loadLogsetSettings returns a logset's settings, cached for
+// logsetCacheTTL. Schemas and pipelines that fail to compile are ignored;
+// the web API refuses them, so that only happens with hand-edited rows.
+// Redaction rules that fail to compile aren't ignored: see refuse.
+func loadLogsetSettings(userID gocql.UUID, logID string) logsetSettings {
+	key := logsetKey{userID, logID}
+	now := time.Now()
+	settingsMu.Lock()
+	old, ok := settingsCache[key]
+	settingsMu.Unlock()
+	if ok && now.Before(old.expires) {
+		return old
+	}
+
+	var data string
+	err := session.Query(
+		`SELECT data FROM logs_meta WHERE user_id = ? AND log_id = ?`, userID, logID,
+	).Scan(&data)
+	if err != nil && err != gocql.ErrNotFound {
+		// Keep the old settings rather than let entries through unchecked.
+		log.Println("logset settings:", err)
+		if !ok {
+			return logsetSettings{refuse: true}
+		}
+		return old
+	}
+	s := logsetSettings{expires: now.Add(logsetCacheTTL)}
+	var raw struct {
+		Schema     json.RawMessage `json:"schema"`
+		SchemaMode string          `json:"schema_mode"`
+		Pipeline   json.RawMessage `json:"pipeline"`
+		Redaction  json.RawMessage `json:"redaction"`
+		Encrypted  bool            `json:"encrypted"`
+	}
+	if data != "" && json.Unmarshal([]byte(data), &raw) == nil {
+		s.encrypted = raw.Encrypted
+		if len(raw.Schema) > 0 {
+			sch, err := compileSchema(raw.Schema)
+			if err != nil {
+				log.Printf("logset %s schema: %v", logID, err)
+			} else {
+				s.schema, s.schemaMode = sch, raw.SchemaMode
+			}
+		}
+		if len(raw.Pipeline) > 0 {
+			p, err := pipeline.Compile(raw.Pipeline)
+			if err != nil {
+				log.Printf("logset %s pipeline: %v", logID, err)
+			} else {
+				s.pipeline = p
+			}
+		}
+		if len(raw.Redaction) > 0 {
+			r, err := redact.Compile(raw.Redaction)
+			if err != nil {
+				// Storing entries the rules were meant to clean would be
+				// worse than refusing them.
+				log.Printf("logset %s redaction: %v", logID, err)
+				s.refuse = true
+			} else {
+				s.redactor = r
+			}
+		}
+	}
+
+	settingsMu.Lock()
+	if len(settingsCache) > 10000 {
+		for k, e := range settingsCache {
+			if now.After(e.expires) {
+				delete(settingsCache, k)
+			}
+		}
+	}
+	settingsCache[key] = s
+	settingsMu.Unlock()
+	return s
+}
@@ -0,0 +1,264 @@
+// AI-assisted code
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Loki push API, so Promtail, Grafana Alloy and the Docker Loki driver can
+// ship straight to the ingester. The logset comes from the stream's
+// `logset` label, else the X-LibreLog-Logset header, else the `job` label.
+
+type lokiEntry struct {
+	Time     time.Time
+	Line     string
+	Metadata map[string]string
+}
+
+// storeTime is when an entry is stored: its own time, or now for senders
+// that leave it out.
+func (e lokiEntry) storeTime(now time.Time) time.Time {
+	if e.Time.Unix() <= 0 {
+		return now
+	}
+	return e.Time
+}
+
+type lokiStream struct {
+	Labels  map[string]string
+	Entries []lokiEntry
+}
+
+// parseLokiLabels parses the Prometheus-style label string protobuf pushes
+// use, e.g. {job="varlogs", filename="/var/log/syslog"}.
+func parseLokiLabels(s string) (map[string]string, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+		return nil, errors.New("invalid labels")
+	}
+	s = s[1 : len(s)-1]
+	labels := map[string]string{}
+	for {
+		s = strings.TrimLeft(s, " ,")
+		if s == "" {
+			return labels, nil
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq < 1 || eq+1 >= len(s) || s[eq+1] != '"' {
+			return nil, errors.New("invalid labels")
+		}
+		name := strings.TrimSpace(s[:eq])
+		s = s[eq+1:]
+
+		end := 1
+		for end < len(s) && s[end] != '"' {
+			if s[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(s) {
+			return nil, errors.New("invalid labels")
+		}
+		val, err := strconv.Unquote(s[:end+1])
+		if err != nil {
+			return nil, err
+		}
+		labels[name] = val
+		s = s[end+1:]
+	}
+}
+
+func decodeLokiProto(body []byte) ([]lokiStream, error) {
+	raw, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var streams []lokiStream
+	err = walkProto(raw, func(num protowire.Number, b []byte, _ uint64) error {
+		if num != 1 {
+			return nil
+		}
+		var st lokiStream
+		err := walkProto(b, func(num protowire.Number, b []byte, _ uint64) error {
+			switch num {
+			case 1:
+				labels, err := parseLokiLabels(string(b))
+				st.Labels = labels
+				return err
+			case 2:
+				e, err := decodeLokiEntry(b)
+				st.Entries = append(st.Entries, e)
+				return err
+			}
+			return nil
+		})
+		streams = append(streams, st)
+		return err
+	})
+	return streams, err
+}
+
+func decodeLokiEntry(b []byte) (lokiEntry, error) {
+	var e lokiEntry
+	err := walkProto(b, func(num protowire.Number, b []byte, _ uint64) error {
+		switch num {
+		case 1:
+			var sec, nsec int64
+			err := walkProto(b, func(num protowire.Number, _ []byte, v uint64) error {
+				switch num {
+				case 1:
+					sec = int64(v)
+				case 2:
+					nsec = int64(int32(v))
+				}
+				return nil
+			})
+			e.Time = time.Unix(sec, nsec)
+			return err
+		case 2:
+			e.Line = string(b)
+		case 3:
+			var name, value string
+			err := walkProto(b, func(num protowire.Number, b []byte, _ uint64) error {
+				switch num {
+				case 1:
+					name = string(b)
+				case 2:
+					value = string(b)
+				}
+				return nil
+			})
+			if e.Metadata == nil {
+				e.Metadata = map[string]string{}
+			}
+			e.Metadata[name] = value
+			return err
+		}
+		return nil
+	})
+	return e, err
+}
+
+func decodeLokiJSON(body []byte) ([]lokiStream, error) {
+	var req struct {
+		Streams []struct {
+			Stream map[string]string   `json:"stream"`
+			Values [][]json.RawMessage `json:"values"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+
+	streams := make([]lokiStream, len(req.Streams))
+	for i, s := range req.Streams {
+		streams[i].Labels = s.Stream
+		for _, v := range s.Values {
+			if len(v) < 2 {
+				return nil, errors.New("invalid entry")
+			}
+			var tsStr, line string
+			if err := json.Unmarshal(v[0], &tsStr); err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(v[1], &line); err != nil {
+				return nil, err
+			}
+			ns, err := strconv.ParseInt(tsStr, 10, 64)
+			if err != nil {
+				return nil, errors.New("invalid timestamp")
+			}
+			e := lokiEntry{Time: time.Unix(0, ns), Line: line}
+			if len(v) > 2 {
+				if err := json.Unmarshal(v[2], &e.Metadata); err != nil {
+					return nil, err
+				}
+			}
+			streams[i].Entries = append(streams[i].Entries, e)
+		}
+	}
+	return streams, nil
+}
+
+func ingestLoki(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticateBasic(w, r)
+	if !ok {
+		return
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+
+	var streams []lokiStream
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		streams, err = decodeLokiJSON(body)
+	} else {
+		streams, err = decodeLokiProto(body)
+	}
+	if err != nil {
+		http.Error(w, "invalid push request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, st := range streams {
+		logset := st.Labels["logset"]
+		if logset == "" {
+			logset = r.Header.Get("X-LibreLog-Logset")
+		}
+		if logset == "" {
+			logset = st.Labels["job"]
+		}
+		if logset == "" {
+			logset = "loki"
+		}
+		delete(st.Labels, "logset")
+
+		logID, err := resolveLogset(userID, logset)
+		if err != nil {
+			log.Println("loki logset:", err)
+			http.Error(w, "logset error", http.StatusInternalServerError)
+			return
+		}
+
+		for _, e := range st.Entries {
+			entry := map[string]interface{}{
+				"labels": st.Labels,
+				"line":   e.Line,
+			}
+			if len(e.Metadata) > 0 {
+				entry["metadata"] = e.Metadata
+			}
+			data, err := json.Marshal(entry)
+			if err == nil {
+				err = insertLog(userID, logID, listenerDedup.next(logID, e.storeTime(time.Now())), data)
+			}
+			if refused(err) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				log.Println("loki insert error:", err)
+				http.Error(w, "insert error", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,147 @@
+// AI-assisted code
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestParseLokiLabels(t *testing.T) {
+	tests := []struct {
+		in   string
+		want map[string]string
+	}{
+		{`{}`, map[string]string{}},
+		{`{job="varlogs", filename="/var/log/syslog"}`, map[string]string{"job": "varlogs", "filename": "/var/log/syslog"}},
+		{` {a="x\"y",b="\\"} `, map[string]string{"a": `x"y`, "b": `\`}},
+		{`job="x"`, nil},
+		{`{job=x}`, nil},
+		{`{job="x}`, nil},
+		{`{="x"}`, nil},
+		{`{a="\q"}`, nil},
+	}
+	for _, tt := range tests {
+		got, err := parseLokiLabels(tt.in)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%s: parsed as %v", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+// lokiProto builds a snappy-compressed PushRequest with one stream.
+func lokiProto(labels string, entries ...[]byte) []byte {
+	var stream []byte
+	stream = protowire.AppendTag(stream, 1, protowire.BytesType)
+	stream = protowire.AppendString(stream, labels)
+	for _, e := range entries {
+		stream = protowire.AppendTag(stream, 2, protowire.BytesType)
+		stream = protowire.AppendBytes(stream, e)
+	}
+	var req []byte
+	req = protowire.AppendTag(req, 1, protowire.BytesType)
+	req = protowire.AppendBytes(req, stream)
+	return snappy.Encode(nil, req)
+}
+
+func lokiProtoEntry(t time.Time, line string, meta ...string) []byte {
+	var e []byte
+	if !t.IsZero() {
+		var ts []byte
+		ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+		ts = protowire.AppendVarint(ts, uint64(t.Unix()))
+		ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+		ts = protowire.AppendVarint(ts, uint64(t.Nanosecond()))
+		e = protowire.AppendTag(e, 1, protowire.BytesType)
+		e = protowire.AppendBytes(e, ts)
+	}
+	e = protowire.AppendTag(e, 2, protowire.BytesType)
+	e = protowire.AppendString(e, line)
+	for i := 0; i+1 < len(meta); i += 2 {
+		var kv []byte
+		kv = protowire.AppendTag(kv, 1, protowire.BytesType)
+		kv = protowire.AppendString(kv, meta[i])
+		kv = protowire.AppendTag(kv, 2, protowire.BytesType)
+		kv = protowire.AppendString(kv, meta[i+1])
+		e = protowire.AppendTag(e, 3, protowire.BytesType)
+		e = protowire.AppendBytes(e, kv)
+	}
+	return e
+}
+
+func TestDecodeLoki(t *testing.T) {
+	now := time.Date(2025, 10, 13, 20, 0, 0, 0, time.UTC)
+	ts := time.Unix(1760385600, 123456789)
+
+	tests := []struct {
+		name    string
+		body    []byte
+		json    bool
+		want    []lokiStream
+		times   []time.Time
+		wantErr bool
+	}{
+		{
+			name: "json", json: true,
+			body: []byte(`{"streams": [{"stream": {"job": "app"}, "values": [["1760385600123456789", "hello", {"trace": "t1"}], ["0", "no time"]]}]}`),
+			want: []lokiStream{{Labels: map[string]string{"job": "app"}, Entries: []lokiEntry{
+				{Time: ts, Line: "hello", Metadata: map[string]string{"trace": "t1"}},
+				{Time: time.Unix(0, 0), Line: "no time"},
+			}}},
+			times: []time.Time{ts, now},
+		},
+		{
+			name: "protobuf",
+			body: lokiProto(`{job="app"}`, lokiProtoEntry(ts, "hello", "trace", "t1"), lokiProtoEntry(time.Time{}, "no time")),
+			want: []lokiStream{{Labels: map[string]string{"job": "app"}, Entries: []lokiEntry{
+				{Time: ts, Line: "hello", Metadata: map[string]string{"trace": "t1"}},
+				{Line: "no time"},
+			}}},
+			times: []time.Time{ts, now},
+		},
+		{name: "not json", json: true, body: []byte(`{"streams": [`), wantErr: true},
+		{name: "short value", json: true, body: []byte(`{"streams": [{"stream": {}, "values": [["1"]]}]}`), wantErr: true},
+		{name: "bad timestamp", json: true, body: []byte(`{"streams": [{"stream": {}, "values": [["yesterday", "x"]]}]}`), wantErr: true},
+		{name: "numeric timestamp", json: true, body: []byte(`{"streams": [{"stream": {}, "values": [[1, "x"]]}]}`), wantErr: true},
+		{name: "not snappy", body: []byte("plain text"), wantErr: true},
+		{name: "bad labels", body: lokiProto(`job="app"`, lokiProtoEntry(ts, "x")), wantErr: true},
+		{name: "truncated protobuf", body: snappy.Encode(nil, []byte{0x0a, 0x10, 0x0a}), wantErr: true},
+	}
+	for _, tt := range tests {
+		var got []lokiStream
+		var err error
+		if tt.json {
+			got, err = decodeLokiJSON(tt.body)
+		} else {
+			got, err = decodeLokiProto(tt.body)
+		}
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: decoded as %+v", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", tt.name, got, tt.want)
+			continue
+		}
+		for i, e := range got[0].Entries {
+			if st := e.storeTime(now); !st.Equal(tt.times[i]) {
+				t.Errorf("%s: entry %d stored at %v, want %v", tt.name, i, st, tt.times[i])
+			}
+		}
+	}
+}
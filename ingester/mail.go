@@ -0,0 +1,163 @@
+// AI-assisted code
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Alert emails go through the same SMTP relay as the web service's
+// notices, configured with the same SMTP_* variables. This is a copy of
+// the web service's sender.
+
+const smtpTimeout = 30 * time.Second
+
+type smtpConfig struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+	startTLS bool
+}
+
+// smtpConfigFromEnv returns nil when email is not configured.
+func smtpConfigFromEnv() *smtpConfig {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return nil
+	}
+	c := &smtpConfig{
+		host:     host,
+		port:     os.Getenv("SMTP_PORT"),
+		username: os.Getenv("SMTP_USERNAME"),
+		password: os.Getenv("SMTP_PASSWORD"),
+		from:     os.Getenv("SMTP_FROM"),
+		startTLS: os.Getenv("SMTP_STARTTLS") != "false",
+	}
+	if c.port == "" {
+		c.port = "587"
+	}
+	if c.from == "" {
+		c.from = "librelog@" + host
+	}
+	return c
+}
+
+// headerSafe keeps user-chosen names from breaking out of a header.
+func headerSafe(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+func (c *smtpConfig) send(to []string, subject, body string) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(c.host, c.port), smtpTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+	client, err := smtp.NewClient(conn, c.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if c.startTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("smtp server doesn't offer STARTTLS")
+		}
+		if err := client.StartTLS(&tls.Config{ServerName: c.host}); err != nil {
+			return err
+		}
+	}
+	if c.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.username, c.password, c.host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(c.from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := client.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", c.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", headerSafe(subject)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	if _, err := w.Write([]byte(msg.String())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// emailNotice mails the user's confirmed notification targets that take
+// event. Failures are only logged.
+func emailNotice(userID gocql.UUID, event, subject, body string) {
+	cfg := smtpConfigFromEnv()
+	if cfg == nil {
+		return
+	}
+	iter := session.Query(
+		`SELECT address, events, verified FROM notification_targets WHERE user_id = ?`, userID,
+	).Iter()
+	var to []string
+	var address string
+	var events []string
+	var verified bool
+	for iter.Scan(&address, &events, &verified) {
+		for _, e := range events {
+			if e == event && verified {
+				to = append(to, address)
+			}
+		}
+	}
+	if err := iter.Close(); err != nil {
+		log.Println("email targets:", err)
+		return
+	}
+	if len(to) == 0 {
+		return
+	}
+	if err := cfg.send(to, subject, body); err != nil {
+		log.Println("email:", err)
+	}
+}
+
+func emailAlert(r *alertRule, now time.Time, data []byte) {
+	if smtpConfigFromEnv() == nil {
+		return
+	}
+	var body strings.Builder
+	fmt.Fprintf(&body, "The alert %q is firing.\n\n", r.name)
+	fmt.Fprintf(&body, "Logset: %s\n", r.logID)
+	fmt.Fprintf(&body, "Kind: %s\n", r.kind)
+	fmt.Fprintf(&body, "Time: %s\n", now.UTC().Format(time.RFC1123))
+	if len(data) > 0 {
+		fmt.Fprintf(&body, "\nMatching entry:\n%s\n", data)
+	}
+	emailNotice(r.userID, "alert", "LibreLog alert: "+r.name, body.String())
+}
@@ -0,0 +1,208 @@
+// AI-assisted code
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// MQTT bridge: subscribes to topic templates on a broker and ingests every
+// payload. A template is a topic filter with named single-level wildcards,
+// e.g. `librelog/{logset}/#`; the {logset} segment picks the logset unless
+// the subscription names one outright. Messages are received at QoS 1 and
+// only acknowledged once stored. A failed insert is retried with backoff
+// while the connection is up; if it drops first, the broker redelivers the
+// unacknowledged message after we reconnect.
+
+type mqttSubscription struct {
+	Topic  string `json:"topic"`
+	Token  string `json:"token"`
+	Logset string `json:"logset"`
+
+	filter string
+}
+
+type mqttConfig struct {
+	Broker        string
+	ClientID      string
+	Username      string
+	Password      string
+	Subscriptions []mqttSubscription
+}
+
+func mqttConfigFromEnv() (*mqttConfig, error) {
+	broker := os.Getenv("MQTT_BROKER")
+	if broker == "" {
+		return nil, nil
+	}
+	cfg := &mqttConfig{
+		Broker:   broker,
+		ClientID: os.Getenv("MQTT_CLIENT_ID"),
+		Username: os.Getenv("MQTT_USERNAME"),
+		Password: os.Getenv("MQTT_PASSWORD"),
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = "librelog-ingester"
+	}
+	if err := json.Unmarshal([]byte(os.Getenv("MQTT_SUBSCRIPTIONS")), &cfg.Subscriptions); err != nil {
+		return nil, fmt.Errorf("MQTT_SUBSCRIPTIONS: %w", err)
+	}
+	for i := range cfg.Subscriptions {
+		sub := &cfg.Subscriptions[i]
+		if sub.Token == "" {
+			return nil, fmt.Errorf("subscription %q: token required", sub.Topic)
+		}
+		if sub.Logset == "" && !strings.Contains(sub.Topic, "{logset}") {
+			return nil, fmt.Errorf("subscription %q: needs a {logset} segment or a logset", sub.Topic)
+		}
+		sub.filter = templateFilter(sub.Topic)
+	}
+	return cfg, nil
+}
+
+// templateFilter turns `librelog/{logset}/#` into the MQTT filter
+// `librelog/+/#`.
+func templateFilter(template string) string {
+	segs := strings.Split(template, "/")
+	for i, s := range segs {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			segs[i] = "+"
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+// matchTemplate matches a topic against a template and returns the named
+// segments.
+func matchTemplate(template, topic string) (map[string]string, bool) {
+	tsegs := strings.Split(template, "/")
+	segs := strings.Split(topic, "/")
+	vars := map[string]string{}
+	for i, t := range tsegs {
+		if t == "#" {
+			return vars, true
+		}
+		if i >= len(segs) {
+			return nil, false
+		}
+		switch {
+		case strings.HasPrefix(t, "{") && strings.HasSuffix(t, "}"):
+			vars[t[1:len(t)-1]] = segs[i]
+		case t == "+":
+		case t != segs[i]:
+			return nil, false
+		}
+	}
+	return vars, len(tsegs) == len(segs)
+}
+
+// errMQTTDrop marks messages that can never be stored. They're acknowledged
+// anyway so the broker doesn't redeliver them forever.
+var errMQTTDrop = errors.New("dropping message")
+
+// ingestMQTT stores one message. Payloads that aren't JSON are stored as a
+// JSON string.
+func ingestMQTT(sub mqttSubscription, msg mqtt.Message) error {
+	logset := sub.Logset
+	if logset == "" {
+		vars, ok := matchTemplate(sub.Topic, msg.Topic())
+		if !ok || vars["logset"] == "" {
+			return fmt.Errorf("%w: topic %q doesn't match %q", errMQTTDrop, msg.Topic(), sub.Topic)
+		}
+		logset = vars["logset"]
+	}
+
+	data := msg.Payload()
+	if !json.Valid(data) {
+		data, _ = json.Marshal(string(data))
+	}
+	return mqttStore(sub.Token, logset, data)
+}
+
+// mqttStore writes one entry. It's a variable so tests can run the bridge
+// without Cassandra.
+var mqttStore = func(token, logset string, data []byte) error {
+	userID, err := authenticateCached(token)
+	if err != nil {
+		return fmt.Errorf("%w: invalid token for logset %q", errMQTTDrop, logset)
+	}
+	logID, err := resolveLogset(userID, logset)
+	if err != nil {
+		return err
+	}
+	err = insertLog(userID, logID, listenerDedup.next(logID, time.Now()), data)
+	if refused(err) {
+		return fmt.Errorf("%w: %v", errMQTTDrop, err)
+	}
+	return err
+}
+
+const (
+	mqttRetryMin = time.Second
+	mqttRetryMax = 30 * time.Second
+)
+
+// handleMQTTMessage stores a message and acknowledges it. Transient errors
+// are retried in place, which also holds back later messages until storage
+// recovers. If the connection drops meanwhile, the message is left
+// unacknowledged for the broker to redeliver.
+func handleMQTTMessage(c mqtt.Client, sub mqttSubscription, msg mqtt.Message) {
+	backoff := mqttRetryMin
+	for {
+		err := ingestMQTT(sub, msg)
+		if err == nil || errors.Is(err, errMQTTDrop) {
+			if err != nil {
+				log.Println("mqtt:", err)
+			}
+			msg.Ack()
+			return
+		}
+		log.Printf("mqtt: %v, retrying in %s", err, backoff)
+		time.Sleep(backoff)
+		if !c.IsConnectionOpen() {
+			return
+		}
+		backoff = min(backoff*2, mqttRetryMax)
+	}
+}
+
+func startMQTTBridge(cfg *mqttConfig) mqtt.Client {
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetCleanSession(false).
+		SetAutoAckDisabled(true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5 * time.Second).
+		SetMaxReconnectInterval(2 * time.Minute)
+
+	opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		log.Println("mqtt connection lost:", err)
+	})
+	opts.SetOnConnectHandler(func(c mqtt.Client) {
+		log.Println("mqtt connected to", cfg.Broker)
+		for _, sub := range cfg.Subscriptions {
+			sub := sub
+			t := c.Subscribe(sub.filter, 1, func(c mqtt.Client, msg mqtt.Message) {
+				handleMQTTMessage(c, sub, msg)
+			})
+			if t.Wait() && t.Error() != nil {
+				log.Printf("mqtt subscribe %s: %v", sub.filter, t.Error())
+			}
+		}
+	})
+
+	client := mqtt.NewClient(opts)
+	client.Connect()
+	return client
+}
@@ -0,0 +1,182 @@
+// AI-assisted code
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+func TestMatchTemplate(t *testing.T) {
+	tests := []struct {
+		template, topic string
+		logset          string
+		ok              bool
+	}{
+		{"librelog/{logset}/#", "librelog/garden/soil", "garden", true},
+		{"librelog/{logset}/#", "librelog/garden", "garden", true},
+		{"librelog/{logset}", "librelog/garden/soil", "", false},
+		{"librelog/{logset}/+/temp", "librelog/house/kitchen/temp", "house", true},
+		{"librelog/{logset}/+/temp", "librelog/house/kitchen/humidity", "", false},
+		{"other/{logset}", "librelog/garden", "", false},
+	}
+	for _, tt := range tests {
+		vars, ok := matchTemplate(tt.template, tt.topic)
+		if ok != tt.ok || ok && vars["logset"] != tt.logset {
+			t.Errorf("matchTemplate(%q, %q) = %v, %v; want logset %q, %v", tt.template, tt.topic, vars, ok, tt.logset, tt.ok)
+		}
+	}
+	if f := templateFilter("librelog/{logset}/+/#"); f != "librelog/+/+/#" {
+		t.Errorf("templateFilter = %q", f)
+	}
+}
+
+// brokerHook reports subscriptions and completed QoS flows on the test
+// broker.
+type brokerHook struct {
+	mochi.HookBase
+	subscribed chan struct{}
+	acked      chan struct{}
+}
+
+func (h *brokerHook) ID() string { return "test" }
+
+func (h *brokerHook) Provides(b byte) bool {
+	return b == mochi.OnSubscribed || b == mochi.OnQosComplete
+}
+
+func (h *brokerHook) OnSubscribed(*mochi.Client, packets.Packet, []byte) {
+	h.subscribed <- struct{}{}
+}
+
+func (h *brokerHook) OnQosComplete(*mochi.Client, packets.Packet) {
+	h.acked <- struct{}{}
+}
+
+func startTestBroker(t *testing.T, addr string) (*mochi.Server, *brokerHook) {
+	t.Helper()
+	server := mochi.New(&mochi.Options{
+		InlineClient: true,
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	hook := &brokerHook{subscribed: make(chan struct{}, 10), acked: make(chan struct{}, 10)}
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.AddHook(hook, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.AddListener(listeners.NewTCP(listeners.Config{ID: "tcp", Address: addr})); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	return server, hook
+}
+
+func wait(t *testing.T, ch <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+type storedEntry struct {
+	token, logset, data string
+}
+
+func TestMQTTBridge(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	var mu sync.Mutex
+	failures := 0
+	stored := make(chan storedEntry, 10)
+	orig := mqttStore
+	mqttStore = func(token, logset string, data []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			return errors.New("cassandra unavailable")
+		}
+		stored <- storedEntry{token, logset, string(data)}
+		return nil
+	}
+	t.Cleanup(func() { mqttStore = orig })
+
+	broker, hook := startTestBroker(t, addr)
+
+	t.Setenv("MQTT_BROKER", "tcp://"+addr)
+	t.Setenv("MQTT_CLIENT_ID", "librelog-test")
+	t.Setenv("MQTT_SUBSCRIPTIONS", `[{"topic": "librelog/{logset}/#", "token": "tok"}]`)
+	cfg, err := mqttConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := startMQTTBridge(cfg)
+	t.Cleanup(func() { client.Disconnect(0) })
+	wait(t, hook.subscribed, "subscription")
+
+	expect := func(want storedEntry) {
+		t.Helper()
+		select {
+		case got := <-stored:
+			if got != want {
+				t.Fatalf("stored %+v, want %+v", got, want)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for %+v", want)
+		}
+	}
+
+	// The {logset} segment routes the message; JSON is stored as-is.
+	if err := broker.Publish("librelog/garden/soil", []byte(`{"moisture": 41}`), false, 1); err != nil {
+		t.Fatal(err)
+	}
+	expect(storedEntry{"tok", "garden", `{"moisture": 41}`})
+	wait(t, hook.acked, "ack")
+
+	// A transient store error is retried and only then acknowledged.
+	mu.Lock()
+	failures = 1
+	mu.Unlock()
+	if err := broker.Publish("librelog/kitchen/light", []byte("on"), false, 1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-hook.acked:
+		t.Fatal("message acknowledged before it was stored")
+	case <-time.After(200 * time.Millisecond):
+	}
+	expect(storedEntry{"tok", "kitchen", `"on"`})
+	wait(t, hook.acked, "ack after retry")
+
+	// The bridge reconnects and resubscribes when the broker comes back.
+	broker.Close()
+	broker, hook = startTestBroker(t, addr)
+	t.Cleanup(func() { broker.Close() })
+	wait(t, hook.subscribed, "resubscription")
+
+	if err := broker.Publish("librelog/garden/soil", []byte(`{"moisture": 39}`), false, 1); err != nil {
+		t.Fatal(err)
+	}
+	expect(storedEntry{"tok", "garden", `{"moisture": 39}`})
+	wait(t, hook.acked, "ack after reconnect")
+}
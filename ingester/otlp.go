@@ -0,0 +1,267 @@
+// AI-assisted code
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// OTLP/HTTP logs receiver. An ExportLogsServiceRequest has the same wire
+// format as LogsData, so the small logs package is enough and the gRPC
+// service stubs aren't needed.
+//
+// The logset comes from the X-LibreLog-Logset header, else the
+// librelog.logset resource attribute, else service.name.
+
+const otlpLogsetAttr = "librelog.logset"
+
+func anyValue(v *commonpb.AnyValue) interface{} {
+	switch x := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return x.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return x.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return x.IntValue
+	case *commonpb.AnyValue_DoubleValue:
+		return x.DoubleValue
+	case *commonpb.AnyValue_BytesValue:
+		return base64.StdEncoding.EncodeToString(x.BytesValue)
+	case *commonpb.AnyValue_ArrayValue:
+		out := make([]interface{}, len(x.ArrayValue.GetValues()))
+		for i, e := range x.ArrayValue.GetValues() {
+			out[i] = anyValue(e)
+		}
+		return out
+	case *commonpb.AnyValue_KvlistValue:
+		return keyValues(x.KvlistValue.GetValues())
+	default:
+		return nil
+	}
+}
+
+func keyValues(kvs []*commonpb.KeyValue) map[string]interface{} {
+	if len(kvs) == 0 {
+		return nil
+	}
+	out := make(map[string]interface{}, len(kvs))
+	for _, kv := range kvs {
+		out[kv.GetKey()] = anyValue(kv.GetValue())
+	}
+	return out
+}
+
+type otlpEntry struct {
+	Body           interface{}            `json:"body"`
+	Severity       string                 `json:"severity,omitempty"`
+	SeverityNumber int32                  `json:"severity_number,omitempty"`
+	Attributes     map[string]interface{} `json:"attributes,omitempty"`
+	Resource       map[string]interface{} `json:"resource,omitempty"`
+	Scope          *otlpScope             `json:"scope,omitempty"`
+	TraceID        string                 `json:"trace_id,omitempty"`
+	SpanID         string                 `json:"span_id,omitempty"`
+	ObservedTime   *time.Time             `json:"observed_time,omitempty"`
+}
+
+type otlpScope struct {
+	Name       string                 `json:"name,omitempty"`
+	Version    string                 `json:"version,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+func unixNano(ns uint64) *time.Time {
+	if ns == 0 {
+		return nil
+	}
+	t := time.Unix(0, int64(ns)).UTC()
+	return &t
+}
+
+// otlpResponse encodes an ExportLogsServiceResponse, reporting rejected
+// records through partial_success.
+func otlpResponse(isJSON bool, rejected int64, msg string) []byte {
+	if isJSON {
+		if rejected == 0 {
+			return []byte(`{}`)
+		}
+		b, _ := json.Marshal(map[string]interface{}{
+			"partialSuccess": map[string]interface{}{
+				"rejectedLogRecords": fmt.Sprint(rejected),
+				"errorMessage":       msg,
+			},
+		})
+		return b
+	}
+	if rejected == 0 {
+		return nil
+	}
+	var partial []byte
+	partial = protowire.AppendTag(partial, 1, protowire.VarintType)
+	partial = protowire.AppendVarint(partial, uint64(rejected))
+	partial = protowire.AppendTag(partial, 2, protowire.BytesType)
+	partial = protowire.AppendString(partial, msg)
+	var out []byte
+	out = protowire.AppendTag(out, 1, protowire.BytesType)
+	return protowire.AppendBytes(out, partial)
+}
+
+// otlpRecord is one log record from an export request, with the logset
+// and time it's stored under.
+type otlpRecord struct {
+	logset string
+	time   time.Time
+	entry  otlpEntry
+}
+
+// parseOTLP decodes an export request. Records are timed by their
+// time_unix_nano, else their observed time, else now.
+func parseOTLP(body []byte, isJSON bool, headerLogset string, now time.Time) ([]otlpRecord, error) {
+	var req logspb.LogsData
+	var err error
+	if isJSON {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, &req)
+	} else {
+		err = proto.Unmarshal(body, &req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// OTLP/JSON writes trace and span ids as hex, which protojson reads as
+	// base64. Re-encoding the decoded bytes as base64 gives the hex back.
+	idString := hex.EncodeToString
+	if isJSON {
+		idString = base64.StdEncoding.EncodeToString
+	}
+
+	var records []otlpRecord
+	for _, rl := range req.GetResourceLogs() {
+		resource := keyValues(rl.GetResource().GetAttributes())
+
+		logset := headerLogset
+		if logset == "" {
+			logset, _ = resource[otlpLogsetAttr].(string)
+		}
+		if logset == "" {
+			logset, _ = resource["service.name"].(string)
+		}
+		if logset == "" {
+			logset = "otel"
+		}
+		delete(resource, otlpLogsetAttr)
+
+		for _, sl := range rl.GetScopeLogs() {
+			var scope *otlpScope
+			if s := sl.GetScope(); s != nil && (s.GetName() != "" || s.GetVersion() != "" || len(s.GetAttributes()) > 0) {
+				scope = &otlpScope{Name: s.GetName(), Version: s.GetVersion(), Attributes: keyValues(s.GetAttributes())}
+			}
+
+			for _, rec := range sl.GetLogRecords() {
+				entry := otlpEntry{
+					Body:           anyValue(rec.GetBody()),
+					Severity:       rec.GetSeverityText(),
+					SeverityNumber: int32(rec.GetSeverityNumber()),
+					Attributes:     keyValues(rec.GetAttributes()),
+					Resource:       resource,
+					Scope:          scope,
+					ObservedTime:   unixNano(rec.GetObservedTimeUnixNano()),
+				}
+				if len(rec.GetTraceId()) > 0 {
+					entry.TraceID = idString(rec.GetTraceId())
+				}
+				if len(rec.GetSpanId()) > 0 {
+					entry.SpanID = idString(rec.GetSpanId())
+				}
+
+				eventTime := now
+				if t := unixNano(rec.GetTimeUnixNano()); t != nil {
+					eventTime = *t
+				} else if entry.ObservedTime != nil {
+					eventTime = *entry.ObservedTime
+				}
+				records = append(records, otlpRecord{logset, eventTime, entry})
+			}
+		}
+	}
+	return records, nil
+}
+
+func ingestOTLP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticateBearer(w, r)
+	if !ok {
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	isJSON := mediaType == "application/json"
+	if !isJSON && mediaType != "application/x-protobuf" {
+		http.Error(w, `{"error":"unsupported content type"}`, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		http.Error(w, `{"error":"invalid body"}`, http.StatusBadRequest)
+		return
+	}
+
+	records, err := parseOTLP(body, isJSON, r.Header.Get("X-LibreLog-Logset"), time.Now())
+	if err != nil {
+		http.Error(w, `{"error":"invalid otlp payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	var rejected int64
+	var lastErr string
+	for _, rec := range records {
+		logID, err := resolveLogset(userID, rec.logset)
+		if err != nil {
+			log.Println("otlp logset:", err)
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, `{"error":"logset error"}`, http.StatusServiceUnavailable)
+			return
+		}
+
+		// Records that can't be encoded or fail the logset's schema are
+		// rejected for good, but a failed insert is a 503 so the sender
+		// retries the whole request. Entries stored before the failure may
+		// then be stored twice.
+		data, err := json.Marshal(rec.entry)
+		if err != nil {
+			rejected++
+			lastErr = "unencodable log record"
+			continue
+		}
+		err = insertLog(userID, logID, listenerDedup.next(logID, rec.time), data)
+		if refused(err) {
+			rejected++
+			lastErr = err.Error()
+			continue
+		}
+		if err != nil {
+			log.Println("otlp insert error:", err)
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, `{"error":"insert error"}`, http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	if isJSON {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "application/x-protobuf")
+	}
+	w.Write(otlpResponse(isJSON, rejected, lastErr))
+}
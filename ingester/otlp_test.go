@@ -0,0 +1,120 @@
+// AI-assisted code
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestParseOTLP(t *testing.T) {
+	now := time.Date(2025, 10, 13, 20, 0, 0, 0, time.UTC)
+	eventTime := time.Unix(0, 1760385600123456789).UTC()
+	observed := time.Unix(0, 1760385601000000000).UTC()
+
+	str := func(s string) *commonpb.AnyValue {
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: s}}
+	}
+	pb, err := proto.Marshal(&logspb.LogsData{ResourceLogs: []*logspb.ResourceLogs{{
+		Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{{Key: "service.name", Value: str("api")}}},
+		ScopeLogs: []*logspb.ScopeLogs{{LogRecords: []*logspb.LogRecord{{
+			TimeUnixNano: uint64(eventTime.UnixNano()),
+			Body: &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{
+				Values: []*commonpb.KeyValue{
+					{Key: "msg", Value: str("hi")},
+					{Key: "raw", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_BytesValue{BytesValue: []byte{1, 2}}}},
+				},
+			}}},
+			TraceId: []byte{0xab, 0xcd},
+		}}}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		isJSON  bool
+		header  string
+		logset  string
+		time    time.Time
+		entry   otlpEntry
+		wantErr bool
+	}{
+		{
+			name: "json", isJSON: true,
+			body: `{"resourceLogs": [{"resource": {"attributes": [{"key": "librelog.logset", "value": {"stringValue": "web"}}, {"key": "host", "value": {"stringValue": "a"}}]},
+				"scopeLogs": [{"scope": {"name": "lib"}, "logRecords": [{"timeUnixNano": "1760385600123456789", "observedTimeUnixNano": "1760385601000000000",
+				"severityText": "WARN", "severityNumber": 13, "body": {"stringValue": "disk low"},
+				"attributes": [{"key": "free", "value": {"intValue": "5"}}], "traceId": "5b8efff798038103d269b633813fc60c", "spanId": "eee19b7ec3c1b174"}]}]}]}`,
+			logset: "web", time: eventTime,
+			entry: otlpEntry{Body: "disk low", Severity: "WARN", SeverityNumber: 13,
+				Attributes: map[string]interface{}{"free": int64(5)}, Resource: map[string]interface{}{"host": "a"},
+				Scope: &otlpScope{Name: "lib"}, TraceID: "5b8efff798038103d269b633813fc60c", SpanID: "eee19b7ec3c1b174",
+				ObservedTime: &observed},
+		},
+		{
+			name: "observed time fallback", isJSON: true,
+			body:   `{"resourceLogs": [{"scopeLogs": [{"logRecords": [{"observedTimeUnixNano": "1760385601000000000", "body": {"boolValue": true}}]}]}]}`,
+			logset: "otel", time: observed,
+			entry: otlpEntry{Body: true, ObservedTime: &observed},
+		},
+		{
+			name: "no times", isJSON: true, header: "override",
+			body:   `{"resourceLogs": [{"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "api"}}]}, "scopeLogs": [{"logRecords": [{}]}]}]}`,
+			logset: "override", time: now,
+			entry: otlpEntry{Resource: map[string]interface{}{"service.name": "api"}},
+		},
+		{
+			name: "protobuf", body: string(pb),
+			logset: "api", time: eventTime,
+			entry: otlpEntry{Body: map[string]interface{}{"msg": "hi", "raw": "AQI="},
+				Resource: map[string]interface{}{"service.name": "api"}, TraceID: "abcd"},
+		},
+		{name: "not json", isJSON: true, body: `{"resourceLogs": [`, wantErr: true},
+		{name: "wrong json type", isJSON: true, body: `{"resourceLogs": {}}`, wantErr: true},
+		{name: "bad time", isJSON: true, body: `{"resourceLogs": [{"scopeLogs": [{"logRecords": [{"timeUnixNano": "soon"}]}]}]}`, wantErr: true},
+		{name: "not protobuf", body: "\xff\xff\xff", wantErr: true},
+	}
+	for _, tt := range tests {
+		records, err := parseOTLP([]byte(tt.body), tt.isJSON, tt.header, now)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: parsed as %+v", tt.name, records)
+			}
+			continue
+		}
+		if err != nil || len(records) != 1 {
+			t.Errorf("%s: %d records, %v", tt.name, len(records), err)
+			continue
+		}
+		rec := records[0]
+		if rec.logset != tt.logset || !rec.time.Equal(tt.time) {
+			t.Errorf("%s: logset %q at %v, want %q at %v", tt.name, rec.logset, rec.time, tt.logset, tt.time)
+		}
+		if !reflect.DeepEqual(rec.entry, tt.entry) {
+			t.Errorf("%s:\n got %+v\nwant %+v", tt.name, rec.entry, tt.entry)
+		}
+	}
+}
+
+func TestOTLPResponse(t *testing.T) {
+	if got := string(otlpResponse(true, 0, "")); got != `{}` {
+		t.Errorf("json success = %s", got)
+	}
+	if got := string(otlpResponse(true, 2, "nope")); got != `{"partialSuccess":{"errorMessage":"nope","rejectedLogRecords":"2"}}` {
+		t.Errorf("json partial = %s", got)
+	}
+	if got := otlpResponse(false, 0, ""); got != nil {
+		t.Errorf("protobuf success = %x", got)
+	}
+	if got := otlpResponse(false, 2, "nope"); string(got) != "\x0a\x08\x08\x02\x12\x04nope" {
+		t.Errorf("protobuf partial = %x", got)
+	}
+}
@@ -0,0 +1,179 @@
+// AI-assisted code
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Prometheus remote_write receiver. Each sample becomes one entry in the
+// logset named by the series' `logset` label, else the X-LibreLog-Logset
+// header, else the label picked with ?logset_label= (the metric name by
+// default).
+
+type promSample struct {
+	Value float64
+	Time  time.Time
+}
+
+// storeTime is when a sample is stored: its own time, or now for senders
+// that leave it out.
+func (s promSample) storeTime(now time.Time) time.Time {
+	if s.Time.Unix() <= 0 {
+		return now
+	}
+	return s.Time
+}
+
+type promSeries struct {
+	Labels  map[string]string
+	Samples []promSample
+}
+
+func decodePromWrite(body []byte) ([]promSeries, error) {
+	raw, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var series []promSeries
+	err = walkProto(raw, func(num protowire.Number, b []byte, _ uint64) error {
+		if num != 1 {
+			return nil
+		}
+		ts := promSeries{Labels: map[string]string{}}
+		err := walkProto(b, func(num protowire.Number, b []byte, _ uint64) error {
+			switch num {
+			case 1:
+				var name, value string
+				err := walkProto(b, func(num protowire.Number, b []byte, _ uint64) error {
+					switch num {
+					case 1:
+						name = string(b)
+					case 2:
+						value = string(b)
+					}
+					return nil
+				})
+				ts.Labels[name] = value
+				return err
+			case 2:
+				var s promSample
+				err := walkProto(b, func(num protowire.Number, _ []byte, v uint64) error {
+					switch num {
+					case 1:
+						s.Value = math.Float64frombits(v)
+					case 2:
+						s.Time = time.UnixMilli(int64(v))
+					}
+					return nil
+				})
+				ts.Samples = append(ts.Samples, s)
+				return err
+			}
+			return nil
+		})
+		series = append(series, ts)
+		return err
+	})
+	return series, err
+}
+
+// promValue makes a sample value JSON-safe. Infinities are kept as strings;
+// NaN is Prometheus' staleness marker and is skipped.
+func promValue(v float64) (interface{}, bool) {
+	switch {
+	case math.IsNaN(v):
+		return nil, false
+	case math.IsInf(v, 1):
+		return "+Inf", true
+	case math.IsInf(v, -1):
+		return "-Inf", true
+	}
+	return v, true
+}
+
+func ingestPromWrite(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticateBearer(w, r)
+	if !ok {
+		return
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	series, err := decodePromWrite(body)
+	if err != nil {
+		http.Error(w, "invalid write request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logsetLabel := r.URL.Query().Get("logset_label")
+	if logsetLabel == "" {
+		logsetLabel = "__name__"
+	}
+
+	for _, ts := range series {
+		logset := ts.Labels["logset"]
+		if logset == "" {
+			logset = r.Header.Get("X-LibreLog-Logset")
+		}
+		if logset == "" {
+			logset = ts.Labels[logsetLabel]
+		}
+		if logset == "" {
+			logset = "prometheus"
+		}
+
+		metric := ts.Labels["__name__"]
+		labels := map[string]string{}
+		for k, v := range ts.Labels {
+			if k != "__name__" && k != "logset" {
+				labels[k] = v
+			}
+		}
+
+		logID, err := resolveLogset(userID, logset)
+		if err != nil {
+			log.Println("prometheus logset:", err)
+			http.Error(w, "logset error", http.StatusServiceUnavailable)
+			return
+		}
+
+		for _, s := range ts.Samples {
+			value, ok := promValue(s.Value)
+			if !ok {
+				continue
+			}
+			data, err := json.Marshal(map[string]interface{}{
+				"metric": metric,
+				"labels": labels,
+				"value":  value,
+			})
+			if err == nil {
+				err = insertLog(userID, logID, listenerDedup.next(logID, s.storeTime(time.Now())), data)
+			}
+			if refused(err) {
+				// Retrying won't help, so let Prometheus drop the batch.
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				// 5xx makes Prometheus retry the batch; 4xx would drop it.
+				log.Println("prometheus insert error:", err)
+				http.Error(w, "insert error", http.StatusServiceUnavailable)
+				return
+			}
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
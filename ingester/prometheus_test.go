@@ -0,0 +1,116 @@
+// AI-assisted code
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// promWrite builds a snappy-compressed WriteRequest with one series.
+// Samples with a zero time are sent without a timestamp.
+func promWrite(labels []string, samples ...promSample) []byte {
+	var series []byte
+	for i := 0; i+1 < len(labels); i += 2 {
+		var l []byte
+		l = protowire.AppendTag(l, 1, protowire.BytesType)
+		l = protowire.AppendString(l, labels[i])
+		l = protowire.AppendTag(l, 2, protowire.BytesType)
+		l = protowire.AppendString(l, labels[i+1])
+		series = protowire.AppendTag(series, 1, protowire.BytesType)
+		series = protowire.AppendBytes(series, l)
+	}
+	for _, s := range samples {
+		var b []byte
+		b = protowire.AppendTag(b, 1, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(s.Value))
+		if !s.Time.IsZero() {
+			b = protowire.AppendTag(b, 2, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(s.Time.UnixMilli()))
+		}
+		series = protowire.AppendTag(series, 2, protowire.BytesType)
+		series = protowire.AppendBytes(series, b)
+	}
+	var req []byte
+	req = protowire.AppendTag(req, 1, protowire.BytesType)
+	req = protowire.AppendBytes(req, series)
+	// Fields the receiver doesn't know, like metadata, are skipped.
+	req = protowire.AppendTag(req, 3, protowire.BytesType)
+	req = protowire.AppendString(req, "metadata")
+	return snappy.Encode(nil, req)
+}
+
+func TestDecodePromWrite(t *testing.T) {
+	now := time.Date(2025, 10, 13, 20, 0, 0, 0, time.UTC)
+	ts := time.UnixMilli(1760385600123)
+
+	tests := []struct {
+		name    string
+		body    []byte
+		want    []promSeries
+		times   []time.Time
+		wantErr bool
+	}{
+		{
+			name: "samples",
+			body: promWrite([]string{"__name__", "node_load1", "instance", "nas"}, promSample{0.5, ts}, promSample{Value: 1}),
+			want: []promSeries{{
+				Labels:  map[string]string{"__name__": "node_load1", "instance": "nas"},
+				Samples: []promSample{{0.5, ts}, {Value: 1}},
+			}},
+			times: []time.Time{ts, now},
+		},
+		{
+			name: "no samples",
+			body: promWrite([]string{"__name__", "up"}),
+			want: []promSeries{{Labels: map[string]string{"__name__": "up"}}},
+		},
+		{name: "not snappy", body: []byte("plain text"), wantErr: true},
+		{name: "truncated protobuf", body: snappy.Encode(nil, []byte{0x0a, 0x10, 0x0a}), wantErr: true},
+		{name: "bad tag", body: snappy.Encode(nil, []byte{0x0f}), wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := decodePromWrite(tt.body)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: decoded as %+v", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", tt.name, got, tt.want)
+			continue
+		}
+		for i, s := range got[0].Samples {
+			if st := s.storeTime(now); !st.Equal(tt.times[i]) {
+				t.Errorf("%s: sample %d stored at %v, want %v", tt.name, i, st, tt.times[i])
+			}
+		}
+	}
+}
+
+func TestPromValue(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want interface{}
+		ok   bool
+	}{
+		{1.5, 1.5, true},
+		{math.Inf(1), "+Inf", true},
+		{math.Inf(-1), "-Inf", true},
+		{math.NaN(), nil, false},
+	}
+	for _, tt := range tests {
+		if got, ok := promValue(tt.in); got != tt.want || ok != tt.ok {
+			t.Errorf("%v: %v, %v", tt.in, got, ok)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// AI-assisted code
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+
+	"github.com/gocql/gocql"
+)
+
+// Redaction rules are applied with shared/redact. Rules that hash values
+// use a per-user key, kept in redaction_keys.
+
+var (
+	redactionKeyMu sync.Mutex
+	redactionKeys  = map[gocql.UUID][]byte{}
+)
+
+// redactionKey returns the user's hash key, making one the first time it's
+// needed. Keys never change, so they're cached for good.
+func redactionKey(userID gocql.UUID) ([]byte, error) {
+	redactionKeyMu.Lock()
+	key := redactionKeys[userID]
+	redactionKeyMu.Unlock()
+	if key != nil {
+		return key, nil
+	}
+
+	err := session.Query(
+		`SELECT key FROM redaction_keys WHERE user_id = ?`, userID,
+	).Scan(&key)
+	if err == gocql.ErrNotFound {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		// Another ingester may get there first; its key wins.
+		existing := map[string]interface{}{}
+		applied, err := session.Query(
+			`INSERT INTO redaction_keys (user_id, key) VALUES (?, ?) IF NOT EXISTS`, userID, key,
+		).MapScanCAS(existing)
+		if err != nil {
+			return nil, err
+		}
+		if !applied {
+			key, _ = existing["key"].([]byte)
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("empty redaction key for %s", userID)
+	}
+
+	redactionKeyMu.Lock()
+	redactionKeys[userID] = key
+	redactionKeyMu.Unlock()
+	return key, nil
+}
@@ -0,0 +1,160 @@
+// AI-assisted code
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// A logset can carry a JSON Schema, set through the web API and kept in
+// logs_meta.data. Entries are checked against it before they're stored: in
+// reject mode failing entries are refused, in warn mode they're stored and
+// the violations reported back. Schemas are cached with the rest of the
+// logset's settings, so changes apply within a minute.
+
+const maxSchemaViolations = 20
+
+var schemaPrinter = message.NewPrinter(language.English)
+
+type schemaViolation struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// schemaError is returned by insertLog for entries failing their logset's
+// schema in reject mode.
+type schemaError struct {
+	violations []schemaViolation
+}
+
+func (e *schemaError) Error() string {
+	if len(e.violations) == 0 {
+		return "entry doesn't match the logset's schema"
+	}
+	v := e.violations[0]
+	return fmt.Sprintf("entry doesn't match the logset's schema: %s: %s", v.Path, v.Message)
+}
+
+// compileSchema compiles a logset schema. Schemas without $schema are
+// draft 2020-12. Nothing is loaded from outside the schema itself, so $ref
+// only works within it. Formats are asserted, not just annotations.
+func compileSchema(raw []byte) (*jsonschema.Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	c := jsonschema.NewCompiler()
+	c.DefaultDraft(jsonschema.Draft2020)
+	c.UseLoader(jsonschema.SchemeURLLoader{})
+	c.AssertFormat()
+	if err := c.AddResource("logset.json", doc); err != nil {
+		return nil, err
+	}
+	return c.Compile("logset.json")
+}
+
+// validateSchema lists where data breaks the schema, or returns nil.
+func validateSchema(sch *jsonschema.Schema, data []byte) []schemaViolation {
+	inst, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return []schemaViolation{{Path: "", Message: "entry is not JSON"}}
+	}
+	err = sch.Validate(inst)
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		return nil
+	}
+	// The library walks properties in map order; sort every leaf before
+	// cutting the list short, so the same entry always gets the same report.
+	violations := appendViolations(nil, ve)
+	sort.Slice(violations, func(i, j int) bool {
+		if violations[i].Path != violations[j].Path {
+			return violations[i].Path < violations[j].Path
+		}
+		return violations[i].Message < violations[j].Message
+	})
+	if len(violations) > maxSchemaViolations {
+		violations = violations[:maxSchemaViolations]
+	}
+	return violations
+}
+
+// appendViolations flattens a validation error into its leaves, which are
+// the field-level failures.
+func appendViolations(out []schemaViolation, ve *jsonschema.ValidationError) []schemaViolation {
+	if len(ve.Causes) == 0 {
+		return append(out, schemaViolation{
+			Path:    jsonPointer(ve.InstanceLocation),
+			Message: ve.ErrorKind.LocalizedString(schemaPrinter),
+		})
+	}
+	for _, c := range ve.Causes {
+		out = appendViolations(out, c)
+	}
+	return out
+}
+
+func jsonPointer(tokens []string) string {
+	var sb strings.Builder
+	escape := strings.NewReplacer("~", "~0", "/", "~1")
+	for _, t := range tokens {
+		sb.WriteString("/")
+		sb.WriteString(escape.Replace(t))
+	}
+	return sb.String()
+}
+
+// checkSchema validates an entry against its logset's schema. It returns
+// the violations and whether the entry must be refused.
+func checkSchema(settings logsetSettings, data []byte) ([]schemaViolation, bool) {
+	if settings.schema == nil {
+		return nil, false
+	}
+	violations := validateSchema(settings.schema, data)
+	return violations, len(violations) > 0 && settings.schemaMode != "warn"
+}
+
+// schemaErrorBody is the JSON reply for an entry refused by its schema, or
+// nil when err isn't a *schemaError.
+func schemaErrorBody(err error) []byte {
+	var se *schemaError
+	if !errors.As(err, &se) {
+		return nil
+	}
+	b, _ := json.Marshal(map[string]interface{}{
+		"error":  "entry doesn't match the logset's schema",
+		"errors": se.violations,
+	})
+	return b
+}
+
+// writeSchemaError answers with a 422 listing the violations when err is
+// a *schemaError, and reports whether it did.
+func writeSchemaError(w http.ResponseWriter, err error) bool {
+	body := schemaErrorBody(err)
+	if body == nil {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	w.Write(body)
+	return true
+}
+
+// schemaResult is the JSON reply for a stored entry, with any warnings.
+func schemaResult(warnings []schemaViolation) []byte {
+	if len(warnings) == 0 {
+		return []byte(`{"status":"ok"}`)
+	}
+	b, _ := json.Marshal(map[string]interface{}{"status": "ok", "warnings": warnings})
+	return b
+}
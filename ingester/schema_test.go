@@ -0,0 +1,106 @@
+// AI-assisted code
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateSchema(t *testing.T) {
+	sch, err := compileSchema([]byte(`{
+		"type": "object",
+		"required": ["host", "perc"],
+		"properties": {
+			"host": {"type": "string"},
+			"perc": {"type": "number", "minimum": 0, "maximum": 100},
+			"a/b": {"$ref": "#/$defs/email"}
+		},
+		"$defs": {"email": {"type": "string", "format": "email"}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		entry string
+		want  []schemaViolation
+	}{
+		{`{"host": "laptop", "perc": 93.1}`, nil},
+		{`{"host": "laptop", "perc": "high"}`, []schemaViolation{{Path: "/perc"}}},
+		{`{"host": 1, "perc": 120}`, []schemaViolation{{Path: "/host"}, {Path: "/perc"}}},
+		{`{"perc": 1}`, []schemaViolation{{Path: ""}}},
+		{`{"host": "x", "perc": 1, "a/b": "not an address"}`, []schemaViolation{{Path: "/a~1b"}}},
+		{`not json`, []schemaViolation{{Path: "", Message: "entry is not JSON"}}},
+	}
+	for _, tt := range tests {
+		got := validateSchema(sch, []byte(tt.entry))
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %+v, want %d violations", tt.entry, got, len(tt.want))
+			continue
+		}
+		for i := range got {
+			// Messages come from the library; only check that there is one.
+			if got[i].Path != tt.want[i].Path || got[i].Message == "" ||
+				tt.want[i].Message != "" && got[i].Message != tt.want[i].Message {
+				t.Errorf("%s: violation %d = %+v, want path %q", tt.entry, i, got[i], tt.want[i].Path)
+			}
+		}
+	}
+}
+
+func TestValidateSchemaTruncates(t *testing.T) {
+	sch, err := compileSchema([]byte(`{"additionalProperties": {"type": "number"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := make([]string, 30)
+	for i := range fields {
+		fields[i] = fmt.Sprintf(`"f%02d": "x"`, i)
+	}
+	entry := []byte("{" + strings.Join(fields, ", ") + "}")
+
+	// The library reports properties in map order, so only a sort before
+	// the cut keeps the first 20 the same from run to run.
+	want := validateSchema(sch, entry)
+	if len(want) != maxSchemaViolations {
+		t.Fatalf("got %d violations, want %d", len(want), maxSchemaViolations)
+	}
+	for i, v := range want {
+		if p := fmt.Sprintf("/f%02d", i); v.Path != p {
+			t.Errorf("violation %d at %q, want %q", i, v.Path, p)
+		}
+	}
+	for run := 0; run < 20; run++ {
+		if got := validateSchema(sch, entry); !reflect.DeepEqual(got, want) {
+			t.Fatalf("run %d: %+v, want %+v", run, got, want)
+		}
+	}
+}
+
+func TestCompileSchemaNoExternalRefs(t *testing.T) {
+	for _, ref := range []string{"file:///etc/passwd", "https://example.com/schema.json"} {
+		_, err := compileSchema([]byte(`{"$ref": "` + ref + `"}`))
+		if err == nil {
+			t.Errorf("%s: compiled", ref)
+		}
+	}
+	if _, err := compileSchema([]byte(`{"type": "nope"}`)); err == nil {
+		t.Error("invalid schema compiled")
+	}
+}
+
+func TestSchemaErrorBody(t *testing.T) {
+	err := &schemaError{[]schemaViolation{{Path: "/perc", Message: "got string, want number"}}}
+	body := string(schemaErrorBody(err))
+	if !strings.Contains(body, `"errors":[{"path":"/perc","message":"got string, want number"}]`) {
+		t.Errorf("body = %s", body)
+	}
+	if schemaErrorBody(nil) != nil {
+		t.Error("body for nil error")
+	}
+	if got := string(schemaResult(nil)); got != `{"status":"ok"}` {
+		t.Errorf("result = %s", got)
+	}
+}
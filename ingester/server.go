@@ -2,9 +2,12 @@
 package main
 
 import (
+	"compress/gzip"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -13,6 +16,8 @@ import (
 
 	"github.com/gocql/gocql"
 	"github.com/gorilla/websocket"
+
+	"shared/atrest"
 )
 
 type LogObject struct {
@@ -29,13 +34,130 @@ func hashSHA256(s string) string {
 	return hex.EncodeToString(h[:])
 }
 
+// authenticateToken looks up the account behind a token. Tokens of
+// disabled accounts, and ones created before the account's tokens were
+// revoked, are treated as unknown.
 func authenticateToken(token string) (gocql.UUID, error) {
 	tokenHash := hashSHA256(token)
 	var userID gocql.UUID
+	var createdAt time.Time
 	err := session.Query(
-		`SELECT user_id FROM tokens WHERE token_hash = ?`, tokenHash,
-	).Scan(&userID)
-	return userID, err
+		`SELECT user_id, created_at FROM tokens WHERE token_hash = ?`, tokenHash,
+	).Scan(&userID, &createdAt)
+	if err != nil {
+		return userID, err
+	}
+	var disabled bool
+	var revokedAt time.Time
+	err = session.Query(
+		`SELECT disabled, tokens_revoked_at FROM users WHERE user_id = ?`, userID,
+	).Scan(&disabled, &revokedAt)
+	if err != nil {
+		return userID, err
+	}
+	if disabled || !revokedAt.IsZero() && !createdAt.After(revokedAt) {
+		return userID, gocql.ErrNotFound
+	}
+	return userID, nil
+}
+
+func insertLog(userID gocql.UUID, logID string, recvTime time.Time, data []byte) error {
+	_, err := insertLogChecked(userID, logID, recvTime, data)
+	return err
+}
+
+// errLogsetSettings refuses entries for a logset whose redaction rules
+// can't be applied.
+var errLogsetSettings = errors.New("logset settings unavailable")
+
+// refused reports whether insertLog turned an entry down for what it is, so
+// sending it again won't help.
+func refused(err error) bool {
+	var se *schemaError
+	return errors.As(err, &se) || errors.Is(err, errNotEnvelope)
+}
+
+// insertLogChecked is insertLog for callers that pass schema warnings on:
+// entries stored despite failing their logset's schema in warn mode come
+// back with the violations. Entries go through the logset's pipeline
+// first; ones it drops aren't stored, and aren't an error either. Redaction
+// comes last, after the schema check, so nothing past this point sees
+// what it removes. With MASTER_KEY set, the data is sealed just before the
+// insert; everything after it gets the plain entry.
+func insertLogChecked(userID gocql.UUID, logID string, recvTime time.Time, data []byte) ([]schemaViolation, error) {
+	settings := loadLogsetSettings(userID, logID)
+	if settings.refuse {
+		return nil, errLogsetSettings
+	}
+	if settings.encrypted {
+		if err := checkEnvelope(data); err != nil {
+			return nil, err
+		}
+	}
+	if settings.pipeline != nil {
+		var keep bool
+		if data, keep = settings.pipeline.Run(data); !keep {
+			return nil, nil
+		}
+	}
+
+	warnings, reject := checkSchema(settings, data)
+	if reject {
+		return nil, &schemaError{warnings}
+	}
+
+	var redacted []string
+	if settings.redactor != nil {
+		var key []byte
+		if settings.redactor.Hashes {
+			var err error
+			if key, err = redactionKey(userID); err != nil {
+				return nil, err
+			}
+		}
+		data, redacted = settings.redactor.Redact(data, key)
+	}
+
+	// Only entries that were redacted or sealed write those columns, so
+	// the rest don't leave nulls behind.
+	columns := "user_id, log_id, recv_time, data"
+	args := []interface{}{userID, logID, recvTime, data}
+	if len(redacted) > 0 {
+		columns += ", redacted"
+		args = append(args, redacted)
+	}
+	if keyStore.Enabled() {
+		key, err := keyStore.Current(userID)
+		if err != nil {
+			return nil, err
+		}
+		if args[3], err = key.Seal(atrest.EntryAD(userID, logID, recvTime), data); err != nil {
+			return nil, err
+		}
+		columns += ", key_id"
+		args = append(args, key.ID())
+	}
+	query := `INSERT INTO logs (` + columns + `) VALUES (?` + strings.Repeat(",?", len(args)-1) + `)`
+	err := session.Query(query, args...).Exec()
+	if err != nil {
+		return nil, err
+	}
+
+	err = session.Query(
+		`UPDATE logs_usage SET entries = entries + 1, bytes = bytes + ? WHERE user_id = ? AND log_id = ?`,
+		int64(len(data)), userID, logID,
+	).Exec()
+	if err != nil {
+		log.Println("usage update error:", err)
+	}
+
+	recordHeartbeat(userID, logID, time.Now())
+	if !settings.encrypted {
+		recordFields(userID, logID, recvTime, data)
+	}
+	evaluateAlerts(userID, logID, data)
+	notifyEntry(userID, logID, recvTime, data)
+	return warnings, nil
 }
 
 func ingestWS(w http.ResponseWriter, r *http.Request) {
@@ -71,30 +193,72 @@ func ingestWS(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		err = session.Query(
-			`INSERT INTO logs (user_id, log_id, recv_time, data) VALUES (?,?,?,?)`,
-			userID, lo.LogSet, time.Now(), lo.Data,
-		).Exec()
+		warnings, err := insertLogChecked(userID, lo.LogSet, time.Now(), lo.Data)
+		if body := schemaErrorBody(err); body != nil {
+			c.WriteMessage(mt, body)
+			continue
+		}
+		if errors.Is(err, errNotEnvelope) {
+			c.WriteMessage(mt, []byte(`{"error":"`+errNotEnvelope.Error()+`"}`))
+			continue
+		}
 		if err != nil {
 			log.Println("insert error:", err)
 			c.WriteMessage(mt, []byte(`{"error":"insert error"}`))
 			continue
 		}
 
-		c.WriteMessage(mt, []byte(`{"status":"ok"}`))
+		c.WriteMessage(mt, schemaResult(warnings))
 	}
 }
 
-func ingestREST(w http.ResponseWriter, r *http.Request) {
+// authenticateBearer checks the request's Bearer token, writing a 401 and
+// returning false when it is missing or invalid.
+func authenticateBearer(w http.ResponseWriter, r *http.Request) (gocql.UUID, bool) {
 	auth := r.Header.Get("Authorization")
 	if !strings.HasPrefix(auth, "Bearer ") {
 		http.Error(w, `{"error":"missing token"}`, http.StatusUnauthorized)
-		return
+		return gocql.UUID{}, false
 	}
 	token := strings.TrimPrefix(auth, "Bearer ")
 	userID, err := authenticateToken(token)
 	if err != nil {
 		http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
+		return gocql.UUID{}, false
+	}
+	return userID, true
+}
+
+// authenticateBasic also accepts Basic auth with the token as the password,
+// for the Loki, Elasticsearch and Influx shippers that only do usernames and
+// passwords.
+func authenticateBasic(w http.ResponseWriter, r *http.Request) (gocql.UUID, bool) {
+	if _, password, ok := r.BasicAuth(); ok {
+		r.Header.Set("Authorization", "Bearer "+password)
+	}
+	return authenticateBearer(w, r)
+}
+
+// maxBodySize caps request bodies on the batch endpoints.
+const maxBodySize = 16 << 20
+
+// readBody reads a request body, undoing gzip Content-Encoding.
+func readBody(r *http.Request) ([]byte, error) {
+	var body io.Reader = http.MaxBytesReader(nil, r.Body, maxBodySize)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		body = io.LimitReader(gz, maxBodySize)
+	}
+	return io.ReadAll(body)
+}
+
+func ingestREST(w http.ResponseWriter, r *http.Request) {
+	userID, ok := authenticateBearer(w, r)
+	if !ok {
 		return
 	}
 
@@ -104,17 +268,21 @@ func ingestREST(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = session.Query(
-		`INSERT INTO logs (user_id, log_id, recv_time, data) VALUES (?,?,?,?)`,
-		userID, lo.LogSet, time.Now(), lo.Data,
-	).Exec()
+	warnings, err := insertLogChecked(userID, lo.LogSet, time.Now(), lo.Data)
+	if writeSchemaError(w, err) {
+		return
+	}
+	if errors.Is(err, errNotEnvelope) {
+		http.Error(w, `{"error":"`+errNotEnvelope.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, `{"error":"insert error"}`, http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Write([]byte(`{"status":"ok"}`))
+	w.Write(schemaResult(warnings))
 }
 
 func main() {
@@ -130,9 +298,69 @@ func main() {
 	}
 	defer session.Close()
 
+	masterKeys, err := atrest.FromEnv()
+	if err != nil {
+		log.Fatal("MASTER_KEY: ", err)
+	}
+	keyStore = atrest.NewStore(session, masterKeys)
+	if keyStore.Enabled() {
+		startKeyRewrap()
+	}
+
+	startAlertEvaluator()
+	startHeartbeatMonitor()
+	startFieldCatalog()
+	startWebhookDispatcher()
+
+	syslogSpecs, err := parseListeners(os.Getenv("SYSLOG_LISTENERS"))
+	if err != nil {
+		log.Fatal("SYSLOG_LISTENERS: ", err)
+	}
+	if err := startSyslogListeners(syslogSpecs); err != nil {
+		log.Fatal("syslog: ", err)
+	}
+
+	gelfSpecs, err := parseListeners(os.Getenv("GELF_LISTENERS"))
+	if err != nil {
+		log.Fatal("GELF_LISTENERS: ", err)
+	}
+	if err := startGELFListeners(gelfSpecs); err != nil {
+		log.Fatal("gelf: ", err)
+	}
+
+	forwardSpecs, err := parseListeners(os.Getenv("FORWARD_LISTENERS"))
+	if err != nil {
+		log.Fatal("FORWARD_LISTENERS: ", err)
+	}
+	if err := startForwardListeners(forwardSpecs); err != nil {
+		log.Fatal("forward: ", err)
+	}
+
+	mqttCfg, err := mqttConfigFromEnv()
+	if err != nil {
+		log.Fatal("mqtt: ", err)
+	}
+	if mqttCfg != nil {
+		startMQTTBridge(mqttCfg)
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /ingest", ingestWS)
 	mux.HandleFunc("POST /ingest", ingestREST)
+	mux.HandleFunc("POST /v1/logs", ingestOTLP)
+	mux.HandleFunc("POST /loki/api/v1/push", ingestLoki)
+	mux.HandleFunc("POST /api/v2/write", ingestInflux)
+	mux.HandleFunc("POST /write", ingestInflux)
+	mux.HandleFunc("POST /api/v1/write", ingestPromWrite)
+	mux.HandleFunc("POST /hook/{hook_id}", ingestHook)
+	mux.HandleFunc("GET /ping", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /{$}", esInfo)
+	mux.HandleFunc("POST /_bulk", ingestESBulk)
+	mux.HandleFunc("PUT /_bulk", ingestESBulk)
+	mux.HandleFunc("POST /{index}/_bulk", ingestESBulk)
+	mux.HandleFunc("PUT /{index}/_bulk", ingestESBulk)
 
 	log.Println("ingester listening on :9000")
 	log.Fatal(http.ListenAndServe(":9000", mux))
@@ -0,0 +1,374 @@
+// AI-assisted code
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Syslog listeners accept RFC 5424 and legacy BSD (RFC 3164) messages over
+// UDP, TCP and TLS. TCP streams may use octet counting or newline framing
+// (RFC 6587). Each message is stored as structured JSON.
+//
+// A listener's token and logset options apply to every message it receives.
+// Senders can override both with a structured data element:
+//
+//	<14>1 2025-10-13T20:00:00Z nas backup - - [librelog token="..." logset="nas"] done
+
+const maxSyslogSize = 64 * 1024
+
+// maxFrameLenDigits bounds an octet-counting prefix; maxSyslogSize has 5.
+const maxFrameLenDigits = 6
+
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var syslogSeverities = []string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+type syslogMessage struct {
+	Priority       int                          `json:"priority"`
+	Facility       string                       `json:"facility"`
+	Severity       string                       `json:"severity"`
+	Timestamp      *time.Time                   `json:"timestamp,omitempty"`
+	Hostname       string                       `json:"hostname,omitempty"`
+	App            string                       `json:"app,omitempty"`
+	ProcID         string                       `json:"procid,omitempty"`
+	MsgID          string                       `json:"msgid,omitempty"`
+	StructuredData map[string]map[string]string `json:"structured_data,omitempty"`
+	Msg            string                       `json:"msg"`
+}
+
+var errNotSyslog = errors.New("not a syslog message")
+
+func parseSyslog(b []byte) (syslogMessage, error) {
+	var m syslogMessage
+	b = bytes.TrimRight(b, "\r\n\x00")
+	if len(b) < 3 || b[0] != '<' {
+		return m, errNotSyslog
+	}
+	end := bytes.IndexByte(b, '>')
+	if end < 2 || end > 4 {
+		return m, errNotSyslog
+	}
+	pri, err := strconv.Atoi(string(b[1:end]))
+	if err != nil || pri < 0 || pri > 191 {
+		return m, errNotSyslog
+	}
+	m.Priority = pri
+	m.Facility = syslogFacilities[pri/8]
+	m.Severity = syslogSeverities[pri%8]
+
+	rest := string(b[end+1:])
+	if strings.HasPrefix(rest, "1 ") {
+		return parseRFC5424(m, rest[2:])
+	}
+	return parseRFC3164(m, rest), nil
+}
+
+// nextField splits off the next space-delimited header field. "-" is the
+// RFC 5424 nil value.
+func nextField(s string) (string, string) {
+	field, rest, _ := strings.Cut(s, " ")
+	if field == "-" {
+		field = ""
+	}
+	return field, rest
+}
+
+func parseRFC5424(m syslogMessage, s string) (syslogMessage, error) {
+	var ts string
+	ts, s = nextField(s)
+	if ts != "" {
+		t, err := time.Parse(time.RFC3339Nano, ts)
+		if err != nil {
+			return m, fmt.Errorf("invalid timestamp %q", ts)
+		}
+		m.Timestamp = &t
+	}
+	m.Hostname, s = nextField(s)
+	m.App, s = nextField(s)
+	m.ProcID, s = nextField(s)
+	m.MsgID, s = nextField(s)
+
+	if strings.HasPrefix(s, "-") {
+		s = strings.TrimPrefix(s[1:], " ")
+	} else {
+		sd, rest, err := parseStructuredData(s)
+		if err != nil {
+			return m, err
+		}
+		m.StructuredData = sd
+		s = strings.TrimPrefix(rest, " ")
+	}
+	m.Msg = strings.TrimPrefix(s, "\ufeff")
+	return m, nil
+}
+
+// parseStructuredData reads consecutive [id key="value" ...] elements.
+func parseStructuredData(s string) (map[string]map[string]string, string, error) {
+	sd := map[string]map[string]string{}
+	for strings.HasPrefix(s, "[") {
+		s = s[1:]
+		i := strings.IndexAny(s, " ]")
+		if i < 1 {
+			return nil, "", errors.New("invalid structured data")
+		}
+		id := s[:i]
+		params := map[string]string{}
+		s = s[i:]
+		for strings.HasPrefix(s, " ") {
+			s = s[1:]
+			eq := strings.Index(s, `="`)
+			if eq < 1 {
+				return nil, "", errors.New("invalid structured data")
+			}
+			name := s[:eq]
+			s = s[eq+2:]
+			var val strings.Builder
+			closed := false
+			for i := 0; i < len(s); i++ {
+				c := s[i]
+				if c == '\\' && i+1 < len(s) && strings.IndexByte(`"\]`, s[i+1]) >= 0 {
+					val.WriteByte(s[i+1])
+					i++
+					continue
+				}
+				if c == '"' {
+					s = s[i+1:]
+					closed = true
+					break
+				}
+				val.WriteByte(c)
+			}
+			if !closed {
+				return nil, "", errors.New("unterminated structured data value")
+			}
+			params[name] = val.String()
+		}
+		if !strings.HasPrefix(s, "]") {
+			return nil, "", errors.New("invalid structured data")
+		}
+		s = s[1:]
+		sd[id] = params
+	}
+	return sd, s, nil
+}
+
+// parseRFC3164 is lenient since BSD syslog was never strictly specified.
+// Devices differ on whether they send a timestamp or hostname at all.
+func parseRFC3164(m syslogMessage, s string) syslogMessage {
+	if len(s) >= 15 {
+		if t, err := time.ParseInLocation(time.Stamp, s[:15], time.Local); err == nil {
+			now := time.Now()
+			t = t.AddDate(now.Year(), 0, 0)
+			// a December message read in January belongs to last year
+			if t.After(now.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			m.Timestamp = &t
+			s = strings.TrimPrefix(s[15:], " ")
+		}
+	}
+
+	first, rest, _ := strings.Cut(s, " ")
+	if m.Timestamp != nil && first != "" && !strings.HasSuffix(first, ":") && !strings.Contains(first, "[") {
+		m.Hostname = first
+		s = rest
+	}
+
+	colon := strings.Index(s, ":")
+	if colon > 0 && colon <= 48 && !strings.Contains(s[:colon], " ") {
+		tag := s[:colon]
+		if open := strings.IndexByte(tag, '['); open > 0 && strings.HasSuffix(tag, "]") {
+			m.ProcID = tag[open+1 : len(tag)-1]
+			tag = tag[:open]
+		}
+		m.App = tag
+		s = strings.TrimPrefix(s[colon+1:], " ")
+	}
+	m.Msg = s
+	return m
+}
+
+// ingestSyslog stores one raw message received on a listener.
+func ingestSyslog(spec listenerSpec, raw []byte) error {
+	m, err := parseSyslog(raw)
+	if err != nil {
+		return err
+	}
+
+	token := spec.opts.Get("token")
+	logset := spec.opts.Get("logset")
+	for id, params := range m.StructuredData {
+		if id != "librelog" && !strings.HasPrefix(id, "librelog@") {
+			continue
+		}
+		if params["token"] != "" {
+			token = params["token"]
+		}
+		if params["logset"] != "" {
+			logset = params["logset"]
+		}
+		delete(m.StructuredData, id)
+	}
+	if token == "" {
+		return errors.New("no token")
+	}
+	if logset == "" {
+		logset = "syslog"
+	}
+
+	userID, err := authenticateCached(token)
+	if err != nil {
+		return errors.New("invalid token")
+	}
+	logID, err := resolveLogset(userID, logset)
+	if err != nil {
+		return err
+	}
+	if len(m.StructuredData) == 0 {
+		m.StructuredData = nil
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return insertLog(userID, logID, listenerDedup.next(logID, time.Now()), data)
+}
+
+func startSyslogListeners(specs []listenerSpec) error {
+	for _, spec := range specs {
+		switch spec.network {
+		case "udp":
+			conn, err := net.ListenPacket("udp", spec.addr)
+			if err != nil {
+				return err
+			}
+			go serveSyslogUDP(spec, conn)
+		case "tcp", "tls":
+			ln, err := net.Listen("tcp", spec.addr)
+			if err != nil {
+				return err
+			}
+			if spec.network == "tls" {
+				cfg, err := spec.tlsConfig()
+				if err != nil {
+					return err
+				}
+				ln = tls.NewListener(ln, cfg)
+			}
+			go serveSyslogStream(spec, ln)
+		default:
+			return fmt.Errorf("syslog: unsupported listener %q", spec.network)
+		}
+		log.Printf("syslog listening on %s://%s", spec.network, spec.addr)
+	}
+	return nil
+}
+
+func serveSyslogUDP(spec listenerSpec, conn net.PacketConn) {
+	buf := make([]byte, maxSyslogSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Println("syslog read:", err)
+			continue
+		}
+		if err := ingestSyslog(spec, buf[:n]); err != nil {
+			log.Println("syslog:", err)
+		}
+	}
+}
+
+func serveSyslogStream(spec listenerSpec, ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Println("syslog accept:", err)
+			continue
+		}
+		go func() {
+			defer c.Close()
+			r := bufio.NewReaderSize(idleConn{c}, maxSyslogSize)
+			for {
+				frame, err := readSyslogFrame(r)
+				if err != nil {
+					if err != io.EOF {
+						log.Println("syslog read:", err)
+					}
+					return
+				}
+				if len(frame) == 0 {
+					continue
+				}
+				if err := ingestSyslog(spec, frame); err != nil {
+					log.Println("syslog:", err)
+				}
+			}
+		}()
+	}
+}
+
+// readSyslogFrame reads one octet-counted ("LEN SP MSG") or newline
+// terminated message from a stream.
+func readSyslogFrame(r *bufio.Reader) ([]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] >= '1' && first[0] <= '9' {
+		// Read the length digit by digit so a peer that never sends the
+		// space can't make us buffer without bound.
+		n := 0
+		for digits := 0; ; digits++ {
+			c, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if c == ' ' {
+				break
+			}
+			if c < '0' || c > '9' || digits == maxFrameLenDigits {
+				return nil, errors.New("invalid frame length")
+			}
+			n = n*10 + int(c-'0')
+		}
+		if n > maxSyslogSize {
+			return nil, fmt.Errorf("frame length %d too large", n)
+		}
+		frame := make([]byte, n)
+		_, err := io.ReadFull(r, frame)
+		return frame, err
+	}
+
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, errors.New("message too long")
+	}
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+	return bytes.Clone(line), err
+}
@@ -0,0 +1,116 @@
+// AI-assisted code
+package main
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSyslog(t *testing.T) {
+	stamp := time.Date(2025, 10, 13, 20, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		raw     string
+		want    syslogMessage
+		noTime  bool
+		wantErr bool
+	}{
+		{
+			name: "rfc5424",
+			raw:  `<14>1 2025-10-13T20:00:00Z nas backup 42 ID7 [librelog token="t" logset="nas"][ex@1 a="q\"x\]"] done` + "\n",
+			want: syslogMessage{Priority: 14, Facility: "user", Severity: "info", Timestamp: &stamp,
+				Hostname: "nas", App: "backup", ProcID: "42", MsgID: "ID7",
+				StructuredData: map[string]map[string]string{
+					"librelog": {"token": "t", "logset": "nas"},
+					"ex@1":     {"a": `q"x]`},
+				},
+				Msg: "done"},
+		},
+		{
+			name:   "rfc5424 nil values",
+			raw:    "<165>1 - - - - - - \ufeffhello",
+			want:   syslogMessage{Priority: 165, Facility: "local4", Severity: "notice", Msg: "hello"},
+			noTime: true,
+		},
+		{
+			name: "rfc3164",
+			raw:  "<34>Oct 13 20:00:00 router sshd[311]: accepted key",
+			want: syslogMessage{Priority: 34, Facility: "auth", Severity: "crit",
+				Hostname: "router", App: "sshd", ProcID: "311", Msg: "accepted key"},
+		},
+		{
+			// Without a timestamp there's no telling a hostname from a tag.
+			name:   "rfc3164 without timestamp",
+			raw:    "<13>cron: job ran",
+			want:   syslogMessage{Priority: 13, Facility: "user", Severity: "notice", App: "cron", Msg: "job ran"},
+			noTime: true,
+		},
+		{
+			name:   "rfc3164 bare message",
+			raw:    "<0>something happened here",
+			want:   syslogMessage{Priority: 0, Facility: "kern", Severity: "emerg", Msg: "something happened here"},
+			noTime: true,
+		},
+		{name: "no priority", raw: "hello", wantErr: true},
+		{name: "priority too large", raw: "<192>1 - - - - - - x", wantErr: true},
+		{name: "priority not a number", raw: "<1a>x", wantErr: true},
+		{name: "unclosed priority", raw: "<14 hello", wantErr: true},
+		{name: "bad rfc5424 timestamp", raw: "<14>1 yesterday nas app - - - x", wantErr: true},
+		{name: "unterminated structured data", raw: `<14>1 - nas app - - [x a="b] msg`, wantErr: true},
+		{name: "malformed structured data", raw: `<14>1 - nas app - - [x a] msg`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSyslog([]byte(tt.raw))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: parsed as %+v", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if tt.noTime != (got.Timestamp == nil) {
+			t.Errorf("%s: timestamp = %v", tt.name, got.Timestamp)
+		}
+		if tt.want.Timestamp != nil && !got.Timestamp.Equal(*tt.want.Timestamp) {
+			t.Errorf("%s: timestamp = %v, want %v", tt.name, got.Timestamp, tt.want.Timestamp)
+		}
+		// RFC 3164 timestamps carry no year or zone; only the fields
+		// around them are compared.
+		got.Timestamp, tt.want.Timestamp = nil, nil
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRFC3164Year(t *testing.T) {
+	// Messages carry no year; one from the future is from last year.
+	now := time.Now()
+	future := now.AddDate(0, 0, 3)
+	m := parseRFC3164(syslogMessage{}, future.Format(time.Stamp)+" host app: x")
+	if m.Timestamp == nil || m.Timestamp.After(now) {
+		t.Errorf("timestamp = %v, want a year back", m.Timestamp)
+	}
+}
+
+func TestReadSyslogFrame(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("11 <14>1 - - x<13>two\n<13>three"))
+	for _, want := range []string{"<14>1 - - x", "<13>two\n", "<13>three"} {
+		got, err := readSyslogFrame(r)
+		if err != nil || string(got) != want {
+			t.Errorf("frame = %q, %v; want %q", got, err, want)
+		}
+	}
+
+	for _, raw := range []string{"12x <14>", "1234567 <14>", "99999 <14>"} {
+		if got, err := readSyslogFrame(bufio.NewReader(strings.NewReader(raw))); err == nil {
+			t.Errorf("%q: read %q", raw, got)
+		}
+	}
+}
@@ -0,0 +1,513 @@
+// AI-assisted code
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gocql/gocql"
+
+	"shared/atrest"
+)
+
+// Outbound webhooks, managed by the web API. Events are queued as rows in
+// webhook_deliveries, with a row in webhook_due for each attempt: the
+// ingester queues entry and alert events itself, and the web service queues
+// logset changes. Every ingester runs a dispatcher that sends due
+// deliveries, claiming each attempt with a conditional update so only one
+// of them sends it.
+//
+// Payloads are signed like Stripe's: X-LibreLog-Signature is
+// `t=<unix>,v1=<hex>`, the HMAC-SHA256 of `<t>.<body>` with the webhook's
+// secret.
+
+const (
+	webhookTick        = 5 * time.Second
+	webhookWorkers     = 4
+	webhookTimeout     = 10 * time.Second
+	webhookMaxAttempts = 8
+	webhookRetryMin    = 30 * time.Second
+	webhookRetryMax    = time.Hour
+
+	// webhookBucket is the width of webhook_due's partitions.
+	webhookBucket = time.Hour
+	// webhookLookback is how far back the dispatcher looks on startup,
+	// the life of a delivery.
+	webhookLookback = 7 * 24 * time.Hour
+	// webhookSkew is how far back each scan looks again, for rows written
+	// late or by a service whose clock is behind.
+	webhookSkew = time.Minute
+)
+
+type webhook struct {
+	userID    gocql.UUID
+	webhookID gocql.UUID
+	logID     string
+	url       string
+	secret    string
+	events    map[string]bool
+	filter    *alertCondition
+}
+
+type deliveryRef struct {
+	webhookID  gocql.UUID
+	deliveryID gocql.UUID
+}
+
+var (
+	webhookMu   sync.Mutex
+	webhooks    = map[logsetKey][]*webhook{}
+	webhookByID = map[gocql.UUID]*webhook{}
+
+	webhookWork   = make(chan deliveryRef, 256)
+	webhookClient = &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: webhookTimeout,
+				Control: checkWebhookDial,
+			}).DialContext,
+			TLSHandshakeTimeout: webhookTimeout,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		// A redirect could lead anywhere, so the 3xx is the delivery's
+		// answer and it's retried like any other failure.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	// webhookAllowPrivate lets webhooks reach loopback and private
+	// addresses, for receivers on the same network as the ingester.
+	webhookAllowPrivate = os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true"
+)
+
+// webhookBlocked lists ranges webhooks can't reach on top of loopback,
+// private, link-local, multicast and unspecified addresses.
+var webhookBlocked = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("2002::/16"),
+}
+
+// webhookAddrAllowed reports whether a webhook may connect to ip.
+func webhookAddrAllowed(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, p := range webhookBlocked {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkWebhookDial runs on the resolved address of every connection a
+// webhook makes, so a hostname can't be pointed inside the network after
+// the webhook was saved.
+func checkWebhookDial(network, address string, _ syscall.RawConn) error {
+	if webhookAllowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !webhookAddrAllowed(ip) {
+		return fmt.Errorf("%s is a private address", ip)
+	}
+	return nil
+}
+
+// webhookBackoff is the wait after a failed attempt number n, counting
+// from 1.
+func webhookBackoff(n int) time.Duration {
+	d := webhookRetryMin << (n - 1)
+	if n > 7 || d > webhookRetryMax {
+		return webhookRetryMax
+	}
+	return d
+}
+
+func signWebhook(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func scanWebhook(h *webhook, events []string, filter string) error {
+	h.events = map[string]bool{}
+	for _, e := range events {
+		h.events[e] = true
+	}
+	var err error
+	h.filter, err = parseAlertCondition(filter)
+	return err
+}
+
+// refreshWebhooks reloads every webhook, like refreshAlerts.
+func refreshWebhooks() error {
+	byLogset := map[logsetKey][]*webhook{}
+	byID := map[gocql.UUID]*webhook{}
+	iter := session.Query(
+		`SELECT user_id, webhook_id, log_id, url, secret, events, filter FROM webhooks`,
+	).Iter()
+	var h webhook
+	var events []string
+	var filter string
+	for iter.Scan(&h.userID, &h.webhookID, &h.logID, &h.url, &h.secret, &events, &filter) {
+		hook := h
+		if err := scanWebhook(&hook, events, filter); err != nil {
+			log.Printf("webhook %s: invalid filter: %v", h.webhookID, err)
+			continue
+		}
+		key := logsetKey{h.userID, h.logID}
+		byLogset[key] = append(byLogset[key], &hook)
+		byID[h.webhookID] = &hook
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	webhookMu.Lock()
+	webhooks, webhookByID = byLogset, byID
+	webhookMu.Unlock()
+	return nil
+}
+
+// findWebhook looks a webhook up, going to Cassandra for ones created since
+// the last refresh. It returns nil if the webhook is gone.
+func findWebhook(userID, webhookID gocql.UUID) (*webhook, error) {
+	webhookMu.Lock()
+	h := webhookByID[webhookID]
+	webhookMu.Unlock()
+	if h != nil {
+		return h, nil
+	}
+
+	h = &webhook{userID: userID, webhookID: webhookID}
+	var events []string
+	var filter string
+	err := session.Query(
+		`SELECT log_id, url, secret, events, filter FROM webhooks WHERE user_id = ? AND webhook_id = ?`,
+		userID, webhookID,
+	).Scan(&h.logID, &h.url, &h.secret, &events, &filter)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return h, scanWebhook(h, events, filter)
+}
+
+// notifyWebhooks queues an event for the logset's webhooks that subscribe
+// to it. match, when set, picks which of them get it.
+func notifyWebhooks(userID gocql.UUID, logID, event string, body map[string]interface{}, match func(*webhook) bool) {
+	webhookMu.Lock()
+	hooks := webhooks[logsetKey{userID, logID}]
+	webhookMu.Unlock()
+
+	for _, h := range hooks {
+		if !h.events[event] || match != nil && !match(h) {
+			continue
+		}
+		if err := enqueueWebhook(h, event, body); err != nil {
+			log.Println("webhook enqueue:", err)
+		}
+	}
+}
+
+// notifyEntry queues an entry event. Filters use the same conditions as
+// alert rules.
+func notifyEntry(userID gocql.UUID, logID string, recvTime time.Time, data []byte) {
+	webhookMu.Lock()
+	hooks := webhooks[logsetKey{userID, logID}]
+	webhookMu.Unlock()
+	if len(hooks) == 0 {
+		return
+	}
+
+	var v interface{}
+	decoded := false
+	entry := map[string]interface{}{"recv_time": recvTime, "data": string(data)}
+	if json.Valid(data) {
+		entry["data"] = json.RawMessage(data)
+	}
+	notifyWebhooks(userID, logID, "entry", map[string]interface{}{"entry": entry}, func(h *webhook) bool {
+		if h.filter == nil {
+			return true
+		}
+		if !decoded {
+			v, decoded = decodeEntry(data), true
+		}
+		return h.filter.match(v)
+	})
+}
+
+func enqueueWebhook(h *webhook, event string, body map[string]interface{}) error {
+	deliveryID := gocql.TimeUUID()
+	now := time.Now()
+	payload := map[string]interface{}{
+		"event":       event,
+		"webhook_id":  h.webhookID.String(),
+		"delivery_id": deliveryID.String(),
+		"log_id":      h.logID,
+		"time":        now,
+	}
+	for k, v := range body {
+		payload[k] = v
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	sealed, keyID, err := keyStore.Seal(h.userID, atrest.DeliveryAD(h.webhookID, deliveryID), b)
+	if err != nil {
+		return err
+	}
+
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(
+		`INSERT INTO webhook_deliveries (webhook_id, delivery_id, user_id, event, payload, key_id, status, attempts, next_attempt, updated_at) VALUES (?, ?, ?, ?, ?, ?, 'pending', 0, ?, ?)`,
+		h.webhookID, deliveryID, h.userID, event, sealed, keyID, now, now,
+	)
+	batch.Query(
+		`INSERT INTO webhook_due (bucket, next_attempt, delivery_id, webhook_id) VALUES (?, ?, ?, ?)`,
+		now.Truncate(webhookBucket), now, deliveryID, h.webhookID,
+	)
+	if err := session.ExecuteBatch(batch); err != nil {
+		return err
+	}
+
+	select {
+	case webhookWork <- deliveryRef{h.webhookID, deliveryID}:
+	default:
+		// The dispatcher's tick will find it.
+	}
+	return nil
+}
+
+// deliverWebhook makes one attempt at a delivery. The claim pushes
+// next_attempt out by the backoff first, so if this ingester dies mid-send
+// another one retries it later.
+func deliverWebhook(ref deliveryRef) error {
+	var userID, keyID gocql.UUID
+	var event, payload, status string
+	var attempts int
+	var due time.Time
+	err := session.Query(
+		`SELECT user_id, event, payload, key_id, status, attempts, next_attempt FROM webhook_deliveries WHERE webhook_id = ? AND delivery_id = ?`,
+		ref.webhookID, ref.deliveryID,
+	).Scan(&userID, &event, &payload, &keyID, &status, &attempts, &due)
+	// webhook_due keeps a row for every attempt, so most rows are for
+	// deliveries that have since finished or been put off.
+	if errors.Is(err, gocql.ErrNotFound) || err == nil && (status != "pending" || due.After(time.Now().Add(webhookTick))) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	h, err := findWebhook(userID, ref.webhookID)
+	if err != nil {
+		return err
+	}
+	if h == nil {
+		return finishDelivery(ref, "dead", 0, "webhook deleted")
+	}
+	body, err := keyStore.Open(userID, keyID, atrest.DeliveryAD(ref.webhookID, ref.deliveryID), payload)
+	if err != nil {
+		log.Printf("webhook %s delivery %s: %v", ref.webhookID, ref.deliveryID, err)
+		return finishDelivery(ref, "dead", 0, "payload can't be decrypted")
+	}
+
+	now := time.Now()
+	attempt := attempts + 1
+	next := now.Add(webhookBackoff(attempt))
+	applied, err := session.Query(
+		`UPDATE webhook_deliveries SET attempts = ?, next_attempt = ?, updated_at = ? WHERE webhook_id = ? AND delivery_id = ? IF status = 'pending' AND attempts = ?`,
+		attempt, next, now, ref.webhookID, ref.deliveryID, attempts,
+	).MapScanCAS(map[string]interface{}{})
+	if err != nil || !applied {
+		return err
+	}
+	err = session.Query(
+		`INSERT INTO webhook_due (bucket, next_attempt, delivery_id, webhook_id) VALUES (?, ?, ?, ?)`,
+		next.Truncate(webhookBucket), next, ref.deliveryID, ref.webhookID,
+	).Exec()
+	if err != nil {
+		log.Println("webhook queue:", err)
+	}
+
+	code, sendErr := sendWebhook(h, event, ref.deliveryID, body)
+	switch {
+	case sendErr == nil:
+		return finishDelivery(ref, "delivered", code, "")
+	case attempt >= webhookMaxAttempts:
+		return finishDelivery(ref, "dead", code, sendErr.Error())
+	}
+	return session.Query(
+		`UPDATE webhook_deliveries SET last_status = ?, last_error = ? WHERE webhook_id = ? AND delivery_id = ?`,
+		code, sendErr.Error(), ref.webhookID, ref.deliveryID,
+	).Exec()
+}
+
+func sendWebhook(h *webhook, event string, deliveryID gocql.UUID, body []byte) (int, error) {
+	req, err := http.NewRequest("POST", h.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "LibreLog-Webhook")
+	req.Header.Set("X-LibreLog-Event", event)
+	req.Header.Set("X-LibreLog-Delivery", deliveryID.String())
+	req.Header.Set("X-LibreLog-Signature", signWebhook(h.secret, time.Now(), body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func finishDelivery(ref deliveryRef, status string, code int, lastErr string) error {
+	err := session.Query(
+		`UPDATE webhook_deliveries SET status = ?, last_status = ?, last_error = ?, updated_at = ? WHERE webhook_id = ? AND delivery_id = ?`,
+		status, code, lastErr, time.Now(), ref.webhookID, ref.deliveryID,
+	).Exec()
+	if err != nil {
+		return err
+	}
+	if status == "dead" {
+		log.Printf("webhook %s: delivery %s failed for good: %s", ref.webhookID, ref.deliveryID, lastErr)
+	}
+	return nil
+}
+
+type dueAttempt struct {
+	ref deliveryRef
+	at  time.Time
+}
+
+// webhookScan is where the dispatcher's next scan of webhook_due starts,
+// and the attempts from there on it has already handed out. Only the
+// dispatcher goroutine touches it.
+var webhookScan struct {
+	from time.Time
+	sent map[dueAttempt]bool
+}
+
+// queueDueWebhooks hands attempts that have come due since the last scan
+// to the workers.
+func queueDueWebhooks(now time.Time) error {
+	if webhookScan.sent == nil {
+		webhookScan.from = now.Add(-webhookLookback)
+		webhookScan.sent = map[dueAttempt]bool{}
+	}
+	from := now.Add(-webhookSkew)
+	if webhookScan.from.After(from) {
+		from = webhookScan.from
+	}
+	full := false
+	for bucket := webhookScan.from.Truncate(webhookBucket); !bucket.After(now) && !full; bucket = bucket.Add(webhookBucket) {
+		iter := session.Query(
+			`SELECT next_attempt, delivery_id, webhook_id FROM webhook_due WHERE bucket = ? AND next_attempt >= ? AND next_attempt <= ?`,
+			bucket, webhookScan.from, now,
+		).Iter()
+		var a dueAttempt
+		for iter.Scan(&a.at, &a.ref.deliveryID, &a.ref.webhookID) {
+			if webhookScan.sent[a] {
+				continue
+			}
+			select {
+			case webhookWork <- a.ref:
+				webhookScan.sent[a] = true
+			default:
+				// The workers are behind; pick up from here next tick.
+				if a.at.Before(from) {
+					from = a.at
+				}
+				full = true
+			}
+			if full {
+				break
+			}
+		}
+		if err := iter.Close(); err != nil {
+			return err
+		}
+	}
+	webhookScan.from = from
+	for a := range webhookScan.sent {
+		if a.at.Before(from) {
+			delete(webhookScan.sent, a)
+		}
+	}
+	return nil
+}
+
+func startWebhookDispatcher() {
+	if err := refreshWebhooks(); err != nil {
+		log.Println("webhooks:", err)
+	}
+	for i := 0; i < webhookWorkers; i++ {
+		go func() {
+			for ref := range webhookWork {
+				if err := deliverWebhook(ref); err != nil {
+					log.Println("webhook delivery:", err)
+				}
+			}
+		}()
+	}
+	go func() {
+		refresh := time.Now()
+		for now := range time.Tick(webhookTick) {
+			if now.Sub(refresh) >= alertTick {
+				if err := refreshWebhooks(); err != nil {
+					log.Println("webhooks:", err)
+				}
+				refresh = now
+			}
+			if err := queueDueWebhooks(now); err != nil {
+				log.Println("webhook queue:", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,125 @@
+// AI-assisted code
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+func TestSendWebhook(t *testing.T) {
+	body := []byte(`{"event":"entry"}`)
+	got := make(chan *http.Request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if string(b) != string(body) {
+			t.Errorf("body = %s", b)
+		}
+		got <- r
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+	allowPrivateWebhooks(t)
+
+	h := &webhook{url: srv.URL, secret: "s3cret"}
+	deliveryID := gocql.TimeUUID()
+	code, err := sendWebhook(h, "entry", deliveryID, body)
+	if err != nil || code != http.StatusOK {
+		t.Fatalf("send: %d, %v", code, err)
+	}
+	r := <-got
+	if r.Header.Get("X-LibreLog-Event") != "entry" || r.Header.Get("X-LibreLog-Delivery") != deliveryID.String() {
+		t.Errorf("headers = %v", r.Header)
+	}
+	// Signatures verify with the inbound hook check, so one LibreLog can
+	// feed another.
+	if !verifyHookSignature("s3cret", r.Header.Get("X-LibreLog-Signature"), body, time.Now()) {
+		t.Error("signature doesn't verify")
+	}
+
+	h.url = srv.URL + "/fail"
+	if code, err := sendWebhook(h, "entry", deliveryID, body); err == nil || code != http.StatusBadGateway {
+		t.Errorf("failing endpoint: %d, %v", code, err)
+	}
+	<-got
+}
+
+// allowPrivateWebhooks lets a test send webhooks to httptest servers,
+// which listen on loopback.
+func allowPrivateWebhooks(t *testing.T) {
+	webhookAllowPrivate = true
+	t.Cleanup(func() { webhookAllowPrivate = false })
+}
+
+func TestWebhookPrivateAddresses(t *testing.T) {
+	tests := []struct {
+		addr string
+		ok   bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.10", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"64:ff9b::a00:1", false},
+	}
+	for _, tt := range tests {
+		if got := webhookAddrAllowed(netip.MustParseAddr(tt.addr)); got != tt.ok {
+			t.Errorf("%s: allowed = %v, want %v", tt.addr, got, tt.ok)
+		}
+	}
+
+	hit := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit = true
+	}))
+	defer srv.Close()
+	h := &webhook{url: srv.URL, secret: "s3cret"}
+	if _, err := sendWebhook(h, "entry", gocql.TimeUUID(), []byte(`{}`)); err == nil || !strings.Contains(err.Error(), "private address") {
+		t.Errorf("loopback: %v", err)
+	}
+	if hit {
+		t.Error("request reached a loopback server")
+	}
+}
+
+func TestWebhookRedirect(t *testing.T) {
+	allowPrivateWebhooks(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/moved" {
+			t.Error("redirect was followed")
+		}
+		http.Redirect(w, r, "/moved", http.StatusTemporaryRedirect)
+	}))
+	defer srv.Close()
+	h := &webhook{url: srv.URL, secret: "s3cret"}
+	if code, err := sendWebhook(h, "entry", gocql.TimeUUID(), []byte(`{}`)); err == nil || code != http.StatusTemporaryRedirect {
+		t.Errorf("redirect: %d, %v", code, err)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	want := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 16 * time.Minute, 32 * time.Minute, time.Hour, time.Hour}
+	for i, w := range want {
+		if got := webhookBackoff(i + 1); got != w {
+			t.Errorf("attempt %d: %v, want %v", i+1, got, w)
+		}
+	}
+}
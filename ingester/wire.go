@@ -0,0 +1,51 @@
+// AI-assisted code
+package main
+
+import (
+	"errors"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The Loki and Prometheus protobuf messages are small, so they're decoded
+// by hand instead of pulling in either project's generated packages.
+
+var errBadProto = errors.New("invalid protobuf")
+
+// walkProto calls fn for every field of a protobuf message. Length-delimited
+// fields are passed in b, varint and fixed fields in v.
+func walkProto(msg []byte, fn func(num protowire.Number, b []byte, v uint64) error) error {
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return errBadProto
+		}
+		msg = msg[n:]
+
+		var b []byte
+		var v uint64
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(msg)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(msg)
+		case protowire.Fixed32Type:
+			var v32 uint32
+			v32, n = protowire.ConsumeFixed32(msg)
+			v = uint64(v32)
+		case protowire.BytesType:
+			b, n = protowire.ConsumeBytes(msg)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, msg)
+		}
+		if n < 0 {
+			return errBadProto
+		}
+		msg = msg[n:]
+
+		if err := fn(num, b, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+// AI-assisted code
+
+// Package atrest encrypts data at rest. Each user has a data key in
+// data_keys, wrapped by a master key, and data is sealed with AES-GCM under
+// it; whatever holds sealed data also records the data key's id. Master
+// keys come from MASTER_KEY, newest first: the first wraps new data keys
+// and the rest only unwrap old ones until they've been rewrapped.
+package atrest
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+const dataKeySize = 32
+
+type masterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// Keyring holds the master keys.
+type Keyring struct {
+	current *masterKey
+	byID    map[string]*masterKey
+}
+
+// FromEnv reads MASTER_KEY, or the file named by MASTER_KEY_FILE. It
+// returns nil when neither is set.
+func FromEnv() (*Keyring, error) {
+	raw := os.Getenv("MASTER_KEY")
+	if path := os.Getenv("MASTER_KEY_FILE"); path != "" {
+		if raw != "" {
+			return nil, errors.New("set MASTER_KEY or MASTER_KEY_FILE, not both")
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		raw = string(b)
+	}
+	return ParseMasterKeys(raw)
+}
+
+// ParseMasterKeys parses base64 AES-256 keys separated by whitespace.
+func ParseMasterKeys(raw string) (*Keyring, error) {
+	fields := strings.Fields(raw)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	ring := &Keyring{byID: map[string]*masterKey{}}
+	for i, f := range fields {
+		b, err := base64.StdEncoding.DecodeString(f)
+		if err != nil || len(b) != 32 {
+			return nil, fmt.Errorf("key %d: want 32 random bytes in base64", i+1)
+		}
+		aead, err := newGCM(b)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(append([]byte("librelog master key\x00"), b...))
+		k := &masterKey{id: hex.EncodeToString(sum[:8]), aead: aead}
+		if ring.byID[k.id] != nil {
+			return nil, fmt.Errorf("key %d: listed twice", i+1)
+		}
+		ring.byID[k.id] = k
+		if ring.current == nil {
+			ring.current = k
+		}
+	}
+	return ring, nil
+}
+
+// CurrentID is the id of the master key new data keys are wrapped with.
+func (r *Keyring) CurrentID() string { return r.current.id }
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// dataKeyAD ties a wrapped data key to its row, so it can't be swapped for
+// another user's.
+func dataKeyAD(userID, keyID gocql.UUID) []byte {
+	return append(userID.Bytes(), keyID.Bytes()...)
+}
+
+// wrap encrypts a data key under the current master key.
+func (r *Keyring) wrap(userID, keyID gocql.UUID, raw []byte) (string, []byte, error) {
+	k := r.current
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return k.id, k.aead.Seal(nonce, nonce, raw, dataKeyAD(userID, keyID)), nil
+}
+
+func (r *Keyring) unwrap(userID, keyID gocql.UUID, masterID string, wrapped []byte) ([]byte, error) {
+	k := r.byID[masterID]
+	if k == nil {
+		return nil, fmt.Errorf("data key %s is wrapped by master key %s, which isn't in MASTER_KEY", keyID, masterID)
+	}
+	n := k.aead.NonceSize()
+	if len(wrapped) < n {
+		return nil, fmt.Errorf("data key %s is truncated", keyID)
+	}
+	raw, err := k.aead.Open(nil, wrapped[:n], wrapped[n:], dataKeyAD(userID, keyID))
+	if err != nil {
+		return nil, fmt.Errorf("data key %s doesn't unwrap with master key %s", keyID, masterID)
+	}
+	return raw, nil
+}
+
+// DataKey seals and opens one user's data.
+type DataKey struct {
+	id   gocql.UUID
+	aead cipher.AEAD
+}
+
+func newDataKey(id gocql.UUID, raw []byte) (*DataKey, error) {
+	if len(raw) != dataKeySize {
+		return nil, fmt.Errorf("data key %s has %d bytes", id, len(raw))
+	}
+	aead, err := newGCM(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &DataKey{id: id, aead: aead}, nil
+}
+
+// ID is what sealed data records to name the key.
+func (k *DataKey) ID() gocql.UUID { return k.id }
+
+// Seal encrypts data as base64 of the nonce and the ciphertext, for text
+// columns. ad ties the result to where it's stored, so it won't open
+// anywhere else: see EntryAD and friends.
+func (k *DataKey) Seal(ad, data []byte) (string, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(k.aead.Seal(nonce, nonce, data, ad)), nil
+}
+
+// Open decrypts what Seal returned, given the same ad.
+func (k *DataKey) Open(ad []byte, sealed string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(sealed)
+	n := k.aead.NonceSize()
+	if err != nil || len(b) < n {
+		return nil, errors.New("sealed data isn't base64")
+	}
+	plain, err := k.aead.Open(nil, b[:n], b[n:], ad)
+	if err != nil {
+		return nil, fmt.Errorf("data doesn't decrypt with data key %s", k.id)
+	}
+	return plain, nil
+}
+
+// EntryAD ties an entry's data to its row in logs. recv_time is in
+// milliseconds, which is all Cassandra keeps.
+func EntryAD(userID gocql.UUID, logID string, recvTime time.Time) []byte {
+	ad := binary.BigEndian.AppendUint64(userID.Bytes(), uint64(recvTime.UnixMilli()))
+	return append(ad, logID...)
+}
+
+// AlertEventAD ties an alert event's data to its row in alert_history.
+func AlertEventAD(userID gocql.UUID, logID string, eventID gocql.UUID) []byte {
+	ad := append([]byte("alert_history"), userID.Bytes()...)
+	ad = append(ad, eventID.Bytes()...)
+	return append(ad, logID...)
+}
+
+// DeliveryAD ties a webhook delivery's payload to its row in
+// webhook_deliveries.
+func DeliveryAD(webhookID, deliveryID gocql.UUID) []byte {
+	ad := append([]byte("webhook_deliveries"), webhookID.Bytes()...)
+	return append(ad, deliveryID.Bytes()...)
+}
@@ -0,0 +1,121 @@
+// AI-assisted code
+package atrest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+func testMasterKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func TestParseMasterKeys(t *testing.T) {
+	ring, err := ParseMasterKeys(" \n")
+	if ring != nil || err != nil {
+		t.Errorf("empty: %v %v", ring, err)
+	}
+	ring, err = ParseMasterKeys(testMasterKey(1) + "\n" + testMasterKey(2) + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ring.byID) != 2 || ring.current != ring.byID[ring.current.id] || len(ring.current.id) != 16 {
+		t.Errorf("ring %+v", ring)
+	}
+
+	tests := []struct {
+		keys string
+		want string
+	}{
+		{"not-base64!", "key 1: want 32 random bytes"},
+		{testMasterKey(1) + " " + base64.StdEncoding.EncodeToString([]byte("short")), "key 2: want 32 random bytes"},
+		{testMasterKey(1) + " " + testMasterKey(1), "key 2: listed twice"},
+	}
+	for _, tt := range tests {
+		_, err := ParseMasterKeys(tt.keys)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want %q", tt.keys, err, tt.want)
+		}
+	}
+}
+
+func TestWrapAndSeal(t *testing.T) {
+	oldRing, _ := ParseMasterKeys(testMasterKey(1))
+	ring, _ := ParseMasterKeys(testMasterKey(2) + " " + testMasterKey(1))
+	userID, keyID := gocql.TimeUUID(), gocql.TimeUUID()
+	raw := bytes.Repeat([]byte{7}, dataKeySize)
+
+	// A data key wrapped before the rotation still unwraps, and rewraps
+	// under the new master key.
+	oldID, wrapped, err := oldRing.wrap(userID, keyID, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ring.unwrap(userID, keyID, oldID, wrapped)
+	if err != nil || !bytes.Equal(got, raw) {
+		t.Fatalf("unwrap: %v", err)
+	}
+	newID, wrapped, _ := ring.wrap(userID, keyID, raw)
+	if newID == oldID || newID != ring.current.id {
+		t.Errorf("rewrapped under %s", newID)
+	}
+	if _, err := oldRing.unwrap(userID, keyID, newID, wrapped); err == nil || !strings.Contains(err.Error(), "isn't in MASTER_KEY") {
+		t.Errorf("unknown master key: %v", err)
+	}
+	if _, err := ring.unwrap(gocql.TimeUUID(), keyID, newID, wrapped); err == nil {
+		t.Error("unwrapped for another user")
+	}
+
+	key, err := newDataKey(keyID, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recvTime := time.Date(2026, 10, 19, 12, 0, 0, 123456789, time.UTC)
+	sealed, err := key.Seal(EntryAD(userID, "vitals", recvTime), []byte(`{"bpm": 61}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, "bpm") {
+		t.Errorf("sealed %s", sealed)
+	}
+	// Cassandra hands recv_time back in milliseconds.
+	plain, err := key.Open(EntryAD(userID, "vitals", recvTime.Truncate(time.Millisecond)), sealed)
+	if err != nil || string(plain) != `{"bpm": 61}` {
+		t.Errorf("open: %s %v", plain, err)
+	}
+	if _, err := key.Open(EntryAD(userID, "journal", recvTime), sealed); err == nil {
+		t.Error("opened in another logset")
+	}
+	if _, err := key.Open(EntryAD(userID, "vitals", recvTime.Add(time.Millisecond)), sealed); err == nil {
+		t.Error("opened at another time")
+	}
+}
+
+func TestRowAD(t *testing.T) {
+	key, err := newDataKey(gocql.TimeUUID(), bytes.Repeat([]byte{7}, dataKeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, eventID := gocql.TimeUUID(), gocql.TimeUUID()
+	sealed, _ := key.Seal(AlertEventAD(userID, "vitals", eventID), []byte(`{"bpm": 190}`))
+	if plain, err := key.Open(AlertEventAD(userID, "vitals", eventID), sealed); err != nil || string(plain) != `{"bpm": 190}` {
+		t.Errorf("open: %s %v", plain, err)
+	}
+	if _, err := key.Open(AlertEventAD(userID, "vitals", gocql.TimeUUID()), sealed); err == nil {
+		t.Error("opened as another event")
+	}
+
+	webhookID, deliveryID := gocql.TimeUUID(), gocql.TimeUUID()
+	sealed, _ = key.Seal(DeliveryAD(webhookID, deliveryID), []byte(`{"event": "alert"}`))
+	if _, err := key.Open(DeliveryAD(webhookID, deliveryID), sealed); err != nil {
+		t.Errorf("open: %v", err)
+	}
+	if _, err := key.Open(DeliveryAD(deliveryID, webhookID), sealed); err == nil {
+		t.Error("opened as another delivery")
+	}
+}
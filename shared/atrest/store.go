@@ -0,0 +1,210 @@
+// AI-assisted code
+package atrest
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Store finds users' data keys in data_keys. Data keys never change, only
+// how they're wrapped, so they're cached for good.
+type Store struct {
+	session *gocql.Session
+	keys    *Keyring
+
+	mu      sync.Mutex
+	current map[gocql.UUID]*DataKey
+	byID    map[[2]gocql.UUID]*DataKey
+}
+
+// NewStore returns a store using keys, which is nil when encryption at
+// rest is off.
+func NewStore(session *gocql.Session, keys *Keyring) *Store {
+	return &Store{
+		session: session,
+		keys:    keys,
+		current: map[gocql.UUID]*DataKey{},
+		byID:    map[[2]gocql.UUID]*DataKey{},
+	}
+}
+
+// Enabled reports whether new data should be sealed.
+func (s *Store) Enabled() bool { return s != nil && s.keys != nil }
+
+// CurrentMasterID is the id of the master key new data keys are wrapped
+// with.
+func (s *Store) CurrentMasterID() string { return s.keys.CurrentID() }
+
+// Current returns the data key new data for a user is sealed with, making
+// one the first time. If two ingesters both make one, the user ends up
+// with two; sealed data names the key it was sealed with, so either works.
+func (s *Store) Current(userID gocql.UUID) (*DataKey, error) {
+	s.mu.Lock()
+	key := s.current[userID]
+	s.mu.Unlock()
+	if key != nil {
+		return key, nil
+	}
+	if s.keys == nil {
+		return nil, errors.New("MASTER_KEY isn't set")
+	}
+
+	var keyID gocql.UUID
+	var masterID string
+	var wrapped []byte
+	err := s.session.Query(
+		`SELECT key_id, master_id, wrapped FROM data_keys WHERE user_id = ? LIMIT 1`, userID,
+	).Scan(&keyID, &masterID, &wrapped)
+	var raw []byte
+	if err == gocql.ErrNotFound {
+		raw = make([]byte, dataKeySize)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		keyID = gocql.TimeUUID()
+		if masterID, wrapped, err = s.keys.wrap(userID, keyID, raw); err != nil {
+			return nil, err
+		}
+		err = s.session.Query(
+			`INSERT INTO data_keys (user_id, key_id, master_id, wrapped, created_at) VALUES (?, ?, ?, ?, ?)`,
+			userID, keyID, masterID, wrapped, time.Now(),
+		).Exec()
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else if raw, err = s.keys.unwrap(userID, keyID, masterID, wrapped); err != nil {
+		return nil, err
+	}
+	if key, err = newDataKey(keyID, raw); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.current[userID] = key
+	s.byID[[2]gocql.UUID{userID, keyID}] = key
+	s.mu.Unlock()
+	return key, nil
+}
+
+// Load returns the data key something was sealed with.
+func (s *Store) Load(userID, keyID gocql.UUID) (*DataKey, error) {
+	ref := [2]gocql.UUID{userID, keyID}
+	s.mu.Lock()
+	key := s.byID[ref]
+	s.mu.Unlock()
+	if key != nil {
+		return key, nil
+	}
+	if s.keys == nil {
+		return nil, errors.New("data is encrypted at rest, but MASTER_KEY isn't set")
+	}
+
+	var masterID string
+	var wrapped []byte
+	err := s.session.Query(
+		`SELECT master_id, wrapped FROM data_keys WHERE user_id = ? AND key_id = ?`, userID, keyID,
+	).Scan(&masterID, &wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("data key %s: %w", keyID, err)
+	}
+	raw, err := s.keys.unwrap(userID, keyID, masterID, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if key, err = newDataKey(keyID, raw); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.byID[ref] = key
+	s.mu.Unlock()
+	return key, nil
+}
+
+// Seal seals a user's data with their current data key. The key id comes
+// back ready to bind to a key_id column: with encryption off, data is
+// returned as it is and the id is gocql.UnsetValue, so plain rows don't
+// write a null.
+func (s *Store) Seal(userID gocql.UUID, ad, data []byte) (string, interface{}, error) {
+	if !s.Enabled() {
+		return string(data), gocql.UnsetValue, nil
+	}
+	key, err := s.Current(userID)
+	if err != nil {
+		return "", nil, err
+	}
+	sealed, err := key.Seal(ad, data)
+	if err != nil {
+		return "", nil, err
+	}
+	return sealed, key.ID(), nil
+}
+
+// Open opens sealed data, or returns it as it is when keyID is zero, for
+// data stored in plain text.
+func (s *Store) Open(userID, keyID gocql.UUID, ad []byte, data string) ([]byte, error) {
+	if keyID == (gocql.UUID{}) {
+		return []byte(data), nil
+	}
+	key, err := s.Load(userID, keyID)
+	if err != nil {
+		return nil, err
+	}
+	return key.Open(ad, data)
+}
+
+// Rewrap moves data keys wrapped by old master keys to the current one.
+// Sealed data doesn't change: it stays sealed with the same data keys.
+// Keys it can't rewrap are counted as stuck, with the first error.
+func (s *Store) Rewrap() (rewrapped, stuck int, err error) {
+	iter := s.session.Query(`SELECT user_id, key_id, master_id, wrapped FROM data_keys`).Iter()
+	var userID, keyID gocql.UUID
+	var masterID string
+	var wrapped []byte
+	var firstErr error
+	fail := func(err error) {
+		stuck++
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	for iter.Scan(&userID, &keyID, &masterID, &wrapped) {
+		if masterID == s.keys.current.id {
+			continue
+		}
+		raw, err := s.keys.unwrap(userID, keyID, masterID, wrapped)
+		if err != nil {
+			fail(err)
+			continue
+		}
+		newID, newWrapped, err := s.keys.wrap(userID, keyID, raw)
+		if err != nil {
+			fail(err)
+			continue
+		}
+		// Conditional, so a service still on the old MASTER_KEY can't
+		// undo a rewrap.
+		applied, err := s.session.Query(
+			`UPDATE data_keys SET master_id = ?, wrapped = ? WHERE user_id = ? AND key_id = ? IF master_id = ?`,
+			newID, newWrapped, userID, keyID, masterID,
+		).MapScanCAS(map[string]interface{}{})
+		if err != nil {
+			fail(err)
+			continue
+		}
+		if applied {
+			rewrapped++
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return rewrapped, stuck, err
+	}
+	return rewrapped, stuck, firstErr
+}
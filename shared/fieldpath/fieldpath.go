@@ -0,0 +1,60 @@
+// AI-assisted code
+
+// Package fieldpath reads and writes fields of JSON objects decoded into
+// maps, named by dotted paths into nested objects: "user.name" is the name
+// field of the user object.
+package fieldpath
+
+import "strings"
+
+// Get reads a field.
+func Get(entry map[string]interface{}, path string) (interface{}, bool) {
+	keys := strings.Split(path, ".")
+	m := entry
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := m[key].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		m = next
+	}
+	v, ok := m[keys[len(keys)-1]]
+	return v, ok
+}
+
+// Set sets a field, creating parent objects as needed. It fails if a
+// parent exists but isn't an object.
+func Set(entry map[string]interface{}, path string, v interface{}) bool {
+	keys := strings.Split(path, ".")
+	m := entry
+	for _, key := range keys[:len(keys)-1] {
+		child, exists := m[key]
+		if !exists {
+			next := map[string]interface{}{}
+			m[key] = next
+			m = next
+			continue
+		}
+		next, ok := child.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		m = next
+	}
+	m[keys[len(keys)-1]] = v
+	return true
+}
+
+// Delete removes a field, if it's there.
+func Delete(entry map[string]interface{}, path string) {
+	keys := strings.Split(path, ".")
+	m := entry
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := m[key].(map[string]interface{})
+		if !ok {
+			return
+		}
+		m = next
+	}
+	delete(m, keys[len(keys)-1])
+}
@@ -0,0 +1,173 @@
+// AI-assisted code
+package pipeline
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"shared/fieldpath"
+)
+
+// Grok patterns are regular expressions with named building blocks:
+// %{NAME} matches a pattern, %{NAME:field} also stores what it matched,
+// and %{NAME:field:int} or %{NAME:field:float} stores it as a number.
+// Patterns can add their own blocks, which may refer to each other and to
+// the built-in ones.
+
+const maxGrokDepth = 16
+
+var grokBuiltins = map[string]string{
+	"USERNAME":     `[a-zA-Z0-9._-]+`,
+	"USER":         `%{USERNAME}`,
+	"EMAILADDRESS": `[a-zA-Z0-9._%+-]+@%{HOSTNAME}`,
+	"INT":          `[+-]?[0-9]+`,
+	"BASE10NUM":    `[+-]?(?:[0-9]+(?:\.[0-9]*)?|\.[0-9]+)`,
+	"NUMBER":       `%{BASE10NUM}`,
+	"POSINT":       `[1-9][0-9]*`,
+	"NONNEGINT":    `[0-9]+`,
+	"WORD":         `\b\w+\b`,
+	"NOTSPACE":     `\S+`,
+	"SPACE":        `\s*`,
+	"DATA":         `.*?`,
+	"GREEDYDATA":   `.*`,
+	"QUOTEDSTRING": `"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'`,
+	"QS":           `%{QUOTEDSTRING}`,
+	"UUID":         `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+	"MAC":          `(?:[A-Fa-f0-9]{2}[:-]){5}[A-Fa-f0-9]{2}`,
+
+	"IPV4":     `(?:(?:25[0-5]|2[0-4][0-9]|1?[0-9]?[0-9])\.){3}(?:25[0-5]|2[0-4][0-9]|1?[0-9]?[0-9])`,
+	"IPV6":     `(?:[A-Fa-f0-9]{0,4}:){2,7}(?:%{IPV4}|[A-Fa-f0-9]{0,4})`,
+	"IP":       `(?:%{IPV6}|%{IPV4})`,
+	"HOSTNAME": `\b[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\.?\b`,
+	"IPORHOST": `(?:%{IP}|%{HOSTNAME})`,
+	"HOSTPORT": `%{IPORHOST}:%{POSINT}`,
+
+	"URIPATH":      `(?:/[A-Za-z0-9$.+!*'(){},~:;=@#%&_\-]*)+`,
+	"URIPARAM":     `\?[A-Za-z0-9$.+!*'|(){},~@#%&/=:;_?\-\[\]<>]*`,
+	"URIPATHPARAM": `%{URIPATH}(?:%{URIPARAM})?`,
+	"URI":          `[A-Za-z][A-Za-z0-9+\-.]*://\S+`,
+
+	"LOGLEVEL": `(?i:trace|debug|info(?:rmation)?|notice|warn(?:ing)?|err(?:or)?|crit(?:ical)?|fatal|severe|alert|emerg(?:ency)?)`,
+
+	"MONTH":             `\b(?:[Jj]an(?:uary)?|[Ff]eb(?:ruary)?|[Mm]ar(?:ch)?|[Aa]pr(?:il)?|[Mm]ay|[Jj]une?|[Jj]uly?|[Aa]ug(?:ust)?|[Ss]ep(?:tember)?|[Oo]ct(?:ober)?|[Nn]ov(?:ember)?|[Dd]ec(?:ember)?)\b`,
+	"MONTHNUM":          `(?:1[0-2]|0?[1-9])`,
+	"MONTHDAY":          `(?:3[01]|[12][0-9]|0?[1-9])`,
+	"YEAR":              `[0-9]{4}`,
+	"HOUR":              `(?:2[0-3]|[01]?[0-9])`,
+	"MINUTE":            `[0-5][0-9]`,
+	"SECOND":            `(?:60|[0-5]?[0-9])(?:[.,][0-9]+)?`,
+	"TIME":              `%{HOUR}:%{MINUTE}(?::%{SECOND})?`,
+	"ISO8601_TIMEZONE":  `(?:Z|[+-]%{HOUR}(?::?%{MINUTE}))`,
+	"TIMESTAMP_ISO8601": `%{YEAR}-%{MONTHNUM}-%{MONTHDAY}[T ]%{HOUR}:?%{MINUTE}(?::?%{SECOND})?%{ISO8601_TIMEZONE}?`,
+	"HTTPDATE":          `%{MONTHDAY}/%{MONTH}/%{YEAR}:%{TIME} %{INT}`,
+	"SYSLOGTIMESTAMP":   `%{MONTH} +%{MONTHDAY} %{TIME}`,
+
+	"COMMONAPACHELOG":   `%{IPORHOST:clientip} %{USER:ident} %{USER:auth} \[%{HTTPDATE:timestamp}\] "(?:%{WORD:verb} %{NOTSPACE:request}(?: HTTP/%{NUMBER:httpversion})?|%{DATA:rawrequest})" %{NUMBER:response:int} (?:%{NUMBER:bytes:int}|-)`,
+	"COMBINEDAPACHELOG": `%{COMMONAPACHELOG} %{QS:referrer} %{QS:agent}`,
+}
+
+var grokRef = regexp.MustCompile(`%\{(\w+)(?::([\w.@-]+))?(?::(int|float))?\}`)
+
+type grokCapture struct {
+	field string
+	kind  string
+}
+
+// grokProcessor parses a string field, by default message, with a grok
+// pattern. Entries that don't match are left as they are.
+type grokProcessor struct {
+	Type     string            `json:"type"`
+	Field    string            `json:"field"`
+	Pattern  string            `json:"pattern"`
+	Patterns map[string]string `json:"patterns"`
+	re       *regexp.Regexp
+	captures []grokCapture
+}
+
+func (g *grokProcessor) compile() error {
+	if g.Pattern == "" {
+		return fmt.Errorf("pattern required")
+	}
+	expr, err := g.expand(g.Pattern, 0)
+	if err != nil {
+		return err
+	}
+	if len(g.captures) == 0 {
+		return fmt.Errorf("pattern needs a field, like %%{WORD:method}")
+	}
+	g.re, err = regexp.Compile(expr)
+	return err
+}
+
+// expand replaces pattern references with their regular expressions, and
+// references with a field by a capture group.
+func (g *grokProcessor) expand(pattern string, depth int) (string, error) {
+	if depth > maxGrokDepth {
+		return "", fmt.Errorf("patterns refer to each other too deeply")
+	}
+	var sb strings.Builder
+	last := 0
+	for _, m := range grokRef.FindAllStringSubmatchIndex(pattern, -1) {
+		sb.WriteString(pattern[last:m[0]])
+		last = m[1]
+
+		name := pattern[m[2]:m[3]]
+		def, ok := g.Patterns[name]
+		if !ok {
+			def, ok = grokBuiltins[name]
+		}
+		if !ok {
+			return "", fmt.Errorf("unknown pattern %s", name)
+		}
+		expr, err := g.expand(def, depth+1)
+		if err != nil {
+			return "", err
+		}
+		if m[4] < 0 {
+			sb.WriteString("(?:" + expr + ")")
+			continue
+		}
+		c := grokCapture{field: pattern[m[4]:m[5]]}
+		if m[6] >= 0 {
+			c.kind = pattern[m[6]:m[7]]
+		}
+		fmt.Fprintf(&sb, "(?P<grok%d>%s)", len(g.captures), expr)
+		g.captures = append(g.captures, c)
+	}
+	sb.WriteString(pattern[last:])
+	return sb.String(), nil
+}
+
+func (g *grokProcessor) apply(entry map[string]interface{}) bool {
+	v, _ := fieldpath.Get(entry, g.Field)
+	s, ok := v.(string)
+	if !ok {
+		return true
+	}
+	m := g.re.FindStringSubmatchIndex(s)
+	if m == nil {
+		return true
+	}
+	for i, name := range g.re.SubexpNames() {
+		n, err := strconv.Atoi(strings.TrimPrefix(name, "grok"))
+		if !strings.HasPrefix(name, "grok") || err != nil || n >= len(g.captures) || m[2*i] < 0 {
+			continue
+		}
+		c := g.captures[n]
+		var value interface{} = s[m[2*i]:m[2*i+1]]
+		switch c.kind {
+		case "int":
+			if f, ok := pipelineNumber(value); ok {
+				value = float64(int64(f))
+			}
+		case "float":
+			if f, ok := pipelineNumber(value); ok {
+				value = f
+			}
+		}
+		fieldpath.Set(entry, c.field, value)
+	}
+	return true
+}
@@ -0,0 +1,518 @@
+// AI-assisted code
+
+// Package pipeline runs logset pipelines: ordered lists of processors the
+// ingester runs on each entry before it's checked against the logset's
+// schema and stored. The web API uses it too, to check pipelines when
+// they're saved and for dry runs.
+//
+// Processors work on JSON objects. Entries that are strings or not JSON at
+// all go in as {"message": "<text>"}, other JSON values as
+// {"message": <value>}, so a logset with a pipeline only stores objects.
+// Field names are dotted paths into nested objects.
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"shared/fieldpath"
+)
+
+const maxProcessors = 32
+
+type processor interface {
+	// apply transforms entry in place, returning false to drop it.
+	apply(entry map[string]interface{}) bool
+}
+
+// Pipeline is a compiled pipeline.
+type Pipeline struct {
+	processors []processor
+	// Random decides sampling; dry runs replace it to keep every entry.
+	Random func() float64
+}
+
+// Compile parses and checks a pipeline definition, a JSON array of
+// processors.
+func Compile(raw []byte) (*Pipeline, error) {
+	var specs []json.RawMessage
+	if err := json.Unmarshal(raw, &specs); err != nil {
+		return nil, fmt.Errorf("pipeline must be an array of processors")
+	}
+	if len(specs) > maxProcessors {
+		return nil, fmt.Errorf("pipeline can have at most %d processors", maxProcessors)
+	}
+	p := &Pipeline{Random: rand.Float64}
+	for i, spec := range specs {
+		var head struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(spec, &head); err != nil {
+			return nil, fmt.Errorf("processor %d: must be an object", i)
+		}
+		proc, err := compileProcessor(head.Type, spec, p)
+		if err != nil {
+			return nil, fmt.Errorf("processor %d (%s): %v", i, head.Type, err)
+		}
+		p.processors = append(p.processors, proc)
+	}
+	return p, nil
+}
+
+// decodeProcessor decodes a processor's options into v, refusing unknown
+// ones so typos don't go unnoticed.
+func decodeProcessor(spec []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(spec))
+	dec.DisallowUnknownFields()
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func compileProcessor(typ string, spec []byte, p *Pipeline) (processor, error) {
+	switch typ {
+	case "rename":
+		var proc renameProcessor
+		if err := decodeProcessor(spec, &proc); err != nil {
+			return nil, err
+		}
+		if len(proc.Fields) == 0 {
+			return nil, fmt.Errorf("fields required")
+		}
+		return &proc, nil
+	case "drop", "keep":
+		var proc fieldListProcessor
+		if err := decodeProcessor(spec, &proc); err != nil {
+			return nil, err
+		}
+		if len(proc.Fields) == 0 {
+			return nil, fmt.Errorf("fields required")
+		}
+		proc.keep = typ == "keep"
+		return &proc, nil
+	case "coerce":
+		var proc coerceProcessor
+		if err := decodeProcessor(spec, &proc); err != nil {
+			return nil, err
+		}
+		if len(proc.Fields) == 0 {
+			return nil, fmt.Errorf("fields required")
+		}
+		for field, to := range proc.Fields {
+			switch to {
+			case "string", "number", "integer", "boolean":
+			default:
+				return nil, fmt.Errorf("%s: type must be string, number, integer or boolean", field)
+			}
+		}
+		return &proc, nil
+	case "convert":
+		var proc convertProcessor
+		if err := decodeProcessor(spec, &proc); err != nil {
+			return nil, err
+		}
+		if proc.Field == "" {
+			return nil, fmt.Errorf("field required")
+		}
+		from, ok1 := units[proc.From]
+		to, ok2 := units[proc.To]
+		switch {
+		case !ok1:
+			return nil, fmt.Errorf("unknown unit %q", proc.From)
+		case !ok2:
+			return nil, fmt.Errorf("unknown unit %q", proc.To)
+		case from.dimension != to.dimension:
+			return nil, fmt.Errorf("can't convert %s (%s) to %s (%s)", proc.From, from.dimension, proc.To, to.dimension)
+		}
+		if proc.Precision != nil && (*proc.Precision < 0 || *proc.Precision > 15) {
+			return nil, fmt.Errorf("precision must be 0-15")
+		}
+		proc.from, proc.to = from, to
+		return &proc, nil
+	case "extract":
+		var proc extractProcessor
+		if err := decodeProcessor(spec, &proc); err != nil {
+			return nil, err
+		}
+		if proc.Field == "" {
+			return nil, fmt.Errorf("field required")
+		}
+		re, err := regexp.Compile(proc.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range re.SubexpNames() {
+			if name != "" {
+				proc.names = append(proc.names, name)
+			}
+		}
+		if len(proc.names) == 0 {
+			return nil, fmt.Errorf("pattern needs a named group, like (?P<user>\\w+)")
+		}
+		proc.re = re
+		return &proc, nil
+	case "grok":
+		var proc grokProcessor
+		if err := decodeProcessor(spec, &proc); err != nil {
+			return nil, err
+		}
+		if proc.Field == "" {
+			proc.Field = "message"
+		}
+		if err := proc.compile(); err != nil {
+			return nil, err
+		}
+		return &proc, nil
+	case "enrich":
+		var proc enrichProcessor
+		if err := decodeProcessor(spec, &proc); err != nil {
+			return nil, err
+		}
+		if len(proc.Fields) == 0 {
+			return nil, fmt.Errorf("fields required")
+		}
+		return &proc, nil
+	case "sample":
+		var proc sampleProcessor
+		if err := decodeProcessor(spec, &proc); err != nil {
+			return nil, err
+		}
+		if !(proc.Rate > 0 && proc.Rate <= 1) {
+			return nil, fmt.Errorf("rate must be greater than 0 and at most 1")
+		}
+		proc.p = p
+		return &proc, nil
+	case "":
+		return nil, fmt.Errorf("type required")
+	}
+	return nil, fmt.Errorf("unknown processor type")
+}
+
+// Run passes an entry through the pipeline, returning the entry to store
+// and whether to store it at all.
+func (p *Pipeline) Run(data []byte) ([]byte, bool) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if dec.Decode(&v) != nil || dec.More() {
+		v = string(data)
+	}
+	entry, ok := v.(map[string]interface{})
+	if !ok {
+		entry = map[string]interface{}{"message": v}
+	}
+	for _, proc := range p.processors {
+		if !proc.apply(entry) {
+			return nil, false
+		}
+	}
+	out, err := json.Marshal(entry)
+	if err != nil {
+		return data, true
+	}
+	return out, true
+}
+
+// pipelineNumber reads a number or numeric string.
+func pipelineNumber(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case json.Number:
+		f, err := x.Float64()
+		return f, err == nil
+	case float64:
+		return x, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		return f, err == nil && !math.IsInf(f, 0) && !math.IsNaN(f)
+	}
+	return 0, false
+}
+
+// renameProcessor moves fields, all at once, so {"a": "b", "b": "a"} swaps
+// them. Fields that aren't there are skipped.
+type renameProcessor struct {
+	Type   string            `json:"type"`
+	Fields map[string]string `json:"fields"`
+}
+
+func (r *renameProcessor) apply(entry map[string]interface{}) bool {
+	from := make([]string, 0, len(r.Fields))
+	for f := range r.Fields {
+		from = append(from, f)
+	}
+	sort.Strings(from)
+	values := map[string]interface{}{}
+	for _, f := range from {
+		if v, ok := fieldpath.Get(entry, f); ok {
+			values[f] = v
+			fieldpath.Delete(entry, f)
+		}
+	}
+	for _, f := range from {
+		if v, ok := values[f]; ok && !fieldpath.Set(entry, r.Fields[f], v) {
+			fieldpath.Set(entry, f, v)
+		}
+	}
+	return true
+}
+
+type fieldListProcessor struct {
+	Type   string   `json:"type"`
+	Fields []string `json:"fields"`
+	keep   bool
+}
+
+func (d *fieldListProcessor) apply(entry map[string]interface{}) bool {
+	if !d.keep {
+		for _, f := range d.Fields {
+			fieldpath.Delete(entry, f)
+		}
+		return true
+	}
+	kept := map[string]interface{}{}
+	for _, f := range d.Fields {
+		if v, ok := fieldpath.Get(entry, f); ok {
+			fieldpath.Set(kept, f, v)
+		}
+	}
+	for k := range entry {
+		delete(entry, k)
+	}
+	for k, v := range kept {
+		entry[k] = v
+	}
+	return true
+}
+
+// coerceProcessor converts fields to a JSON type. Values that don't
+// convert are left as they are.
+type coerceProcessor struct {
+	Type   string            `json:"type"`
+	Fields map[string]string `json:"fields"`
+}
+
+func (c *coerceProcessor) apply(entry map[string]interface{}) bool {
+	for field, to := range c.Fields {
+		v, ok := fieldpath.Get(entry, field)
+		if !ok {
+			continue
+		}
+		if out, ok := coerce(v, to); ok {
+			fieldpath.Set(entry, field, out)
+		}
+	}
+	return true
+}
+
+func coerce(v interface{}, to string) (interface{}, bool) {
+	switch to {
+	case "string":
+		switch x := v.(type) {
+		case string:
+			return x, true
+		case json.Number:
+			return x.String(), true
+		case bool:
+			return strconv.FormatBool(x), true
+		case nil:
+			return nil, false
+		}
+		b, err := json.Marshal(v)
+		return string(b), err == nil
+	case "number", "integer":
+		var f float64
+		switch x := v.(type) {
+		case bool:
+			if x {
+				f = 1
+			}
+		default:
+			var ok bool
+			if f, ok = pipelineNumber(v); !ok {
+				return nil, false
+			}
+		}
+		if to == "integer" {
+			f = math.Trunc(f)
+		}
+		return f, true
+	case "boolean":
+		switch x := v.(type) {
+		case bool:
+			return x, true
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(x))
+			if err != nil {
+				switch strings.ToLower(strings.TrimSpace(x)) {
+				case "yes", "on":
+					return true, true
+				case "no", "off":
+					return false, true
+				}
+				return nil, false
+			}
+			return b, true
+		case json.Number:
+			f, err := x.Float64()
+			return f != 0, err == nil
+		}
+	}
+	return nil, false
+}
+
+// unit converts to and from its dimension's base unit:
+// base = value*scale + offset.
+type unit struct {
+	dimension     string
+	scale, offset float64
+}
+
+var units = map[string]unit{
+	"C": {"temperature", 1, 273.15},
+	"F": {"temperature", 5.0 / 9, 273.15 - 32*5.0/9},
+	"K": {"temperature", 1, 0},
+
+	"mm": {"length", 0.001, 0},
+	"cm": {"length", 0.01, 0},
+	"m":  {"length", 1, 0},
+	"km": {"length", 1000, 0},
+	"in": {"length", 0.0254, 0},
+	"ft": {"length", 0.3048, 0},
+	"yd": {"length", 0.9144, 0},
+	"mi": {"length", 1609.344, 0},
+
+	"mg": {"mass", 1e-6, 0},
+	"g":  {"mass", 0.001, 0},
+	"kg": {"mass", 1, 0},
+	"oz": {"mass", 0.028349523125, 0},
+	"lb": {"mass", 0.45359237, 0},
+
+	"ns":  {"duration", 1e-9, 0},
+	"us":  {"duration", 1e-6, 0},
+	"ms":  {"duration", 0.001, 0},
+	"s":   {"duration", 1, 0},
+	"min": {"duration", 60, 0},
+	"h":   {"duration", 3600, 0},
+	"d":   {"duration", 86400, 0},
+
+	"B":   {"data", 1, 0},
+	"KB":  {"data", 1e3, 0},
+	"MB":  {"data", 1e6, 0},
+	"GB":  {"data", 1e9, 0},
+	"TB":  {"data", 1e12, 0},
+	"KiB": {"data", 1 << 10, 0},
+	"MiB": {"data", 1 << 20, 0},
+	"GiB": {"data", 1 << 30, 0},
+	"TiB": {"data", 1 << 40, 0},
+
+	"m/s":  {"speed", 1, 0},
+	"km/h": {"speed", 1 / 3.6, 0},
+	"mph":  {"speed", 0.44704, 0},
+	"kn":   {"speed", 1852.0 / 3600, 0},
+
+	"Pa":   {"pressure", 1, 0},
+	"hPa":  {"pressure", 100, 0},
+	"kPa":  {"pressure", 1000, 0},
+	"bar":  {"pressure", 1e5, 0},
+	"psi":  {"pressure", 6894.757293168, 0},
+	"inHg": {"pressure", 3386.389, 0},
+	"mmHg": {"pressure", 133.322387415, 0},
+
+	"J":    {"energy", 1, 0},
+	"kJ":   {"energy", 1000, 0},
+	"Wh":   {"energy", 3600, 0},
+	"kWh":  {"energy", 3.6e6, 0},
+	"cal":  {"energy", 4.184, 0},
+	"kcal": {"energy", 4184, 0},
+
+	"mL":  {"volume", 0.001, 0},
+	"L":   {"volume", 1, 0},
+	"m3":  {"volume", 1000, 0},
+	"gal": {"volume", 3.785411784, 0},
+}
+
+// convertProcessor converts a numeric field between units, optionally
+// rounding to a number of decimal places.
+type convertProcessor struct {
+	Type      string `json:"type"`
+	Field     string `json:"field"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Precision *int   `json:"precision"`
+	from, to  unit
+}
+
+func (c *convertProcessor) apply(entry map[string]interface{}) bool {
+	v, ok := fieldpath.Get(entry, c.Field)
+	if !ok {
+		return true
+	}
+	f, ok := pipelineNumber(v)
+	if !ok {
+		return true
+	}
+	f = (f*c.from.scale + c.from.offset - c.to.offset) / c.to.scale
+	if c.Precision != nil {
+		p := math.Pow(10, float64(*c.Precision))
+		f = math.Round(f*p) / p
+	}
+	fieldpath.Set(entry, c.Field, f)
+	return true
+}
+
+// extractProcessor matches a regular expression against a string field and
+// sets a field for each named group that matched.
+type extractProcessor struct {
+	Type    string `json:"type"`
+	Field   string `json:"field"`
+	Pattern string `json:"pattern"`
+	re      *regexp.Regexp
+	names   []string
+}
+
+func (e *extractProcessor) apply(entry map[string]interface{}) bool {
+	v, _ := fieldpath.Get(entry, e.Field)
+	s, ok := v.(string)
+	if !ok {
+		return true
+	}
+	m := e.re.FindStringSubmatchIndex(s)
+	if m == nil {
+		return true
+	}
+	for i, name := range e.re.SubexpNames() {
+		if name != "" && m[2*i] >= 0 {
+			fieldpath.Set(entry, name, s[m[2*i]:m[2*i+1]])
+		}
+	}
+	return true
+}
+
+type enrichProcessor struct {
+	Type   string                 `json:"type"`
+	Fields map[string]interface{} `json:"fields"`
+}
+
+func (e *enrichProcessor) apply(entry map[string]interface{}) bool {
+	for field, v := range e.Fields {
+		fieldpath.Set(entry, field, v)
+	}
+	return true
+}
+
+// sampleProcessor keeps a random fraction of entries.
+type sampleProcessor struct {
+	Type string  `json:"type"`
+	Rate float64 `json:"rate"`
+	p    *Pipeline
+}
+
+func (s *sampleProcessor) apply(entry map[string]interface{}) bool {
+	return s.p.Random() < s.Rate
+}
@@ -0,0 +1,131 @@
+// AI-assisted code
+package pipeline
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPipelineRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		pipeline string
+		in       string
+		want     string
+	}{
+		{"rename swaps", `[{"type": "rename", "fields": {"a": "b", "b": "a"}}]`,
+			`{"a": 1, "b": 2}`, `{"a":2,"b":1}`},
+		{"rename nested", `[{"type": "rename", "fields": {"tmp": "sensor.temp"}}]`,
+			`{"tmp": 21.5}`, `{"sensor":{"temp":21.5}}`},
+		{"drop", `[{"type": "drop", "fields": ["debug", "x.y"]}]`,
+			`{"debug": true, "x": {"y": 1, "z": 2}, "v": 1}`, `{"v":1,"x":{"z":2}}`},
+		{"keep", `[{"type": "keep", "fields": ["v", "x.z", "missing"]}]`,
+			`{"debug": true, "x": {"y": 1, "z": 2}, "v": 1}`, `{"v":1,"x":{"z":2}}`},
+		{"coerce", `[{"type": "coerce", "fields": {"n": "number", "i": "integer", "s": "string", "b": "boolean", "bad": "number"}}]`,
+			`{"n": "93.1", "i": "7.9", "s": 12, "b": "yes", "bad": "high"}`, `{"b":true,"bad":"high","i":7,"n":93.1,"s":"12"}`},
+		{"convert", `[{"type": "convert", "field": "temp", "from": "F", "to": "C", "precision": 1}]`,
+			`{"temp": 98.6}`, `{"temp":37}`},
+		{"convert string", `[{"type": "convert", "field": "d", "from": "mi", "to": "km", "precision": 2}]`,
+			`{"d": "3.2"}`, `{"d":5.15}`},
+		{"convert skips text", `[{"type": "convert", "field": "d", "from": "mi", "to": "km"}]`,
+			`{"d": "far"}`, `{"d":"far"}`},
+		{"extract", `[{"type": "extract", "field": "msg", "pattern": "user (?P<user>\\w+) from (?P<ip>\\S+)"}]`,
+			`{"msg": "login: user bob from 10.0.0.2"}`, `{"ip":"10.0.0.2","msg":"login: user bob from 10.0.0.2","user":"bob"}`},
+		{"extract no match", `[{"type": "extract", "field": "msg", "pattern": "(?P<user>\\d+)"}]`,
+			`{"msg": "none"}`, `{"msg":"none"}`},
+		{"raw text", `[{"type": "grok", "pattern": "%{LOGLEVEL:level} %{GREEDYDATA:text}"}]`,
+			`WARN disk almost full`, `{"level":"WARN","message":"WARN disk almost full","text":"disk almost full"}`},
+		{"json string", `[{"type": "enrich", "fields": {"site": "home"}}]`,
+			`"hello"`, `{"message":"hello","site":"home"}`},
+		{"enrich", `[{"type": "enrich", "fields": {"site": "home", "geo.lat": 52.1}}]`,
+			`{"site": "work"}`, `{"geo":{"lat":52.1},"site":"home"}`},
+		{"in order", `[{"type": "rename", "fields": {"t": "temp"}}, {"type": "coerce", "fields": {"temp": "number"}}]`,
+			`{"t": "20"}`, `{"temp":20}`},
+	}
+	for _, tt := range tests {
+		p, err := Compile([]byte(tt.pipeline))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		out, keep := p.Run([]byte(tt.in))
+		if !keep || string(out) != tt.want {
+			t.Errorf("%s: got %s %v, want %s", tt.name, out, keep, tt.want)
+		}
+	}
+}
+
+func TestPipelineSample(t *testing.T) {
+	p, err := Compile([]byte(`[{"type": "sample", "rate": 0.25}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Random = func() float64 { return 0.5 }
+	if _, keep := p.Run([]byte(`{}`)); keep {
+		t.Error("kept at 0.5")
+	}
+	p.Random = func() float64 { return 0.1 }
+	if _, keep := p.Run([]byte(`{}`)); !keep {
+		t.Error("dropped at 0.1")
+	}
+}
+
+func TestGrok(t *testing.T) {
+	p, err := Compile([]byte(`[{"type": "grok", "field": "line", "pattern": "%{COMBINEDAPACHELOG}"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := `127.0.0.1 - frank [10/Oct/2025:13:55:36 -0700] "GET /a.gif?x=1 HTTP/1.1" 200 2326 "http://example.com/" "curl/8.0"`
+	out, _ := p.Run([]byte(`{"line": ` + quote(line) + `}`))
+	for _, want := range []string{
+		`"clientip":"127.0.0.1"`, `"auth":"frank"`, `"timestamp":"10/Oct/2025:13:55:36 -0700"`,
+		`"verb":"GET"`, `"request":"/a.gif?x=1"`, `"response":200`, `"bytes":2326`, `"agent":"\"curl/8.0\""`,
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("missing %s in %s", want, out)
+		}
+	}
+
+	p, err = Compile([]byte(`[{"type": "grok", "pattern": "%{NUMBER:reading.temp:float}C at %{SITE:host}",
+		"patterns": {"SITE": "%{HOSTNAME}"}}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ = p.Run([]byte(`"21.5C at nas.local"`))
+	if got := string(out); got != `{"host":"nas.local","message":"21.5C at nas.local","reading":{"temp":21.5}}` {
+		t.Errorf("got %s", got)
+	}
+}
+
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func TestCompilePipelineErrors(t *testing.T) {
+	tests := []struct {
+		pipeline string
+		want     string
+	}{
+		{`{"type": "drop"}`, "pipeline must be an array"},
+		{`[{"type": "nope"}]`, "processor 0 (nope): unknown processor type"},
+		{`[{"fields": ["a"]}]`, "processor 0 (): type required"},
+		{`[{"type": "drop", "feilds": ["a"]}]`, `processor 0 (drop): json: unknown field "feilds"`},
+		{`[{"type": "drop", "fields": []}]`, "fields required"},
+		{`[{"type": "coerce", "fields": {"a": "date"}}]`, "a: type must be"},
+		{`[{"type": "convert", "field": "a", "from": "kg", "to": "km"}]`, "can't convert kg (mass) to km (length)"},
+		{`[{"type": "convert", "field": "a", "from": "kg", "to": "stone"}]`, `unknown unit "stone"`},
+		{`[{"type": "extract", "field": "a", "pattern": "\\d+"}]`, "pattern needs a named group"},
+		{`[{"type": "extract", "field": "a", "pattern": "(?P<x>"}]`, "missing closing )"},
+		{`[{"type": "grok", "pattern": "%{NOPE:x}"}]`, "unknown pattern NOPE"},
+		{`[{"type": "grok", "pattern": "%{WORD}"}]`, "pattern needs a field"},
+		{`[{"type": "grok", "pattern": "%{A:x}", "patterns": {"A": "%{A}"}}]`, "too deeply"},
+		{`[{"type": "sample", "rate": 0}]`, "rate must be"},
+		{`[{"type": "sample", "rate": 1.5}]`, "rate must be"},
+	}
+	for _, tt := range tests {
+		_, err := Compile([]byte(tt.pipeline))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want %q", tt.pipeline, err, tt.want)
+		}
+	}
+}
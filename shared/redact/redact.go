@@ -0,0 +1,389 @@
+// AI-assisted code
+
+// Package redact applies logset redaction rules, which the ingester runs
+// after the schema check and before anything is stored, alerted on or sent
+// to a webhook. Each rule finds values with a detector and masks them,
+// replaces them with a keyed hash, or drops the field holding them. The
+// names of the rules that fired are stored with the entry. The web API
+// uses the same rules to check them when they're saved.
+package redact
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"shared/fieldpath"
+)
+
+const maxRules = 32
+
+// Mask replaces what mask rules find.
+const Mask = "[REDACTED]"
+
+// Rule is one redaction rule. Name defaults to the detector.
+type Rule struct {
+	Name     string   `json:"name"`
+	Detector string   `json:"detector"`
+	Pattern  string   `json:"pattern,omitempty"`
+	Fields   []string `json:"fields,omitempty"`
+	Action   string   `json:"action"`
+	detect   *detector
+}
+
+// Redactor is a compiled list of rules.
+type Redactor struct {
+	Rules []*Rule
+	// Hashes is set when a rule needs the user's hash key.
+	Hashes bool
+}
+
+// detector finds candidates with a regular expression, then checks each
+// one with valid, which sees the whole string to look at what's around the
+// match.
+type detector struct {
+	re    *regexp.Regexp
+	valid func(s string, start, end int) bool
+}
+
+var detectors = map[string]*detector{
+	"email": {
+		re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`),
+	},
+	"phone": {
+		re:    regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?(?:\(\d{1,4}\)[ .-]?)?\d{2,4}(?:[ .-]?\d{2,4}){1,4}`),
+		valid: validPhone,
+	},
+	"ipv4": {
+		re:    regexp.MustCompile(`(?:\d{1,3}\.){3}\d{1,3}`),
+		valid: validIPv4,
+	},
+	"ipv6": {
+		re:    regexp.MustCompile(`[0-9A-Fa-f]{0,4}(?::[0-9A-Fa-f]{0,4}){2,7}(?:(?:\.\d{1,3}){3})?`),
+		valid: validIPv6,
+	},
+	"credit_card": {
+		re:    regexp.MustCompile(`[2-6]\d{3}(?:[ -]?\d){9,15}`),
+		valid: validCard,
+	},
+	"jwt": {
+		re:    regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+		valid: func(s string, start, end int) bool { return bounded(s, start, end, isWordByte) },
+	},
+}
+
+func init() {
+	// ip finds both kinds. IPv6 goes first so an IPv4 tail of one isn't
+	// taken on its own.
+	detectors["ip"] = &detector{
+		re: regexp.MustCompile(detectors["ipv6"].re.String() + `|` + detectors["ipv4"].re.String()),
+		valid: func(s string, start, end int) bool {
+			if strings.Contains(s[start:end], ":") {
+				return validIPv6(s, start, end)
+			}
+			return validIPv4(s, start, end)
+		},
+	}
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+func isDigitByte(b byte) bool { return b >= '0' && b <= '9' }
+
+// bounded reports whether the match isn't the middle of something longer,
+// by checking the bytes either side of it.
+func bounded(s string, start, end int, inside func(byte) bool) bool {
+	return (start == 0 || !inside(s[start-1])) && (end == len(s) || !inside(s[end]))
+}
+
+func countDigits(s string) int {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if isDigitByte(s[i]) {
+			n++
+		}
+	}
+	return n
+}
+
+var datePrefix = regexp.MustCompile(`^\d{4}[-/.]\d{1,2}[-/.]\d{1,2}`)
+
+// validPhone takes 9 to 15 digits, written with a country code or
+// separators, that aren't a date, an IPv4 address or part of a longer
+// number or word. Plain runs of digits are more often IDs or timestamps.
+func validPhone(s string, start, end int) bool {
+	m := s[start:end]
+	n := countDigits(m)
+	return n >= 9 && n <= 15 && n < len(m) &&
+		bounded(s, start, end, func(b byte) bool { return isWordByte(b) || b == '.' || b == ':' }) &&
+		!datePrefix.MatchString(m) && net.ParseIP(m) == nil
+}
+
+func validIPv4(s string, start, end int) bool {
+	ip := net.ParseIP(s[start:end])
+	return ip != nil && ip.To4() != nil &&
+		bounded(s, start, end, func(b byte) bool { return isWordByte(b) || b == '.' })
+}
+
+func validIPv6(s string, start, end int) bool {
+	ip := net.ParseIP(s[start:end])
+	return ip != nil && strings.Contains(s[start:end], ":") &&
+		bounded(s, start, end, func(b byte) bool { return isWordByte(b) || b == ':' || b == '.' })
+}
+
+// validCard takes 13 to 19 digits passing the Luhn check.
+func validCard(s string, start, end int) bool {
+	m := s[start:end]
+	n := countDigits(m)
+	if n < 13 || n > 19 || !bounded(s, start, end, isWordByte) {
+		return false
+	}
+	sum, double := 0, false
+	for i := len(m) - 1; i >= 0; i-- {
+		if !isDigitByte(m[i]) {
+			continue
+		}
+		d := int(m[i] - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// Compile parses and checks redaction rules, a JSON array.
+func Compile(raw []byte) (*Redactor, error) {
+	var rules []*Rule
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("redaction must be an array of rules: %v", err)
+	}
+	if len(rules) > maxRules {
+		return nil, fmt.Errorf("redaction can have at most %d rules", maxRules)
+	}
+	r := &Redactor{}
+	for i, rule := range rules {
+		if rule == nil {
+			return nil, fmt.Errorf("rule %d: must be an object", i)
+		}
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %v", i, rule.Detector, err)
+		}
+		if rule.Action == "hash" {
+			r.Hashes = true
+		}
+		r.Rules = append(r.Rules, rule)
+	}
+	return r, nil
+}
+
+func (rule *Rule) compile() error {
+	switch rule.Action {
+	case "mask", "hash", "drop":
+	default:
+		return fmt.Errorf("action must be mask, hash or drop")
+	}
+	for _, f := range rule.Fields {
+		if f == "" || strings.HasPrefix(f, ".") || strings.HasSuffix(f, ".") {
+			return fmt.Errorf("invalid field %q", f)
+		}
+	}
+	switch rule.Detector {
+	case "field":
+		if len(rule.Fields) == 0 {
+			return fmt.Errorf("fields required")
+		}
+	case "regex":
+		if rule.Pattern == "" {
+			return fmt.Errorf("pattern required")
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return err
+		}
+		if re.MatchString("") {
+			return fmt.Errorf("pattern matches the empty string")
+		}
+		rule.detect = &detector{re: re}
+	case "":
+		return fmt.Errorf("detector required")
+	default:
+		rule.detect = detectors[rule.Detector]
+		if rule.detect == nil {
+			return fmt.Errorf("unknown detector")
+		}
+	}
+	if rule.Pattern != "" && rule.Detector != "regex" {
+		return fmt.Errorf("pattern is only for the regex detector")
+	}
+	if rule.Name == "" {
+		rule.Name = rule.Detector
+	}
+	return nil
+}
+
+// Redact applies the rules to an entry, returning the entry to store and
+// the names of the rules that fired. key is the user's hash key, needed
+// when r.Hashes is set.
+func (r *Redactor) Redact(data []byte, key []byte) ([]byte, []string) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	isJSON := dec.Decode(&v) == nil && !dec.More()
+	if !isJSON {
+		v = string(data)
+	}
+
+	var fired []string
+	for _, rule := range r.Rules {
+		var hit bool
+		v, hit = rule.apply(v, key)
+		if hit {
+			fired = append(fired, rule.Name)
+		}
+	}
+	if len(fired) == 0 {
+		return data, nil
+	}
+	if !isJSON {
+		return []byte(v.(string)), fired
+	}
+	out, _ := json.Marshal(v)
+	return out, fired
+}
+
+// apply runs one rule over a decoded entry. Rules with fields only look at
+// those fields, and whatever is inside them.
+func (rule *Rule) apply(v interface{}, key []byte) (interface{}, bool) {
+	entry, isObject := v.(map[string]interface{})
+	if len(rule.Fields) == 0 {
+		nv, keep, hit := rule.walk(v, key)
+		if !keep {
+			// Only fields can be dropped, so a bare value is masked whole.
+			nv = Mask
+		}
+		return nv, hit
+	}
+	if !isObject {
+		return v, false
+	}
+
+	var hit bool
+	for _, f := range rule.Fields {
+		fv, ok := fieldpath.Get(entry, f)
+		if !ok {
+			continue
+		}
+		nv, keep, h := fv, true, true
+		if rule.detect != nil {
+			nv, keep, h = rule.walk(fv, key)
+		} else if rule.Action != "drop" {
+			nv = rule.replace(fieldText(fv), key)
+		} else {
+			keep = false
+		}
+		if !h {
+			continue
+		}
+		hit = true
+		if keep {
+			fieldpath.Set(entry, f, nv)
+		} else {
+			fieldpath.Delete(entry, f)
+		}
+	}
+	return entry, hit
+}
+
+func fieldText(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// walk redacts detected values anywhere under v. It returns the new value,
+// whether to keep it, and whether anything was found.
+func (rule *Rule) walk(v interface{}, key []byte) (interface{}, bool, bool) {
+	switch x := v.(type) {
+	case string:
+		return rule.redactString(x, key)
+	case json.Number:
+		nv, keep, hit := rule.redactString(x.String(), key)
+		if !hit {
+			return v, true, false
+		}
+		return nv, keep, true
+	case map[string]interface{}:
+		var hit bool
+		for k, child := range x {
+			nv, keep, h := rule.walk(child, key)
+			hit = hit || h
+			if !keep {
+				delete(x, k)
+			} else if h {
+				x[k] = nv
+			}
+		}
+		return x, true, hit
+	case []interface{}:
+		var hit bool
+		out := x[:0]
+		for _, child := range x {
+			nv, keep, h := rule.walk(child, key)
+			hit = hit || h
+			if keep {
+				out = append(out, nv)
+			}
+		}
+		return out, true, hit
+	}
+	return v, true, false
+}
+
+func (rule *Rule) redactString(s string, key []byte) (interface{}, bool, bool) {
+	var sb strings.Builder
+	last, hit := 0, false
+	for _, m := range rule.detect.re.FindAllStringIndex(s, -1) {
+		if m[0] == m[1] || rule.detect.valid != nil && !rule.detect.valid(s, m[0], m[1]) {
+			continue
+		}
+		if rule.Action == "drop" {
+			return nil, false, true
+		}
+		sb.WriteString(s[last:m[0]])
+		sb.WriteString(rule.replace(s[m[0]:m[1]], key))
+		last, hit = m[1], true
+	}
+	if !hit {
+		return s, true, false
+	}
+	sb.WriteString(s[last:])
+	return sb.String(), true, true
+}
+
+// replace masks or hashes one value. Hashes are keyed per user, so the
+// same value hashes the same way across a user's logsets and can still be
+// matched up, but can't be confirmed by hashing guesses without the key.
+func (rule *Rule) replace(s string, key []byte) string {
+	if rule.Action != "hash" {
+		return Mask
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(s))
+	return "hmac:" + hex.EncodeToString(mac.Sum(nil)[:16])
+}
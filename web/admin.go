@@ -0,0 +1,234 @@
+// AI-assisted code
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gocql/gocql"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func handleAdminListUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := dbListUsers(session)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list users")
+		return
+	}
+	for i := range users {
+		userID, _ := gocql.ParseUUID(users[i].UserID)
+		logsets, entries, bytes, err := dbGetUsage(session, userID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to read usage")
+			return
+		}
+		users[i].Logsets = logsets
+		users[i].Entries = entries
+		users[i].Bytes = bytes
+	}
+	if users == nil {
+		users = []AdminUser{}
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+func handleAdminCreateUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password string `json:"password"`
+		Name     string `json:"name"`
+		IsAdmin  bool   `json:"is_admin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if req.Password == "" {
+		writeError(w, http.StatusBadRequest, "password required")
+		return
+	}
+
+	accountNumber, userID, err := createAccount(req.Password, req.Name, req.IsAdmin)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create user")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]string{
+		"user_id":        userID.String(),
+		"account_number": accountNumber,
+	})
+}
+
+// adminTargetUser resolves the {id} path value to an existing user.
+func adminTargetUser(w http.ResponseWriter, r *http.Request) (User, bool) {
+	userID, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "user not found")
+		return User{}, false
+	}
+	user, err := dbGetUser(session, userID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "user not found")
+		return User{}, false
+	}
+	return user, true
+}
+
+func handleAdminUpdateUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		IsAdmin  *bool `json:"is_admin"`
+		Disabled *bool `json:"disabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	self := user.UserID == getUserID(r)
+	if self && ((req.IsAdmin != nil && !*req.IsAdmin) || (req.Disabled != nil && *req.Disabled)) {
+		writeError(w, http.StatusBadRequest, "cannot demote or disable yourself")
+		return
+	}
+
+	if req.IsAdmin != nil {
+		if err := dbSetUserAdmin(session, user.UserID, *req.IsAdmin); err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to update user")
+			return
+		}
+		user.IsAdmin = *req.IsAdmin
+	}
+	if req.Disabled != nil {
+		if err := dbSetUserDisabled(session, user.UserID, *req.Disabled); err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to update user")
+			return
+		}
+		user.Disabled = *req.Disabled
+		if user.Disabled {
+			if err := dbRevokeUserTokens(session, user.UserID); err != nil {
+				writeError(w, http.StatusInternalServerError, "failed to revoke tokens")
+				return
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, AdminUser{
+		UserID:    user.UserID.String(),
+		Name:      user.Name,
+		CreatedAt: user.CreatedAt,
+		IsAdmin:   user.IsAdmin,
+		Disabled:  user.Disabled,
+	})
+}
+
+func handleAdminResetPassword(w http.ResponseWriter, r *http.Request) {
+	user, ok := adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if req.Password == "" {
+		writeError(w, http.StatusBadRequest, "password required")
+		return
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to hash password")
+		return
+	}
+	if err := dbSetUserPassword(session, user.UserID, string(passwordHash)); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update password")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func handleAdminRevokeTokens(w http.ResponseWriter, r *http.Request) {
+	user, ok := adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	if err := dbRevokeUserTokens(session, user.UserID); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to revoke tokens")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// bootstrapAdmins promotes the accounts listed in ADMIN_ACCOUNTS so a fresh
+// node can get its first admin without opening registration.
+func bootstrapAdmins() {
+	for _, acct := range strings.Fields(os.Getenv("ADMIN_ACCOUNTS")) {
+		if err := setAdminByAccount(acct, true); err != nil {
+			log.Printf("ADMIN_ACCOUNTS: %s: %v", acct, err)
+		}
+	}
+}
+
+func setAdminByAccount(accountNumber string, isAdmin bool) error {
+	userID, err := dbGetUserIDByAccount(session, hashSHA256(accountNumber))
+	if err != nil {
+		return fmt.Errorf("account not found")
+	}
+	return dbSetUserAdmin(session, userID, isAdmin)
+}
+
+const adminUsage = `usage:
+  web admin create [name]          create an admin account (password read from stdin)
+  web admin promote <account>      grant admin to an existing account
+  web admin demote <account>       revoke admin from an account`
+
+// runAdminCLI handles `web admin ...` invocations, e.g.
+// `docker compose exec web /web admin create`.
+func runAdminCLI(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", adminUsage)
+	}
+
+	switch args[0] {
+	case "create":
+		name := strings.Join(args[1:], " ")
+		fmt.Fprint(os.Stderr, "password: ")
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && password == "" {
+			return fmt.Errorf("failed to read password: %w", err)
+		}
+		password = strings.TrimRight(password, "\r\n")
+		if password == "" {
+			return fmt.Errorf("password required")
+		}
+		accountNumber, _, err := createAccount(password, name, true)
+		if err != nil {
+			return fmt.Errorf("failed to create user: %w", err)
+		}
+		fmt.Println(accountNumber)
+		return nil
+	case "promote", "demote":
+		if len(args) != 2 {
+			return fmt.Errorf("%s", adminUsage)
+		}
+		return setAdminByAccount(args[1], args[0] == "promote")
+	default:
+		return fmt.Errorf("%s", adminUsage)
+	}
+}
@@ -0,0 +1,260 @@
+// AI-assisted code
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Alert rules are stored here and evaluated by the ingester, which moves
+// their state between ok, pending and firing and records each firing and
+// resolution in alert_history.
+//
+// A threshold rule breaches while the latest entry matches its condition and
+// arrived within the window. It fires once the breach has lasted for_seconds,
+// and resolves on the next non-matching entry or when the window passes
+// without entries. A silence rule fires when no (matching) entry has arrived
+// for window_seconds. Either kind waits cooldown_seconds after firing before
+// it can fire again.
+
+const (
+	defaultAlertWindow = 300
+	minAlertWindow     = 60
+)
+
+var alertOps = map[string]bool{
+	">": true, ">=": true, "<": true, "<=": true,
+	"==": true, "!=": true, "contains": true, "exists": true,
+}
+
+// validateCondition checks a condition as used by alert rules and webhook
+// filters, returning an error message or "".
+func validateCondition(c *AlertCondition) string {
+	if c.Field == "" || !alertOps[c.Op] {
+		return "condition needs a field and an op (>, >=, <, <=, ==, !=, contains, exists)"
+	}
+	if c.Op != "exists" && len(c.Value) == 0 {
+		return "condition needs a value"
+	}
+	if len(c.Value) > 0 && !json.Valid(c.Value) {
+		return "invalid condition value"
+	}
+	return ""
+}
+
+type alertRequest struct {
+	Name            *string         `json:"name"`
+	Kind            *string         `json:"kind"`
+	Condition       *AlertCondition `json:"condition"`
+	WindowSeconds   *int            `json:"window_seconds"`
+	ForSeconds      *int            `json:"for_seconds"`
+	CooldownSeconds *int            `json:"cooldown_seconds"`
+}
+
+// apply merges a create or update request into a, returning a validation
+// error message or "".
+func (req alertRequest) apply(a *AlertRule) string {
+	if req.Name != nil {
+		a.Name = *req.Name
+	}
+	if req.Kind != nil {
+		a.Kind = *req.Kind
+	}
+	if req.Condition != nil {
+		a.Condition = req.Condition
+		if req.Condition.Field == "" && req.Condition.Op == "" {
+			a.Condition = nil
+		}
+	}
+	if req.WindowSeconds != nil {
+		a.WindowSeconds = *req.WindowSeconds
+	}
+	if req.ForSeconds != nil {
+		a.ForSeconds = *req.ForSeconds
+	}
+	if req.CooldownSeconds != nil {
+		a.CooldownSeconds = *req.CooldownSeconds
+	}
+
+	if a.Name == "" {
+		return "name required"
+	}
+	switch a.Kind {
+	case "threshold":
+		if a.Condition == nil {
+			return "threshold rules need a condition"
+		}
+	case "silence":
+	default:
+		return "kind must be threshold or silence"
+	}
+	if a.Condition != nil {
+		if msg := validateCondition(a.Condition); msg != "" {
+			return msg
+		}
+	}
+	if a.WindowSeconds < minAlertWindow {
+		return "window_seconds must be at least " + strconv.Itoa(minAlertWindow)
+	}
+	if a.ForSeconds < 0 || a.CooldownSeconds < 0 {
+		return "for_seconds and cooldown_seconds can't be negative"
+	}
+	return ""
+}
+
+func handleListAlerts(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	logID := r.PathValue("id")
+
+	if _, err := dbGetLogset(session, userID, logID); err != nil {
+		writeError(w, http.StatusNotFound, "logset not found")
+		return
+	}
+	rules, err := dbListAlertRules(session, userID, logID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list alerts")
+		return
+	}
+	if rules == nil {
+		rules = []AlertRule{}
+	}
+	writeJSON(w, http.StatusOK, rules)
+}
+
+func handleCreateAlert(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	logID := r.PathValue("id")
+
+	logset, err := dbGetLogset(session, userID, logID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "logset not found")
+		return
+	}
+
+	var req alertRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	now := time.Now()
+	a := AlertRule{
+		LogID:         logID,
+		Kind:          "threshold",
+		WindowSeconds: defaultAlertWindow,
+		CreatedAt:     now,
+		State:         "ok",
+		Since:         &now,
+	}
+	if msg := req.apply(&a); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if a.Condition != nil && refuseEncrypted(w, logset, "an alert condition") {
+		return
+	}
+
+	ruleID := gocql.TimeUUID()
+	a.AlertID = ruleID.String()
+	if err := dbPutAlertRule(session, userID, ruleID, a, true); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create alert")
+		return
+	}
+	writeJSON(w, http.StatusCreated, a)
+}
+
+func handleUpdateAlert(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	logID := r.PathValue("id")
+
+	ruleID, err := gocql.ParseUUID(r.PathValue("alert_id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "alert not found")
+		return
+	}
+	a, err := dbGetAlertRule(session, userID, logID, ruleID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "alert not found")
+		return
+	}
+
+	var req alertRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if msg := req.apply(&a); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if a.Condition != nil {
+		logset, err := dbGetLogset(session, userID, logID)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "logset not found")
+			return
+		}
+		if refuseEncrypted(w, logset, "an alert condition") {
+			return
+		}
+	}
+
+	if err := dbPutAlertRule(session, userID, ruleID, a, false); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update alert")
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+func handleDeleteAlert(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	logID := r.PathValue("id")
+
+	ruleID, err := gocql.ParseUUID(r.PathValue("alert_id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "alert not found")
+		return
+	}
+	if _, err := dbGetAlertRule(session, userID, logID, ruleID); err != nil {
+		writeError(w, http.StatusNotFound, "alert not found")
+		return
+	}
+	if err := dbDeleteAlertRule(session, userID, logID, ruleID); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to delete alert")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func handleAlertHistory(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	logID := r.PathValue("id")
+
+	if _, err := dbGetLogset(session, userID, logID); err != nil {
+		writeError(w, http.StatusNotFound, "logset not found")
+		return
+	}
+
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed < 1 || parsed > 1000 {
+			writeError(w, http.StatusBadRequest, "limit must be 1-1000")
+			return
+		}
+		limit = parsed
+	}
+
+	events, err := dbListAlertHistory(session, userID, logID, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list alert history")
+		return
+	}
+	if events == nil {
+		events = []AlertEvent{}
+	}
+	writeJSON(w, http.StatusOK, events)
+}
@@ -0,0 +1,9 @@
+// AI-assisted code
+package main
+
+import "shared/atrest"
+
+// keyStore opens entries the ingesters sealed with shared/atrest. It's
+// set up in main even without MASTER_KEY, so entries sealed before the
+// key was removed fail to open with a clear error.
+var keyStore *atrest.Store
@@ -7,10 +7,12 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/gocql/gocql"
 	"golang.org/x/crypto/bcrypt"
@@ -18,12 +20,25 @@ import (
 
 type contextKey string
 
-const userIDKey contextKey = "user_id"
+const (
+	userIDKey contextKey = "user_id"
+	tokenKey  contextKey = "token"
+)
 
 func getUserID(r *http.Request) gocql.UUID {
 	return r.Context().Value(userIDKey).(gocql.UUID)
 }
 
+func getToken(r *http.Request) TokenInfo {
+	return r.Context().Value(tokenKey).(TokenInfo)
+}
+
+// getSessionID returns the login session behind the request, or nil when it
+// was authenticated with an API key.
+func getSessionID(r *http.Request) *gocql.UUID {
+	return getToken(r).SessionID
+}
+
 func requireAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		auth := r.Header.Get("Authorization")
@@ -34,17 +49,39 @@ func requireAuth(next http.HandlerFunc) http.HandlerFunc {
 		token := strings.TrimPrefix(auth, "Bearer ")
 		tokenHash := hashSHA256(token)
 
-		userID, err := dbGetUserByToken(session, tokenHash)
+		tok, err := dbGetToken(session, tokenHash)
 		if err != nil {
 			writeError(w, http.StatusUnauthorized, "invalid token")
 			return
 		}
+		// Revoking deletes the tokens it can find; this also stops the
+		// ones it can't, and any left behind by a failed revoke.
+		user, err := dbGetUser(session, tok.UserID)
+		if err != nil || user.Disabled || !user.TokensRevokedAt.IsZero() && !tok.CreatedAt.After(user.TokensRevokedAt) {
+			writeError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		if tok.SessionID != nil {
+			touchSession(tok)
+		}
 
-		ctx := context.WithValue(r.Context(), userIDKey, userID)
+		ctx := context.WithValue(r.Context(), userIDKey, tok.UserID)
+		ctx = context.WithValue(ctx, tokenKey, tok)
 		next(w, r.WithContext(ctx))
 	}
 }
 
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		user, err := dbGetUser(session, getUserID(r))
+		if err != nil || !user.IsAdmin || user.Disabled {
+			writeError(w, http.StatusForbidden, "admin only")
+			return
+		}
+		next(w, r)
+	})
+}
+
 func hashSHA256(s string) string {
 	h := sha256.Sum256([]byte(s))
 	return hex.EncodeToString(h[:])
@@ -62,18 +99,16 @@ func generateAccountNumber() (string, error) {
 	return fmt.Sprintf("%010d", num%10000000000), nil
 }
 
-func registrationOpen() bool {
-	return os.Getenv("PUBLIC_REGISTRATION") == "true"
-}
-
 func handleSignup(w http.ResponseWriter, r *http.Request) {
-	if !registrationOpen() {
+	mode := registrationMode()
+	if mode == "closed" {
 		writeError(w, http.StatusForbidden, "registration is closed")
 		return
 	}
 	var req struct {
-		Password string `json:"password"`
-		Name     string `json:"name"`
+		Password   string `json:"password"`
+		Name       string `json:"name"`
+		InviteCode string `json:"invite_code"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeError(w, http.StatusBadRequest, "invalid json")
@@ -83,29 +118,56 @@ func handleSignup(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "password required")
 		return
 	}
+	if mode == "invite" {
+		if req.InviteCode == "" {
+			writeError(w, http.StatusForbidden, "invite code required")
+			return
+		}
+		if err := redeemInvite(req.InviteCode); err != nil {
+			if errors.Is(err, errInvalidInvite) {
+				writeError(w, http.StatusForbidden, err.Error())
+			} else {
+				writeError(w, http.StatusInternalServerError, "failed to redeem invite")
+			}
+			return
+		}
+	}
 
-	accountNumber, err := generateAccountNumber()
+	accountNumber, _, err := createAccount(req.Password, req.Name, false)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to generate account number")
+		if mode == "invite" {
+			if err := releaseInvite(req.InviteCode); err != nil {
+				log.Println("release invite:", err)
+			}
+		}
+		writeError(w, http.StatusInternalServerError, "failed to create user")
 		return
 	}
 
+	writeJSON(w, http.StatusCreated, map[string]string{
+		"account_number": accountNumber,
+	})
+}
+
+// createAccount generates a fresh account number and stores the user. The
+// plaintext account number is returned so it can be shown once.
+func createAccount(password, name string, isAdmin bool) (string, gocql.UUID, error) {
+	accountNumber, err := generateAccountNumber()
+	if err != nil {
+		return "", gocql.UUID{}, err
+	}
+
 	accountHash := hashSHA256(accountNumber)
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to hash password")
-		return
+		return "", gocql.UUID{}, err
 	}
 
 	userID := gocql.TimeUUID()
-	if err := dbCreateUser(session, userID, accountHash, string(passwordHash), req.Name); err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to create user")
-		return
+	if err := dbCreateUser(session, userID, accountHash, string(passwordHash), name, isAdmin); err != nil {
+		return "", gocql.UUID{}, err
 	}
-
-	writeJSON(w, http.StatusCreated, map[string]string{
-		"account_number": accountNumber,
-	})
+	return accountNumber, userID, nil
 }
 
 func handleLogin(w http.ResponseWriter, r *http.Request) {
@@ -139,16 +201,13 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusUnauthorized, "invalid credentials")
 		return
 	}
-
-	tokenBytes := make([]byte, 32)
-	if _, err := rand.Read(tokenBytes); err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to generate token")
+	if user.Disabled {
+		writeError(w, http.StatusForbidden, "account disabled")
 		return
 	}
-	token := hex.EncodeToString(tokenBytes)
-	tokenHash := hashSHA256(token)
 
-	if err := dbCreateToken(session, tokenHash, userID, "", ""); err != nil {
+	token, err := issueSessionToken(r, userID)
+	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to create token")
 		return
 	}
@@ -158,13 +217,48 @@ func handleLogin(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// issueSessionToken creates a login session for userID and returns the
+// plaintext token. The request's user agent and IP are kept for the
+// sessions list.
+func issueSessionToken(r *http.Request, userID gocql.UUID) (string, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(tokenBytes)
+	tokenHash := hashSHA256(token)
+
+	sessionID := gocql.TimeUUID()
+	now := time.Now()
+	ttl := time.Until(sessionExpiry(now, now))
+	ip := clientIP(r)
+	newIP := loginFromNewIP(userID, ip)
+	if err := dbCreateSession(session, tokenHash, userID, sessionID, r.UserAgent(), ip, now, ttl); err != nil {
+		return "", err
+	}
+	if newIP {
+		notifyEmail(userID, "login", "New LibreLog login from "+ip,
+			"Your LibreLog account was signed in to from an address it hasn't been used from lately.\n\n"+
+				"IP address: "+ip+"\n"+
+				"Browser: "+r.UserAgent()+"\n"+
+				"Time: "+now.UTC().Format(time.RFC1123)+"\n\n"+
+				"If this wasn't you, change your password and end the session from the sessions list.\n")
+	}
+	return token, nil
+}
+
 func handleLogout(w http.ResponseWriter, r *http.Request) {
 	auth := r.Header.Get("Authorization")
 	token := strings.TrimPrefix(auth, "Bearer ")
 	tokenHash := hashSHA256(token)
 
 	userID := getUserID(r)
-	if err := dbDeleteToken(session, tokenHash, userID); err != nil {
+	if sessionID := getSessionID(r); sessionID != nil {
+		if err := dbDeleteSession(session, userID, *sessionID, tokenHash); err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to delete token")
+			return
+		}
+	} else if err := dbDeleteToken(session, tokenHash, userID); err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to delete token")
 		return
 	}
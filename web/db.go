@@ -2,37 +2,101 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
 	"time"
 
 	"github.com/gocql/gocql"
+
+	"shared/atrest"
 )
 
 type Logset struct {
-	LogID       string `json:"log_id"`
-	UserID      string `json:"user_id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Data        string `json:"data,omitempty"`
+	LogID       string     `json:"log_id"`
+	UserID      string     `json:"user_id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Kind        string     `json:"kind"`
+	Heartbeat   *Heartbeat `json:"heartbeat,omitempty"`
+	LogsetSettings
+}
+
+// LogsetSettings is kept as JSON in logs_meta.data, where the ingesters
+// read it too.
+type LogsetSettings struct {
+	Schema     json.RawMessage `json:"schema,omitempty"`
+	SchemaMode string          `json:"schema_mode,omitempty"`
+	Pipeline   json.RawMessage `json:"pipeline,omitempty"`
+	Redaction  json.RawMessage `json:"redaction,omitempty"`
+	// Encrypted logsets only take end-to-end encrypted entries. It's set
+	// when the logset is created and never changes.
+	Encrypted bool `json:"encrypted,omitempty"`
+}
+
+func decodeLogsetSettings(data string) LogsetSettings {
+	var s LogsetSettings
+	if data != "" {
+		json.Unmarshal([]byte(data), &s)
+	}
+	return s
+}
+
+func encodeLogsetSettings(s LogsetSettings) string {
+	b, _ := json.Marshal(s)
+	if string(b) == "{}" {
+		return ""
+	}
+	return string(b)
+}
+
+type Heartbeat struct {
+	IntervalSeconds int        `json:"interval_seconds"`
+	GraceSeconds    int        `json:"grace_seconds"`
+	Status          string     `json:"status"`
+	LastEntry       *time.Time `json:"last_entry,omitempty"`
+	Due             *time.Time `json:"due,omitempty"`
 }
 
 type LogEntry struct {
 	RecvTime time.Time `json:"recv_time"`
 	Data     string    `json:"data"`
+	// Redacted names the redaction rules that fired on the entry.
+	Redacted []string `json:"redacted,omitempty"`
+	// Error says why Data is empty, for entries that can't be decrypted.
+	Error string `json:"error,omitempty"`
 }
 
 type User struct {
-	UserID             gocql.UUID
-	AccountNumberHash  string
-	PasswordHash       string
-	Name               string
-	CreatedAt          time.Time
+	UserID            gocql.UUID
+	AccountNumberHash string
+	PasswordHash      string
+	Name              string
+	CreatedAt         time.Time
+	IsAdmin           bool
+	Disabled          bool
+	// TokensRevokedAt is when the account's tokens were last revoked;
+	// tokens from before then no longer work.
+	TokensRevokedAt time.Time
 }
 
-func dbCreateUser(session *gocql.Session, userID gocql.UUID, accountHash, passwordHash, name string) error {
+type AdminUser struct {
+	UserID    string    `json:"user_id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	IsAdmin   bool      `json:"is_admin"`
+	Disabled  bool      `json:"disabled"`
+	Logsets   int       `json:"logsets"`
+	Entries   int64     `json:"entries"`
+	Bytes     int64     `json:"bytes"`
+}
+
+func dbCreateUser(session *gocql.Session, userID gocql.UUID, accountHash, passwordHash, name string, isAdmin bool) error {
 	batch := session.NewBatch(gocql.LoggedBatch)
 	batch.Query(
-		`INSERT INTO users (user_id, account_number_hash, password_hash, name, created_at) VALUES (?, ?, ?, ?, ?)`,
-		userID, accountHash, passwordHash, name, time.Now(),
+		`INSERT INTO users (user_id, account_number_hash, password_hash, name, created_at, is_admin) VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, accountHash, passwordHash, name, time.Now(), isAdmin,
 	)
 	batch.Query(
 		`INSERT INTO users_by_account (account_number_hash, user_id) VALUES (?, ?)`,
@@ -52,11 +116,62 @@ func dbGetUserIDByAccount(session *gocql.Session, accountHash string) (gocql.UUI
 func dbGetUser(session *gocql.Session, userID gocql.UUID) (User, error) {
 	var u User
 	err := session.Query(
-		`SELECT user_id, account_number_hash, password_hash, name, created_at FROM users WHERE user_id = ?`, userID,
-	).Scan(&u.UserID, &u.AccountNumberHash, &u.PasswordHash, &u.Name, &u.CreatedAt)
+		`SELECT user_id, account_number_hash, password_hash, name, created_at, is_admin, disabled, tokens_revoked_at FROM users WHERE user_id = ?`, userID,
+	).Scan(&u.UserID, &u.AccountNumberHash, &u.PasswordHash, &u.Name, &u.CreatedAt, &u.IsAdmin, &u.Disabled, &u.TokensRevokedAt)
 	return u, err
 }
 
+func dbListUsers(session *gocql.Session) ([]AdminUser, error) {
+	iter := session.Query(
+		`SELECT user_id, name, created_at, is_admin, disabled FROM users`,
+	).Iter()
+
+	var users []AdminUser
+	var userID gocql.UUID
+	var u AdminUser
+	for iter.Scan(&userID, &u.Name, &u.CreatedAt, &u.IsAdmin, &u.Disabled) {
+		u.UserID = userID.String()
+		users = append(users, u)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func dbGetUsage(session *gocql.Session, userID gocql.UUID) (logsets int, entries, bytes int64, err error) {
+	iter := session.Query(
+		`SELECT entries, bytes FROM logs_usage WHERE user_id = ?`, userID,
+	).Iter()
+
+	var e, b int64
+	for iter.Scan(&e, &b) {
+		logsets++
+		entries += e
+		bytes += b
+	}
+	err = iter.Close()
+	return
+}
+
+func dbSetUserAdmin(session *gocql.Session, userID gocql.UUID, isAdmin bool) error {
+	return session.Query(
+		`UPDATE users SET is_admin = ? WHERE user_id = ?`, isAdmin, userID,
+	).Exec()
+}
+
+func dbSetUserDisabled(session *gocql.Session, userID gocql.UUID, disabled bool) error {
+	return session.Query(
+		`UPDATE users SET disabled = ? WHERE user_id = ?`, disabled, userID,
+	).Exec()
+}
+
+func dbSetUserPassword(session *gocql.Session, userID gocql.UUID, passwordHash string) error {
+	return session.Query(
+		`UPDATE users SET password_hash = ? WHERE user_id = ?`, passwordHash, userID,
+	).Exec()
+}
+
 type APIKey struct {
 	TokenHash string    `json:"token_hash"`
 	Name      string    `json:"name"`
@@ -64,32 +179,113 @@ type APIKey struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
+type Session struct {
+	SessionID string    `json:"session_id"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+	LastUsed  time.Time `json:"last_used"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Current   bool      `json:"current"`
+	tokenHash string
+}
+
+type TokenInfo struct {
+	TokenHash string
+	UserID    gocql.UUID
+	SessionID *gocql.UUID
+	CreatedAt time.Time
+}
+
 func dbCreateToken(session *gocql.Session, tokenHash string, userID gocql.UUID, name, prefix string) error {
 	now := time.Now()
-	if name != "" {
-		batch := session.NewBatch(gocql.LoggedBatch)
-		batch.Query(
-			`INSERT INTO tokens (token_hash, user_id, name, prefix, created_at) VALUES (?, ?, ?, ?, ?) USING TTL 0`,
-			tokenHash, userID, name, prefix, now,
-		)
-		batch.Query(
-			`INSERT INTO tokens_by_user (user_id, token_hash, name, prefix, created_at) VALUES (?, ?, ?, ?, ?)`,
-			userID, tokenHash, name, prefix, now,
-		)
-		return session.ExecuteBatch(batch)
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(
+		`INSERT INTO tokens (token_hash, user_id, name, prefix, created_at) VALUES (?, ?, ?, ?, ?) USING TTL 0`,
+		tokenHash, userID, name, prefix, now,
+	)
+	batch.Query(
+		`INSERT INTO tokens_by_user (user_id, token_hash, name, prefix, created_at) VALUES (?, ?, ?, ?, ?)`,
+		userID, tokenHash, name, prefix, now,
+	)
+	return session.ExecuteBatch(batch)
+}
+
+// dbCreateSession stores a login token together with its sessions row, both
+// expiring after ttl.
+func dbCreateSession(session *gocql.Session, tokenHash string, userID, sessionID gocql.UUID, userAgent, ip string, createdAt time.Time, ttl time.Duration) error {
+	seconds := int(ttl.Seconds())
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(
+		`INSERT INTO tokens (token_hash, user_id, created_at, session_id) VALUES (?, ?, ?, ?) USING TTL ?`,
+		tokenHash, userID, createdAt, sessionID, seconds,
+	)
+	batch.Query(
+		`INSERT INTO sessions (user_id, session_id, token_hash, user_agent, ip, created_at, last_used) VALUES (?, ?, ?, ?, ?, ?, ?) USING TTL ?`,
+		userID, sessionID, tokenHash, userAgent, ip, createdAt, time.Now(), seconds,
+	)
+	return session.ExecuteBatch(batch)
+}
+
+// dbRenewSession rewrites every column of a session's token and sessions
+// rows so the whole row picks up the new TTL. Both writes are conditional
+// so a renewal racing a logout can't bring either row back.
+func dbRenewSession(session *gocql.Session, tok TokenInfo, s Session, ttl time.Duration) (bool, error) {
+	seconds := int(ttl.Seconds())
+	applied, err := session.Query(
+		`UPDATE tokens USING TTL ? SET user_id = ?, created_at = ?, session_id = ? WHERE token_hash = ? IF EXISTS`,
+		seconds, tok.UserID, tok.CreatedAt, tok.SessionID, tok.TokenHash,
+	).MapScanCAS(map[string]interface{}{})
+	if err != nil || !applied {
+		return applied, err
 	}
 	return session.Query(
-		`INSERT INTO tokens (token_hash, user_id, created_at) VALUES (?, ?, ?)`,
-		tokenHash, userID, now,
-	).Exec()
+		`UPDATE sessions USING TTL ? SET token_hash = ?, user_agent = ?, ip = ?, created_at = ?, last_used = ? WHERE user_id = ? AND session_id = ? IF EXISTS`,
+		seconds, tok.TokenHash, s.UserAgent, s.IP, tok.CreatedAt, time.Now(), tok.UserID, tok.SessionID,
+	).MapScanCAS(map[string]interface{}{})
 }
 
-func dbGetUserByToken(session *gocql.Session, tokenHash string) (gocql.UUID, error) {
-	var userID gocql.UUID
+func dbGetToken(session *gocql.Session, tokenHash string) (TokenInfo, error) {
+	t := TokenInfo{TokenHash: tokenHash}
 	err := session.Query(
-		`SELECT user_id FROM tokens WHERE token_hash = ?`, tokenHash,
-	).Scan(&userID)
-	return userID, err
+		`SELECT user_id, session_id, created_at FROM tokens WHERE token_hash = ?`, tokenHash,
+	).Scan(&t.UserID, &t.SessionID, &t.CreatedAt)
+	return t, err
+}
+
+func dbListSessions(session *gocql.Session, userID gocql.UUID) ([]Session, error) {
+	iter := session.Query(
+		`SELECT session_id, token_hash, user_agent, ip, created_at, last_used FROM sessions WHERE user_id = ?`, userID,
+	).Iter()
+
+	var sessions []Session
+	var sessionID gocql.UUID
+	var s Session
+	for iter.Scan(&sessionID, &s.tokenHash, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastUsed) {
+		s.SessionID = sessionID.String()
+		sessions = append(sessions, s)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func dbGetSession(session *gocql.Session, userID, sessionID gocql.UUID) (Session, error) {
+	var s Session
+	err := session.Query(
+		`SELECT token_hash, user_agent, ip, created_at, last_used FROM sessions WHERE user_id = ? AND session_id = ?`,
+		userID, sessionID,
+	).Scan(&s.tokenHash, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastUsed)
+	s.SessionID = sessionID.String()
+	return s, err
+}
+
+func dbDeleteSession(session *gocql.Session, userID, sessionID gocql.UUID, tokenHash string) error {
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`DELETE FROM tokens WHERE token_hash = ?`, tokenHash)
+	batch.Query(`DELETE FROM sessions WHERE user_id = ? AND session_id = ?`, userID, sessionID)
+	return session.ExecuteBatch(batch)
 }
 
 func dbDeleteToken(session *gocql.Session, tokenHash string, userID gocql.UUID) error {
@@ -99,6 +295,40 @@ func dbDeleteToken(session *gocql.Session, tokenHash string, userID gocql.UUID)
 	return session.ExecuteBatch(batch)
 }
 
+// dbRevokeUserTokens revokes every token belonging to a user. Marking the
+// account first covers session tokens from before the sessions table, which
+// aren't indexed by user; the ones that are get deleted.
+func dbRevokeUserTokens(session *gocql.Session, userID gocql.UUID) error {
+	err := session.Query(
+		`UPDATE users SET tokens_revoked_at = ? WHERE user_id = ?`, time.Now(), userID,
+	).Exec()
+	if err != nil {
+		return err
+	}
+
+	var hashes []string
+	var h string
+	for _, q := range []string{
+		`SELECT token_hash FROM tokens_by_user WHERE user_id = ?`,
+		`SELECT token_hash FROM sessions WHERE user_id = ?`,
+	} {
+		iter := session.Query(q, userID).Iter()
+		for iter.Scan(&h) {
+			hashes = append(hashes, h)
+		}
+		if err := iter.Close(); err != nil {
+			return err
+		}
+	}
+
+	for _, h := range hashes {
+		if err := dbDeleteToken(session, h, userID); err != nil {
+			return err
+		}
+	}
+	return session.Query(`DELETE FROM sessions WHERE user_id = ?`, userID).Exec()
+}
+
 func dbListTokens(session *gocql.Session, userID gocql.UUID) ([]APIKey, error) {
 	iter := session.Query(
 		`SELECT token_hash, name, prefix, created_at FROM tokens_by_user WHERE user_id = ?`, userID,
@@ -117,13 +347,16 @@ func dbListTokens(session *gocql.Session, userID gocql.UUID) ([]APIKey, error) {
 
 func dbListLogsets(session *gocql.Session, userID gocql.UUID) ([]Logset, error) {
 	iter := session.Query(
-		`SELECT log_id, name, description FROM logs_meta WHERE user_id = ?`, userID,
+		`SELECT log_id, name, description, data FROM logs_meta WHERE user_id = ?`, userID,
 	).Iter()
 
 	var logsets []Logset
 	var d Logset
-	for iter.Scan(&d.LogID, &d.Name, &d.Description) {
+	var data string
+	for iter.Scan(&d.LogID, &d.Name, &d.Description, &data) {
 		d.UserID = userID.String()
+		d.Kind = "logs"
+		d.LogsetSettings = decodeLogsetSettings(data)
 		logsets = append(logsets, d)
 	}
 	if err := iter.Close(); err != nil {
@@ -134,36 +367,167 @@ func dbListLogsets(session *gocql.Session, userID gocql.UUID) ([]Logset, error)
 
 func dbGetLogset(session *gocql.Session, userID gocql.UUID, logID string) (Logset, error) {
 	var d Logset
+	var data string
 	err := session.Query(
 		`SELECT log_id, name, description, data FROM logs_meta WHERE user_id = ? AND log_id = ?`,
 		userID, logID,
-	).Scan(&d.LogID, &d.Name, &d.Description, &d.Data)
+	).Scan(&d.LogID, &d.Name, &d.Description, &data)
 	d.UserID = userID.String()
+	d.Kind = "logs"
+	d.LogsetSettings = decodeLogsetSettings(data)
 	return d, err
 }
 
-func dbCreateLogset(session *gocql.Session, userID gocql.UUID, logID, name, description string) error {
+func dbCreateLogset(session *gocql.Session, userID gocql.UUID, logID, name, description string, settings LogsetSettings) error {
 	return session.Query(
-		`INSERT INTO logs_meta (user_id, log_id, name, description) VALUES (?, ?, ?, ?)`,
-		userID, logID, name, description,
+		`INSERT INTO logs_meta (user_id, log_id, name, description, data) VALUES (?, ?, ?, ?, ?)`,
+		userID, logID, name, description, encodeLogsetSettings(settings),
 	).Exec()
 }
 
-func dbUpdateLogset(session *gocql.Session, userID gocql.UUID, logID, name, description string) error {
+func dbUpdateLogset(session *gocql.Session, userID gocql.UUID, logID, name, description string, settings LogsetSettings) error {
 	return session.Query(
-		`UPDATE logs_meta SET name = ?, description = ? WHERE user_id = ? AND log_id = ?`,
-		name, description, userID, logID,
+		`UPDATE logs_meta SET name = ?, description = ?, data = ? WHERE user_id = ? AND log_id = ?`,
+		name, description, encodeLogsetSettings(settings), userID, logID,
 	).Exec()
 }
 
+// dbDeleteLogset removes a logset along with its inbound hooks, so their
+// URLs stop accepting data, its alert rules and history, its heartbeat and
+// its field catalog.
 func dbDeleteLogset(session *gocql.Session, userID gocql.UUID, logID string) error {
+	hooks, err := dbListHooks(session, userID, logID)
+	if err != nil {
+		return err
+	}
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`DELETE FROM logs_meta WHERE user_id = ? AND log_id = ?`, userID, logID)
+	for _, h := range hooks {
+		batch.Query(`DELETE FROM hooks WHERE hook_id = ?`, h.HookID)
+	}
+	batch.Query(`DELETE FROM hooks_by_user WHERE user_id = ? AND log_id = ?`, userID, logID)
+	batch.Query(`DELETE FROM alert_rules WHERE user_id = ? AND log_id = ?`, userID, logID)
+	batch.Query(`DELETE FROM alert_state WHERE user_id = ? AND log_id = ?`, userID, logID)
+	batch.Query(`DELETE FROM alert_history WHERE user_id = ? AND log_id = ?`, userID, logID)
+	batch.Query(`DELETE FROM heartbeats WHERE user_id = ? AND log_id = ?`, userID, logID)
+	batch.Query(`DELETE FROM logset_fields WHERE user_id = ? AND log_id = ?`, userID, logID)
+	if err := session.ExecuteBatch(batch); err != nil {
+		return err
+	}
+	// Counters can't share a batch with other writes.
 	return session.Query(
-		`DELETE FROM logs_meta WHERE user_id = ? AND log_id = ?`, userID, logID,
+		`DELETE FROM logset_field_counts WHERE user_id = ? AND log_id = ?`, userID, logID,
 	).Exec()
 }
 
+// dbListHeartbeats returns the user's heartbeat settings and last entries
+// by logset. Status is left for the caller to work out.
+func dbListHeartbeats(session *gocql.Session, userID gocql.UUID) (map[string]*Heartbeat, error) {
+	iter := session.Query(
+		`SELECT log_id, interval_seconds, grace_seconds, last_entry FROM heartbeats WHERE user_id = ?`, userID,
+	).Iter()
+
+	heartbeats := map[string]*Heartbeat{}
+	var logID string
+	var h Heartbeat
+	var lastEntry time.Time
+	for iter.Scan(&logID, &h.IntervalSeconds, &h.GraceSeconds, &lastEntry) {
+		hb := h
+		if !lastEntry.IsZero() {
+			t := lastEntry
+			hb.LastEntry = &t
+		}
+		heartbeats[logID] = &hb
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return heartbeats, nil
+}
+
+// dbPutHeartbeat makes a logset a heartbeat or changes its settings,
+// keeping its status and last entry.
+func dbPutHeartbeat(session *gocql.Session, userID gocql.UUID, logID string, h Heartbeat, isNew bool) error {
+	if isNew {
+		return session.Query(
+			`INSERT INTO heartbeats (user_id, log_id, interval_seconds, grace_seconds, status, since) VALUES (?, ?, ?, ?, 'new', ?)`,
+			userID, logID, h.IntervalSeconds, h.GraceSeconds, time.Now(),
+		).Exec()
+	}
+	return session.Query(
+		`UPDATE heartbeats SET interval_seconds = ?, grace_seconds = ? WHERE user_id = ? AND log_id = ?`,
+		h.IntervalSeconds, h.GraceSeconds, userID, logID,
+	).Exec()
+}
+
+func dbDeleteHeartbeat(session *gocql.Session, userID gocql.UUID, logID string) error {
+	return session.Query(
+		`DELETE FROM heartbeats WHERE user_id = ? AND log_id = ?`, userID, logID,
+	).Exec()
+}
+
+type Field struct {
+	Path      string    `json:"path"`
+	Types     []string  `json:"types"`
+	Count     int64     `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Min       *float64  `json:"min,omitempty"`
+	Max       *float64  `json:"max,omitempty"`
+}
+
+// dbListFields returns a logset's field catalog, sorted by path.
+func dbListFields(session *gocql.Session, userID gocql.UUID, logID string) ([]Field, error) {
+	counts := map[string]int64{}
+	iter := session.Query(
+		`SELECT path, entries FROM logset_field_counts WHERE user_id = ? AND log_id = ?`, userID, logID,
+	).Iter()
+	var path string
+	var count int64
+	for iter.Scan(&path, &count) {
+		counts[path] = count
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	iter = session.Query(
+		`SELECT path, types, first_seen, last_seen, min_value, max_value FROM logset_fields WHERE user_id = ? AND log_id = ?`,
+		userID, logID,
+	).Iter()
+	var fields []Field
+	var f Field
+	for iter.Scan(&f.Path, &f.Types, &f.FirstSeen, &f.LastSeen, &f.Min, &f.Max) {
+		f.Count = counts[f.Path]
+		sort.Strings(f.Types)
+		fields = append(fields, f)
+		f = Field{}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// dbOldestLogTime returns the recv_time of a logset's oldest entry, or nil
+// if it has none.
+func dbOldestLogTime(session *gocql.Session, userID gocql.UUID, logID string) (*time.Time, error) {
+	var t time.Time
+	err := session.Query(
+		`SELECT recv_time FROM logs WHERE user_id = ? AND log_id = ? ORDER BY recv_time ASC LIMIT 1`,
+		userID, logID,
+	).Scan(&t)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
 func dbQueryLogs(session *gocql.Session, userID gocql.UUID, logID string, limit int, before, after *time.Time) ([]LogEntry, error) {
-	query := `SELECT recv_time, data FROM logs WHERE user_id = ? AND log_id = ?`
+	query := `SELECT recv_time, data, redacted, key_id FROM logs WHERE user_id = ? AND log_id = ?`
 	args := []interface{}{userID, logID}
 
 	if before != nil && after != nil {
@@ -184,11 +548,670 @@ func dbQueryLogs(session *gocql.Session, userID gocql.UUID, logID string, limit
 
 	var entries []LogEntry
 	var e LogEntry
-	for iter.Scan(&e.RecvTime, &e.Data) {
+	var keyID gocql.UUID
+	var openErr error
+	for iter.Scan(&e.RecvTime, &e.Data, &e.Redacted, &keyID) {
+		// An entry that won't open is marked rather than failing the page,
+		// so the rest of the logset stays readable and pages still line up.
+		data, err := keyStore.Open(userID, keyID, atrest.EntryAD(userID, logID, e.RecvTime), e.Data)
+		if err != nil {
+			if openErr == nil {
+				openErr = err
+			}
+			e.Data, e.Error = "", "entry can't be decrypted"
+		} else {
+			e.Data = string(data)
+		}
 		entries = append(entries, e)
+		e = LogEntry{}
+		keyID = gocql.UUID{}
+	}
+	if openErr != nil {
+		log.Printf("logset %s: %v", logID, openErr)
 	}
 	if err := iter.Close(); err != nil {
 		return nil, err
 	}
 	return entries, nil
 }
+
+type Invite struct {
+	CodeHash  string    `json:"code_hash"`
+	Prefix    string    `json:"prefix"`
+	MaxUses   int       `json:"max_uses"`
+	Uses      int       `json:"uses"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func dbCreateInvite(session *gocql.Session, codeHash string, userID gocql.UUID, prefix string, maxUses int, expiresAt time.Time) error {
+	now := time.Now()
+	ttl := int(expiresAt.Sub(now).Seconds())
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(
+		`INSERT INTO invites (code_hash, created_by, max_uses, uses, expires_at, created_at) VALUES (?, ?, ?, 0, ?, ?) USING TTL ?`,
+		codeHash, userID, maxUses, expiresAt, now, ttl,
+	)
+	batch.Query(
+		`INSERT INTO invites_by_user (user_id, code_hash, prefix, max_uses, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?) USING TTL ?`,
+		userID, codeHash, prefix, maxUses, expiresAt, now, ttl,
+	)
+	return session.ExecuteBatch(batch)
+}
+
+func dbGetInvite(session *gocql.Session, codeHash string) (Invite, gocql.UUID, error) {
+	var inv Invite
+	var createdBy gocql.UUID
+	err := session.Query(
+		`SELECT code_hash, created_by, max_uses, uses, expires_at, created_at FROM invites WHERE code_hash = ?`, codeHash,
+	).Scan(&inv.CodeHash, &createdBy, &inv.MaxUses, &inv.Uses, &inv.ExpiresAt, &inv.CreatedAt)
+	return inv, createdBy, err
+}
+
+// dbUseInvite bumps the use count with a lightweight transaction so two
+// signups racing on the last use can't both succeed.
+func dbUseInvite(session *gocql.Session, inv Invite) (bool, error) {
+	ttl := int(time.Until(inv.ExpiresAt).Seconds())
+	if ttl < 1 {
+		return false, nil
+	}
+	var current int
+	return session.Query(
+		`UPDATE invites USING TTL ? SET uses = ? WHERE code_hash = ? IF uses = ?`,
+		ttl, inv.Uses+1, inv.CodeHash, inv.Uses,
+	).ScanCAS(&current)
+}
+
+// dbReleaseInvite gives back one use, for signups that failed after
+// redeeming the invite.
+func dbReleaseInvite(session *gocql.Session, inv Invite) (bool, error) {
+	ttl := int(time.Until(inv.ExpiresAt).Seconds())
+	if ttl < 1 || inv.Uses < 1 {
+		return true, nil
+	}
+	var current int
+	return session.Query(
+		`UPDATE invites USING TTL ? SET uses = ? WHERE code_hash = ? IF uses = ?`,
+		ttl, inv.Uses-1, inv.CodeHash, inv.Uses,
+	).ScanCAS(&current)
+}
+
+func dbListInvites(session *gocql.Session, userID gocql.UUID) ([]Invite, error) {
+	iter := session.Query(
+		`SELECT code_hash, prefix, max_uses, expires_at, created_at FROM invites_by_user WHERE user_id = ?`, userID,
+	).Iter()
+
+	var invites []Invite
+	var inv Invite
+	for iter.Scan(&inv.CodeHash, &inv.Prefix, &inv.MaxUses, &inv.ExpiresAt, &inv.CreatedAt) {
+		invites = append(invites, inv)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	for i := range invites {
+		if cur, _, err := dbGetInvite(session, invites[i].CodeHash); err == nil {
+			invites[i].Uses = cur.Uses
+		}
+	}
+	return invites, nil
+}
+
+func dbDeleteInvite(session *gocql.Session, codeHash string, userID gocql.UUID) error {
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`DELETE FROM invites WHERE code_hash = ?`, codeHash)
+	batch.Query(`DELETE FROM invites_by_user WHERE user_id = ? AND code_hash = ?`, userID, codeHash)
+	return session.ExecuteBatch(batch)
+}
+
+type OIDCState struct {
+	Nonce    string
+	Verifier string
+	UserID   *gocql.UUID
+}
+
+func dbCreateOIDCState(session *gocql.Session, state string, st OIDCState) error {
+	return session.Query(
+		`INSERT INTO oidc_states (state, nonce, verifier, user_id) VALUES (?, ?, ?, ?)`,
+		state, st.Nonce, st.Verifier, st.UserID,
+	).Exec()
+}
+
+// dbTakeOIDCState loads and deletes a pending authorization state so each
+// one can only complete a single callback.
+func dbTakeOIDCState(session *gocql.Session, state string) (OIDCState, error) {
+	var st OIDCState
+	err := session.Query(
+		`SELECT nonce, verifier, user_id FROM oidc_states WHERE state = ?`, state,
+	).Scan(&st.Nonce, &st.Verifier, &st.UserID)
+	if err != nil {
+		return st, err
+	}
+	return st, session.Query(`DELETE FROM oidc_states WHERE state = ?`, state).Exec()
+}
+
+func dbGetUserByIdentity(session *gocql.Session, issuer, subject string) (gocql.UUID, error) {
+	var userID gocql.UUID
+	err := session.Query(
+		`SELECT user_id FROM oidc_identities WHERE issuer = ? AND subject = ?`, issuer, subject,
+	).Scan(&userID)
+	return userID, err
+}
+
+// dbLinkIdentity links an identity to an account, replacing any identity
+// from the same issuer that was linked before; the old one can't log in
+// afterwards.
+func dbLinkIdentity(session *gocql.Session, userID gocql.UUID, issuer, subject string) error {
+	var old string
+	err := session.Query(
+		`SELECT subject FROM oidc_identities_by_user WHERE user_id = ? AND issuer = ?`, userID, issuer,
+	).Scan(&old)
+	if err != nil && !errors.Is(err, gocql.ErrNotFound) {
+		return err
+	}
+
+	now := time.Now()
+	batch := session.NewBatch(gocql.LoggedBatch)
+	if old != "" && old != subject {
+		batch.Query(`DELETE FROM oidc_identities WHERE issuer = ? AND subject = ?`, issuer, old)
+	}
+	batch.Query(
+		`INSERT INTO oidc_identities (issuer, subject, user_id, created_at) VALUES (?, ?, ?, ?)`,
+		issuer, subject, userID, now,
+	)
+	batch.Query(
+		`INSERT INTO oidc_identities_by_user (user_id, issuer, subject, created_at) VALUES (?, ?, ?, ?)`,
+		userID, issuer, subject, now,
+	)
+	return session.ExecuteBatch(batch)
+}
+
+func dbUnlinkIdentity(session *gocql.Session, userID gocql.UUID, issuer string) error {
+	var subject string
+	err := session.Query(
+		`SELECT subject FROM oidc_identities_by_user WHERE user_id = ? AND issuer = ?`, userID, issuer,
+	).Scan(&subject)
+	if err != nil {
+		return err
+	}
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`DELETE FROM oidc_identities WHERE issuer = ? AND subject = ?`, issuer, subject)
+	batch.Query(`DELETE FROM oidc_identities_by_user WHERE user_id = ? AND issuer = ?`, userID, issuer)
+	return session.ExecuteBatch(batch)
+}
+
+type Hook struct {
+	HookID          string    `json:"hook_id"`
+	LogID           string    `json:"log_id"`
+	Name            string    `json:"name"`
+	SignatureHeader string    `json:"signature_header,omitempty"`
+	TimestampPath   string    `json:"timestamp_path,omitempty"`
+	HasSecret       bool      `json:"has_secret"`
+	CreatedAt       time.Time `json:"created_at"`
+	secret          string
+}
+
+// dbPutHook writes both copies of a hook; it serves create and update.
+func dbPutHook(session *gocql.Session, userID gocql.UUID, h Hook) error {
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(
+		`INSERT INTO hooks (hook_id, user_id, log_id, name, secret, signature_header, timestamp_path, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		h.HookID, userID, h.LogID, h.Name, h.secret, h.SignatureHeader, h.TimestampPath, h.CreatedAt,
+	)
+	batch.Query(
+		`INSERT INTO hooks_by_user (user_id, log_id, hook_id, name, secret, signature_header, timestamp_path, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, h.LogID, h.HookID, h.Name, h.secret, h.SignatureHeader, h.TimestampPath, h.CreatedAt,
+	)
+	return session.ExecuteBatch(batch)
+}
+
+func dbListHooks(session *gocql.Session, userID gocql.UUID, logID string) ([]Hook, error) {
+	iter := session.Query(
+		`SELECT hook_id, name, secret, signature_header, timestamp_path, created_at FROM hooks_by_user WHERE user_id = ? AND log_id = ?`,
+		userID, logID,
+	).Iter()
+
+	var hooks []Hook
+	h := Hook{LogID: logID}
+	for iter.Scan(&h.HookID, &h.Name, &h.secret, &h.SignatureHeader, &h.TimestampPath, &h.CreatedAt) {
+		h.HasSecret = h.secret != ""
+		hooks = append(hooks, h)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+func dbGetHook(session *gocql.Session, userID gocql.UUID, logID, hookID string) (Hook, error) {
+	h := Hook{HookID: hookID, LogID: logID}
+	err := session.Query(
+		`SELECT name, secret, signature_header, timestamp_path, created_at FROM hooks_by_user WHERE user_id = ? AND log_id = ? AND hook_id = ?`,
+		userID, logID, hookID,
+	).Scan(&h.Name, &h.secret, &h.SignatureHeader, &h.TimestampPath, &h.CreatedAt)
+	h.HasSecret = h.secret != ""
+	return h, err
+}
+
+func dbDeleteHook(session *gocql.Session, userID gocql.UUID, logID, hookID string) error {
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`DELETE FROM hooks WHERE hook_id = ?`, hookID)
+	batch.Query(`DELETE FROM hooks_by_user WHERE user_id = ? AND log_id = ? AND hook_id = ?`, userID, logID, hookID)
+	return session.ExecuteBatch(batch)
+}
+
+type AlertCondition struct {
+	Field string          `json:"field"`
+	Op    string          `json:"op"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+type AlertRule struct {
+	AlertID         string          `json:"alert_id"`
+	LogID           string          `json:"log_id"`
+	Name            string          `json:"name"`
+	Kind            string          `json:"kind"`
+	Condition       *AlertCondition `json:"condition,omitempty"`
+	WindowSeconds   int             `json:"window_seconds"`
+	ForSeconds      int             `json:"for_seconds"`
+	CooldownSeconds int             `json:"cooldown_seconds"`
+	CreatedAt       time.Time       `json:"created_at"`
+	State           string          `json:"state,omitempty"`
+	Since           *time.Time      `json:"since,omitempty"`
+	LastFired       *time.Time      `json:"last_fired,omitempty"`
+}
+
+type AlertEvent struct {
+	AlertID string    `json:"alert_id"`
+	Name    string    `json:"name"`
+	State   string    `json:"state"`
+	Time    time.Time `json:"time"`
+	Data    string    `json:"data,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+func scanAlertRule(logID string, ruleID gocql.UUID, condition string, a *AlertRule) {
+	a.AlertID = ruleID.String()
+	a.LogID = logID
+	a.Condition = nil
+	if condition != "" {
+		var c AlertCondition
+		if json.Unmarshal([]byte(condition), &c) == nil {
+			a.Condition = &c
+		}
+	}
+}
+
+func dbListAlertRules(session *gocql.Session, userID gocql.UUID, logID string) ([]AlertRule, error) {
+	iter := session.Query(
+		`SELECT rule_id, name, kind, condition, window_seconds, for_seconds, cooldown_seconds, created_at FROM alert_rules WHERE user_id = ? AND log_id = ?`,
+		userID, logID,
+	).Iter()
+
+	var rules []AlertRule
+	var a AlertRule
+	var ruleID gocql.UUID
+	var condition string
+	for iter.Scan(&ruleID, &a.Name, &a.Kind, &condition, &a.WindowSeconds, &a.ForSeconds, &a.CooldownSeconds, &a.CreatedAt) {
+		scanAlertRule(logID, ruleID, condition, &a)
+		rules = append(rules, a)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	type alertState struct {
+		state     string
+		since     time.Time
+		lastFired time.Time
+	}
+	states := map[gocql.UUID]alertState{}
+	iter = session.Query(
+		`SELECT rule_id, state, since, last_fired FROM alert_state WHERE user_id = ? AND log_id = ?`,
+		userID, logID,
+	).Iter()
+	var s alertState
+	for iter.Scan(&ruleID, &s.state, &s.since, &s.lastFired) {
+		states[ruleID] = s
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		id, _ := gocql.ParseUUID(rules[i].AlertID)
+		s := states[id]
+		rules[i].State = s.state
+		if !s.since.IsZero() {
+			rules[i].Since = &s.since
+		}
+		if !s.lastFired.IsZero() {
+			rules[i].LastFired = &s.lastFired
+		}
+	}
+	return rules, nil
+}
+
+func dbGetAlertRule(session *gocql.Session, userID gocql.UUID, logID string, ruleID gocql.UUID) (AlertRule, error) {
+	var a AlertRule
+	var condition string
+	err := session.Query(
+		`SELECT name, kind, condition, window_seconds, for_seconds, cooldown_seconds, created_at FROM alert_rules WHERE user_id = ? AND log_id = ? AND rule_id = ?`,
+		userID, logID, ruleID,
+	).Scan(&a.Name, &a.Kind, &condition, &a.WindowSeconds, &a.ForSeconds, &a.CooldownSeconds, &a.CreatedAt)
+	scanAlertRule(logID, ruleID, condition, &a)
+	return a, err
+}
+
+// dbPutAlertRule writes a rule. New rules also get an ok state row, with
+// last_seen starting now so a silence rule has something to count from.
+func dbPutAlertRule(session *gocql.Session, userID gocql.UUID, ruleID gocql.UUID, a AlertRule, isNew bool) error {
+	var condition string
+	if a.Condition != nil {
+		b, err := json.Marshal(a.Condition)
+		if err != nil {
+			return err
+		}
+		condition = string(b)
+	}
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(
+		`INSERT INTO alert_rules (user_id, log_id, rule_id, name, kind, condition, window_seconds, for_seconds, cooldown_seconds, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, a.LogID, ruleID, a.Name, a.Kind, condition, a.WindowSeconds, a.ForSeconds, a.CooldownSeconds, a.CreatedAt,
+	)
+	if isNew {
+		batch.Query(
+			`INSERT INTO alert_state (user_id, log_id, rule_id, state, since, last_seen) VALUES (?, ?, ?, 'ok', ?, ?)`,
+			userID, a.LogID, ruleID, a.CreatedAt, a.CreatedAt,
+		)
+	}
+	return session.ExecuteBatch(batch)
+}
+
+func dbDeleteAlertRule(session *gocql.Session, userID gocql.UUID, logID string, ruleID gocql.UUID) error {
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`DELETE FROM alert_rules WHERE user_id = ? AND log_id = ? AND rule_id = ?`, userID, logID, ruleID)
+	batch.Query(`DELETE FROM alert_state WHERE user_id = ? AND log_id = ? AND rule_id = ?`, userID, logID, ruleID)
+	return session.ExecuteBatch(batch)
+}
+
+func dbListAlertHistory(session *gocql.Session, userID gocql.UUID, logID string, limit int) ([]AlertEvent, error) {
+	iter := session.Query(
+		`SELECT event_id, rule_id, name, state, data, key_id FROM alert_history WHERE user_id = ? AND log_id = ? LIMIT ?`,
+		userID, logID, limit,
+	).Iter()
+
+	var events []AlertEvent
+	var e AlertEvent
+	var eventID, ruleID, keyID gocql.UUID
+	var openErr error
+	for iter.Scan(&eventID, &ruleID, &e.Name, &e.State, &e.Data, &keyID) {
+		e.AlertID = ruleID.String()
+		e.Time = eventID.Time()
+		// Marked like entries that won't open, so the rest of the history
+		// stays readable.
+		data, err := keyStore.Open(userID, keyID, atrest.AlertEventAD(userID, logID, eventID), e.Data)
+		if err != nil {
+			if openErr == nil {
+				openErr = err
+			}
+			e.Data, e.Error = "", "event data can't be decrypted"
+		} else {
+			e.Data = string(data)
+		}
+		events = append(events, e)
+		e = AlertEvent{}
+		keyID = gocql.UUID{}
+	}
+	if openErr != nil {
+		log.Printf("alert history %s: %v", logID, openErr)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+type Webhook struct {
+	WebhookID string          `json:"webhook_id"`
+	LogID     string          `json:"log_id"`
+	Name      string          `json:"name"`
+	URL       string          `json:"url"`
+	Events    []string        `json:"events"`
+	Filter    *AlertCondition `json:"filter,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	secret    string
+}
+
+type WebhookDelivery struct {
+	DeliveryID  string          `json:"delivery_id"`
+	Event       string          `json:"event"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt *time.Time      `json:"next_attempt,omitempty"`
+	LastStatus  int             `json:"last_status,omitempty"`
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	Payload     json.RawMessage `json:"payload"`
+	Error       string          `json:"error,omitempty"`
+}
+
+func scanWebhook(webhookID gocql.UUID, filter string, h *Webhook) {
+	h.WebhookID = webhookID.String()
+	h.Filter = nil
+	if filter != "" {
+		var c AlertCondition
+		if json.Unmarshal([]byte(filter), &c) == nil {
+			h.Filter = &c
+		}
+	}
+}
+
+func dbListWebhooks(session *gocql.Session, userID gocql.UUID) ([]Webhook, error) {
+	iter := session.Query(
+		`SELECT webhook_id, log_id, name, url, secret, events, filter, created_at FROM webhooks WHERE user_id = ?`, userID,
+	).Iter()
+
+	var hooks []Webhook
+	var h Webhook
+	var webhookID gocql.UUID
+	var filter string
+	for iter.Scan(&webhookID, &h.LogID, &h.Name, &h.URL, &h.secret, &h.Events, &filter, &h.CreatedAt) {
+		scanWebhook(webhookID, filter, &h)
+		hooks = append(hooks, h)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+func dbGetWebhook(session *gocql.Session, userID, webhookID gocql.UUID) (Webhook, error) {
+	var h Webhook
+	var filter string
+	err := session.Query(
+		`SELECT log_id, name, url, secret, events, filter, created_at FROM webhooks WHERE user_id = ? AND webhook_id = ?`,
+		userID, webhookID,
+	).Scan(&h.LogID, &h.Name, &h.URL, &h.secret, &h.Events, &filter, &h.CreatedAt)
+	scanWebhook(webhookID, filter, &h)
+	return h, err
+}
+
+func dbPutWebhook(session *gocql.Session, userID, webhookID gocql.UUID, h Webhook) error {
+	var filter string
+	if h.Filter != nil {
+		b, err := json.Marshal(h.Filter)
+		if err != nil {
+			return err
+		}
+		filter = string(b)
+	}
+	return session.Query(
+		`INSERT INTO webhooks (user_id, webhook_id, log_id, name, url, secret, events, filter, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, webhookID, h.LogID, h.Name, h.URL, h.secret, h.Events, filter, h.CreatedAt,
+	).Exec()
+}
+
+func dbDeleteWebhook(session *gocql.Session, userID, webhookID gocql.UUID) error {
+	return session.Query(
+		`DELETE FROM webhooks WHERE user_id = ? AND webhook_id = ?`, userID, webhookID,
+	).Exec()
+}
+
+// webhookDueBucket is the width of webhook_due's partitions; it must match
+// the ingesters'.
+const webhookDueBucket = time.Hour
+
+// dbEnqueueWebhook queues a delivery for the ingesters' dispatchers.
+func dbEnqueueWebhook(session *gocql.Session, userID, webhookID, deliveryID gocql.UUID, event string, payload []byte) error {
+	sealed, keyID, err := keyStore.Seal(userID, atrest.DeliveryAD(webhookID, deliveryID), payload)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(
+		`INSERT INTO webhook_deliveries (webhook_id, delivery_id, user_id, event, payload, key_id, status, attempts, next_attempt, updated_at) VALUES (?, ?, ?, ?, ?, ?, 'pending', 0, ?, ?)`,
+		webhookID, deliveryID, userID, event, sealed, keyID, now, now,
+	)
+	batch.Query(
+		`INSERT INTO webhook_due (bucket, next_attempt, delivery_id, webhook_id) VALUES (?, ?, ?, ?)`,
+		now.Truncate(webhookDueBucket), now, deliveryID, webhookID,
+	)
+	return session.ExecuteBatch(batch)
+}
+
+func dbListWebhookDeliveries(session *gocql.Session, webhookID gocql.UUID, status string, limit int) ([]WebhookDelivery, error) {
+	iter := session.Query(
+		`SELECT delivery_id, user_id, event, payload, key_id, status, attempts, next_attempt, last_status, last_error, updated_at FROM webhook_deliveries WHERE webhook_id = ?`,
+		webhookID,
+	).Iter()
+
+	var deliveries []WebhookDelivery
+	var d WebhookDelivery
+	var deliveryID, userID, keyID gocql.UUID
+	var payload string
+	var next time.Time
+	var openErr error
+	for len(deliveries) < limit && iter.Scan(&deliveryID, &userID, &d.Event, &payload, &keyID, &d.Status, &d.Attempts, &next, &d.LastStatus, &d.LastError, &d.UpdatedAt) {
+		if status != "" && d.Status != status {
+			keyID = gocql.UUID{}
+			continue
+		}
+		d.DeliveryID = deliveryID.String()
+		d.CreatedAt = deliveryID.Time()
+		d.Payload, d.Error = nil, ""
+		body, err := keyStore.Open(userID, keyID, atrest.DeliveryAD(webhookID, deliveryID), payload)
+		if err != nil {
+			if openErr == nil {
+				openErr = err
+			}
+			d.Error = "payload can't be decrypted"
+		} else {
+			d.Payload = json.RawMessage(body)
+		}
+		keyID = gocql.UUID{}
+		d.NextAttempt = nil
+		if d.Status == "pending" {
+			t := next
+			d.NextAttempt = &t
+		}
+		deliveries = append(deliveries, d)
+	}
+	if openErr != nil {
+		log.Printf("webhook %s deliveries: %v", webhookID, openErr)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// dbRetryWebhookDelivery puts a dead delivery back in the queue with a
+// fresh set of attempts.
+func dbRetryWebhookDelivery(session *gocql.Session, webhookID, deliveryID gocql.UUID) (bool, error) {
+	now := time.Now()
+	applied, err := session.Query(
+		`UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt = ?, updated_at = ? WHERE webhook_id = ? AND delivery_id = ? IF status = 'dead'`,
+		now, now, webhookID, deliveryID,
+	).MapScanCAS(map[string]interface{}{})
+	if err != nil || !applied {
+		return applied, err
+	}
+	return true, session.Query(
+		`INSERT INTO webhook_due (bucket, next_attempt, delivery_id, webhook_id) VALUES (?, ?, ?, ?)`,
+		now.Truncate(webhookDueBucket), now, deliveryID, webhookID,
+	).Exec()
+}
+
+type NotificationTarget struct {
+	Address   string    `json:"address"`
+	Events    []string  `json:"events"`
+	Verified  bool      `json:"verified"`
+	CreatedAt time.Time `json:"created_at"`
+	codeHash  string
+}
+
+func (t NotificationTarget) takes(event string) bool {
+	for _, e := range t.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func dbListNotificationTargets(session *gocql.Session, userID gocql.UUID) ([]NotificationTarget, error) {
+	iter := session.Query(
+		`SELECT address, events, verified, code_hash, created_at FROM notification_targets WHERE user_id = ?`, userID,
+	).Iter()
+
+	var targets []NotificationTarget
+	var t NotificationTarget
+	for iter.Scan(&t.Address, &t.Events, &t.Verified, &t.codeHash, &t.CreatedAt) {
+		targets = append(targets, t)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+func dbGetNotificationTarget(session *gocql.Session, userID gocql.UUID, address string) (NotificationTarget, error) {
+	t := NotificationTarget{Address: address}
+	err := session.Query(
+		`SELECT events, verified, code_hash, created_at FROM notification_targets WHERE user_id = ? AND address = ?`,
+		userID, address,
+	).Scan(&t.Events, &t.Verified, &t.codeHash, &t.CreatedAt)
+	return t, err
+}
+
+func dbPutNotificationTarget(session *gocql.Session, userID gocql.UUID, t NotificationTarget) error {
+	return session.Query(
+		`INSERT INTO notification_targets (user_id, address, events, verified, code_hash, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, t.Address, t.Events, t.Verified, t.codeHash, t.CreatedAt,
+	).Exec()
+}
+
+// dbCountConfirmationSends counts the confirmation emails sent for an
+// account in the last day; notification_sends rows expire after that.
+func dbCountConfirmationSends(session *gocql.Session, userID gocql.UUID) (int, error) {
+	var n int
+	err := session.Query(
+		`SELECT COUNT(*) FROM notification_sends WHERE user_id = ?`, userID,
+	).Scan(&n)
+	return n, err
+}
+
+func dbRecordConfirmationSend(session *gocql.Session, userID gocql.UUID) error {
+	return session.Query(
+		`INSERT INTO notification_sends (user_id, sent_at) VALUES (?, ?)`, userID, gocql.TimeUUID(),
+	).Exec()
+}
+
+func dbDeleteNotificationTarget(session *gocql.Session, userID gocql.UUID, address string) error {
+	return session.Query(
+		`DELETE FROM notification_targets WHERE user_id = ? AND address = ?`, userID, address,
+	).Exec()
+}
@@ -0,0 +1,34 @@
+// AI-assisted code
+package main
+
+import "net/http"
+
+// Encrypted logsets hold entries their clients encrypted before sending.
+// The ingester stores each envelope as it is, so the server never sees the
+// plaintext, and anything here that would need to read entries is refused.
+
+// refuseEncrypted answers 409 when the logset is encrypted, naming what
+// needed the plaintext, and reports whether it did.
+func refuseEncrypted(w http.ResponseWriter, logset Logset, feature string) bool {
+	if !logset.Encrypted {
+		return false
+	}
+	writeError(w, http.StatusConflict, feature+" needs plaintext, and this logset is end-to-end encrypted")
+	return true
+}
+
+// plaintextConflict returns an error message when an encrypted logset's
+// settings include something that reads entries, or "".
+func (s LogsetSettings) plaintextConflict() string {
+	switch {
+	case !s.Encrypted:
+		return ""
+	case s.Schema != nil:
+		return "encrypted logsets can't have a schema"
+	case s.Pipeline != nil:
+		return "encrypted logsets can't have a pipeline"
+	case s.Redaction != nil:
+		return "encrypted logsets can't have redaction rules"
+	}
+	return ""
+}
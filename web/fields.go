@@ -0,0 +1,66 @@
+// AI-assisted code
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// The field catalog is kept up to date by the ingesters as entries are
+// stored. It only covers entries stored since it was introduced.
+
+func handleListFields(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	logID := r.PathValue("id")
+
+	logset, err := dbGetLogset(session, userID, logID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "logset not found")
+		return
+	}
+	if refuseEncrypted(w, logset, "the field catalog") {
+		return
+	}
+
+	fields, err := dbListFields(session, userID, logID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list fields")
+		return
+	}
+	if fields == nil {
+		fields = []Field{}
+	}
+	writeJSON(w, http.StatusOK, fields)
+}
+
+// catalogColumns returns the catalog's top-level keys, in path order, for
+// use as CSV columns. It returns nil if the catalog is empty or doesn't
+// reach back to the logset's oldest entry, as for entries stored before
+// the catalog existed.
+func catalogColumns(userID gocql.UUID, logID string) ([]string, error) {
+	fields, err := dbListFields(session, userID, logID)
+	if err != nil || len(fields) == 0 {
+		return nil, err
+	}
+	var since time.Time
+	var keys []string
+	for _, f := range fields {
+		if !f.FirstSeen.IsZero() && (since.IsZero() || f.FirstSeen.Before(since)) {
+			since = f.FirstSeen
+		}
+		if !strings.ContainsAny(f.Path, ".[") {
+			keys = append(keys, f.Path)
+		}
+	}
+	oldest, err := dbOldestLogTime(session, userID, logID)
+	if err != nil {
+		return nil, err
+	}
+	if oldest != nil && (since.IsZero() || oldest.Before(since)) {
+		return nil, nil
+	}
+	return keys, nil
+}
@@ -0,0 +1,19 @@
+// AI-assisted code
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSVRow(t *testing.T) {
+	var m map[string]interface{}
+	json.Unmarshal([]byte(`{"host": "nas", "perc": 93.10, "ok": true, "tags": ["a"], "extra": 1}`), &m)
+	got := csvRow(time.Date(2025, 10, 13, 20, 0, 0, 0, time.UTC), m, []string{"host", "missing", "ok", "perc", "tags"})
+	want := "2025-10-13T20:00:00Z|nas||true|93.1|[\"a\"]"
+	if strings.Join(got, "|") != want {
+		t.Errorf("got %q", got)
+	}
+}
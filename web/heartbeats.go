@@ -0,0 +1,127 @@
+// AI-assisted code
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Heartbeat logsets expect an entry at least every interval, like a cron
+// job reporting in. The ingesters track their last entry and send the
+// late, down and up notifications; here the status is worked out from the
+// last entry when the logset is read.
+
+const (
+	minHeartbeatInterval  = 60
+	maxHeartbeatSeconds   = 366 * 24 * 3600
+	defaultHeartbeatGrace = 300
+)
+
+type heartbeatRequest struct {
+	IntervalSeconds *int `json:"interval_seconds"`
+	GraceSeconds    *int `json:"grace_seconds"`
+}
+
+// heartbeatSettings works out a logset's heartbeat from a create or update
+// request, starting from its current one. It returns nil for a plain
+// logset, or a validation error message.
+func heartbeatSettings(kind *string, req *heartbeatRequest, existing *Heartbeat) (*Heartbeat, string) {
+	k := "logs"
+	if existing != nil || req != nil {
+		k = "heartbeat"
+	}
+	if kind != nil {
+		k = *kind
+	}
+	switch {
+	case k != "logs" && k != "heartbeat":
+		return nil, "kind must be logs or heartbeat"
+	case k == "logs" && req != nil:
+		return nil, "heartbeat settings need kind heartbeat"
+	case k == "logs":
+		return nil, ""
+	}
+
+	h := Heartbeat{GraceSeconds: defaultHeartbeatGrace}
+	if existing != nil {
+		h = *existing
+	}
+	if req != nil {
+		if req.IntervalSeconds != nil {
+			h.IntervalSeconds = *req.IntervalSeconds
+		}
+		if req.GraceSeconds != nil {
+			h.GraceSeconds = *req.GraceSeconds
+		}
+	}
+	if h.IntervalSeconds == 0 {
+		return nil, "heartbeat.interval_seconds required"
+	}
+	if h.IntervalSeconds < minHeartbeatInterval || h.IntervalSeconds > maxHeartbeatSeconds {
+		return nil, fmt.Sprintf("heartbeat.interval_seconds must be %d-%d", minHeartbeatInterval, maxHeartbeatSeconds)
+	}
+	if h.GraceSeconds < 0 || h.GraceSeconds > maxHeartbeatSeconds {
+		return nil, fmt.Sprintf("heartbeat.grace_seconds must be 0-%d", maxHeartbeatSeconds)
+	}
+	return &h, ""
+}
+
+// setStatus fills in Status and Due for now, the same way the ingesters
+// decide when a heartbeat is late or down.
+func (h *Heartbeat) setStatus(now time.Time) {
+	h.Due = nil
+	if h.LastEntry == nil {
+		h.Status = "new"
+		return
+	}
+	interval := time.Duration(h.IntervalSeconds) * time.Second
+	grace := time.Duration(h.GraceSeconds) * time.Second
+	due := h.LastEntry.Add(interval)
+	h.Due = &due
+	switch since := now.Sub(*h.LastEntry); {
+	case since <= interval:
+		h.Status = "up"
+	case since <= interval+grace:
+		h.Status = "late"
+	default:
+		h.Status = "down"
+	}
+}
+
+// attachHeartbeats fills in Kind and Heartbeat for the user's logsets that
+// are heartbeats.
+func attachHeartbeats(userID gocql.UUID, logsets []Logset) error {
+	heartbeats, err := dbListHeartbeats(session, userID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for i := range logsets {
+		if h := heartbeats[logsets[i].LogID]; h != nil {
+			h.setStatus(now)
+			logsets[i].Kind = "heartbeat"
+			logsets[i].Heartbeat = h
+		}
+	}
+	return nil
+}
+
+// saveHeartbeat stores the result of heartbeatSettings for a logset that
+// previously had existing.
+func saveHeartbeat(userID gocql.UUID, logset *Logset, h, existing *Heartbeat) error {
+	if h == nil {
+		logset.Kind, logset.Heartbeat = "logs", nil
+		if existing == nil {
+			return nil
+		}
+		return dbDeleteHeartbeat(session, userID, logset.LogID)
+	}
+	if err := dbPutHeartbeat(session, userID, logset.LogID, *h, existing == nil); err != nil {
+		return err
+	}
+	h.setStatus(time.Now())
+	logset.Kind, logset.Heartbeat = "heartbeat", h
+	return nil
+}
@@ -0,0 +1,76 @@
+// AI-assisted code
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeartbeatSettings(t *testing.T) {
+	str := func(s string) *string { return &s }
+	num := func(n int) *int { return &n }
+	existing := &Heartbeat{IntervalSeconds: 3600, GraceSeconds: 600}
+
+	tests := []struct {
+		name     string
+		kind     *string
+		req      *heartbeatRequest
+		existing *Heartbeat
+		want     *Heartbeat
+		msg      string
+	}{
+		{name: "plain logset"},
+		{name: "plain stays plain", kind: str("logs")},
+		{name: "new heartbeat", kind: str("heartbeat"), req: &heartbeatRequest{IntervalSeconds: num(3600)},
+			want: &Heartbeat{IntervalSeconds: 3600, GraceSeconds: defaultHeartbeatGrace}},
+		{name: "settings imply kind", req: &heartbeatRequest{IntervalSeconds: num(60), GraceSeconds: num(0)},
+			want: &Heartbeat{IntervalSeconds: 60}},
+		{name: "missing interval", kind: str("heartbeat"), msg: "heartbeat.interval_seconds required"},
+		{name: "interval too short", req: &heartbeatRequest{IntervalSeconds: num(59)},
+			msg: "heartbeat.interval_seconds must be 60-31622400"},
+		{name: "negative grace", req: &heartbeatRequest{IntervalSeconds: num(60), GraceSeconds: num(-1)},
+			msg: "heartbeat.grace_seconds must be 0-31622400"},
+		{name: "unknown kind", kind: str("cron"), msg: "kind must be logs or heartbeat"},
+		{name: "settings on plain", kind: str("logs"), req: &heartbeatRequest{IntervalSeconds: num(60)},
+			msg: "heartbeat settings need kind heartbeat"},
+		{name: "update keeps settings", existing: existing, req: &heartbeatRequest{GraceSeconds: num(60)},
+			want: &Heartbeat{IntervalSeconds: 3600, GraceSeconds: 60}},
+		{name: "unrelated update", existing: existing, want: existing},
+		{name: "back to plain", existing: existing, kind: str("logs")},
+	}
+	for _, tt := range tests {
+		got, msg := heartbeatSettings(tt.kind, tt.req, tt.existing)
+		if msg != tt.msg {
+			t.Errorf("%s: msg %q, want %q", tt.name, msg, tt.msg)
+			continue
+		}
+		if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHeartbeatSetStatus(t *testing.T) {
+	last := time.Date(2025, 10, 13, 20, 0, 0, 0, time.UTC)
+	h := Heartbeat{IntervalSeconds: 3600, GraceSeconds: 600}
+
+	h.setStatus(last)
+	if h.Status != "new" || h.Due != nil {
+		t.Errorf("no entries: %s, due %v", h.Status, h.Due)
+	}
+
+	h.LastEntry = &last
+	for after, want := range map[time.Duration]string{
+		time.Hour:                  "up",
+		time.Hour + time.Minute:    "late",
+		time.Hour + 11*time.Minute: "down",
+	} {
+		h.setStatus(last.Add(after))
+		if h.Status != want {
+			t.Errorf("after %s: %s, want %s", after, h.Status, want)
+		}
+		if !h.Due.Equal(last.Add(time.Hour)) {
+			t.Errorf("due %v", h.Due)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// AI-assisted code
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Inbound webhooks give services that can't send the ingest envelope a URL
+// of their own: the ingester's POST /hook/{hook_id} stores whatever body it
+// receives in the hook's logset. The hook ID is the only credential unless
+// a secret is set, in which case requests must carry an HMAC signature.
+
+const defaultSignatureHeader = "X-Hub-Signature-256"
+
+func hookPath(hookID string) string {
+	return "/hook/" + hookID
+}
+
+func handleListHooks(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	logID := r.PathValue("id")
+
+	if _, err := dbGetLogset(session, userID, logID); err != nil {
+		writeError(w, http.StatusNotFound, "logset not found")
+		return
+	}
+	hooks, err := dbListHooks(session, userID, logID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list hooks")
+		return
+	}
+	if hooks == nil {
+		hooks = []Hook{}
+	}
+	writeJSON(w, http.StatusOK, hooks)
+}
+
+func handleCreateHook(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	logID := r.PathValue("id")
+
+	logset, err := dbGetLogset(session, userID, logID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "logset not found")
+		return
+	}
+	// Hooks turn whatever they're sent into entries, so they'd store
+	// plaintext.
+	if refuseEncrypted(w, logset, "an inbound webhook") {
+		return
+	}
+
+	var req struct {
+		Name            string `json:"name"`
+		Secret          string `json:"secret"`
+		SignatureHeader string `json:"signature_header"`
+		TimestampPath   string `json:"timestamp_path"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if req.Secret != "" && req.SignatureHeader == "" {
+		req.SignatureHeader = defaultSignatureHeader
+	}
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to generate hook")
+		return
+	}
+	h := Hook{
+		HookID:          hex.EncodeToString(idBytes),
+		LogID:           logID,
+		Name:            req.Name,
+		SignatureHeader: req.SignatureHeader,
+		TimestampPath:   req.TimestampPath,
+		HasSecret:       req.Secret != "",
+		CreatedAt:       time.Now(),
+		secret:          req.Secret,
+	}
+	if err := dbPutHook(session, userID, h); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create hook")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"hook": h,
+		"path": hookPath(h.HookID),
+	})
+}
+
+func handleUpdateHook(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	logID := r.PathValue("id")
+
+	h, err := dbGetHook(session, userID, logID, r.PathValue("hook_id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "hook not found")
+		return
+	}
+
+	var req struct {
+		Name            *string `json:"name"`
+		Secret          *string `json:"secret"`
+		SignatureHeader *string `json:"signature_header"`
+		TimestampPath   *string `json:"timestamp_path"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	if req.Name != nil {
+		h.Name = *req.Name
+	}
+	if req.Secret != nil {
+		h.secret = *req.Secret
+	}
+	if req.SignatureHeader != nil {
+		h.SignatureHeader = *req.SignatureHeader
+	}
+	if req.TimestampPath != nil {
+		h.TimestampPath = *req.TimestampPath
+	}
+	if h.secret != "" && h.SignatureHeader == "" {
+		h.SignatureHeader = defaultSignatureHeader
+	}
+	h.HasSecret = h.secret != ""
+
+	if err := dbPutHook(session, userID, h); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update hook")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"hook": h,
+		"path": hookPath(h.HookID),
+	})
+}
+
+func handleDeleteHook(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	logID := r.PathValue("id")
+	hookID := r.PathValue("hook_id")
+
+	if _, err := dbGetHook(session, userID, logID, hookID); err != nil {
+		writeError(w, http.StatusNotFound, "hook not found")
+		return
+	}
+	if err := dbDeleteHook(session, userID, logID, hookID); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to delete hook")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
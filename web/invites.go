@@ -0,0 +1,178 @@
+// AI-assisted code
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// invitePolicy reports who may mint invite codes: "admin" (default), "all"
+// or "off".
+func invitePolicy() string {
+	switch p := os.Getenv("INVITES"); p {
+	case "all", "off":
+		return p
+	default:
+		return "admin"
+	}
+}
+
+// registrationMode is advertised by /api/info: "open" with
+// PUBLIC_REGISTRATION=true, else "invite" unless invites are off, else
+// "closed".
+func registrationMode() string {
+	if os.Getenv("PUBLIC_REGISTRATION") == "true" {
+		return "open"
+	}
+	if invitePolicy() != "off" {
+		return "invite"
+	}
+	return "closed"
+}
+
+var errInvalidInvite = errors.New("invalid or expired invite code")
+
+// redeemInvite consumes one use of an invite code.
+func redeemInvite(code string) error {
+	codeHash := hashSHA256(code)
+	for attempt := 0; attempt < 5; attempt++ {
+		inv, _, err := dbGetInvite(session, codeHash)
+		if err != nil {
+			return errInvalidInvite
+		}
+		if inv.Uses >= inv.MaxUses || time.Now().After(inv.ExpiresAt) {
+			return errInvalidInvite
+		}
+		applied, err := dbUseInvite(session, inv)
+		if err != nil {
+			return err
+		}
+		if applied {
+			return nil
+		}
+	}
+	return errInvalidInvite
+}
+
+// releaseInvite gives back a use taken by redeemInvite.
+func releaseInvite(code string) error {
+	codeHash := hashSHA256(code)
+	for attempt := 0; attempt < 5; attempt++ {
+		inv, _, err := dbGetInvite(session, codeHash)
+		if err == gocql.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		applied, err := dbReleaseInvite(session, inv)
+		if err != nil || applied {
+			return err
+		}
+	}
+	return errors.New("invite release contended")
+}
+
+func handleListInvites(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	invites, err := dbListInvites(session, userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list invites")
+		return
+	}
+	if invites == nil {
+		invites = []Invite{}
+	}
+	writeJSON(w, http.StatusOK, invites)
+}
+
+func handleCreateInvite(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+
+	switch invitePolicy() {
+	case "off":
+		writeError(w, http.StatusForbidden, "invites are disabled")
+		return
+	case "admin":
+		user, err := dbGetUser(session, userID)
+		if err != nil || !user.IsAdmin {
+			writeError(w, http.StatusForbidden, "admin only")
+			return
+		}
+	}
+
+	var req struct {
+		MaxUses   int `json:"max_uses"`
+		ExpiresIn int `json:"expires_in_hours"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	}
+	if req.ExpiresIn == 0 {
+		req.ExpiresIn = 7 * 24
+	}
+	if req.MaxUses < 1 || req.MaxUses > 1000 {
+		writeError(w, http.StatusBadRequest, "max_uses must be 1-1000")
+		return
+	}
+	if req.ExpiresIn < 1 || req.ExpiresIn > 24*365 {
+		writeError(w, http.StatusBadRequest, "expires_in_hours must be 1-8760")
+		return
+	}
+
+	codeBytes := make([]byte, 8)
+	if _, err := rand.Read(codeBytes); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to generate invite")
+		return
+	}
+	code := hex.EncodeToString(codeBytes)
+	codeHash := hashSHA256(code)
+	prefix := code[:4]
+	expiresAt := time.Now().Add(time.Duration(req.ExpiresIn) * time.Hour)
+
+	if err := dbCreateInvite(session, codeHash, userID, prefix, req.MaxUses, expiresAt); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create invite")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"invite_code": code,
+		"code_hash":   codeHash,
+		"prefix":      prefix,
+		"max_uses":    req.MaxUses,
+		"expires_at":  expiresAt,
+	})
+}
+
+func handleDeleteInvite(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	codeHash := r.PathValue("hash")
+
+	_, createdBy, err := dbGetInvite(session, codeHash)
+	if err != nil && !errors.Is(err, gocql.ErrNotFound) {
+		writeError(w, http.StatusInternalServerError, "failed to delete invite")
+		return
+	}
+	if err == nil && createdBy != userID {
+		writeError(w, http.StatusNotFound, "invite not found")
+		return
+	}
+
+	if err := dbDeleteInvite(session, codeHash, userID); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to delete invite")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"sort"
 	"strconv"
@@ -52,6 +53,7 @@ func handleQueryLogs(w http.ResponseWriter, r *http.Request) {
 
 	entries, err := dbQueryLogs(session, userID, logID, limit, before, after)
 	if err != nil {
+		log.Printf("query logs %s: %v", logID, err)
 		writeError(w, http.StatusInternalServerError, "failed to query logs")
 		return
 	}
@@ -89,8 +91,25 @@ func handleExportLogs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	format := r.URL.Query().Get("format")
+	if format == "csv" && refuseEncrypted(w, ds, "CSV export") {
+		return
+	}
+	if format == "csv" {
+		cols, err := catalogColumns(userID, logID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to list fields")
+			return
+		}
+		if cols != nil {
+			streamCSV(w, ds.Name, userID, logID, cols)
+			return
+		}
+	}
+
 	entries, err := collectAllLogs(userID, logID)
 	if err != nil {
+		log.Printf("export %s: %v", logID, err)
 		writeError(w, http.StatusInternalServerError, "failed to query logs")
 		return
 	}
@@ -98,7 +117,6 @@ func handleExportLogs(w http.ResponseWriter, r *http.Request) {
 		entries = []LogEntry{}
 	}
 
-	format := r.URL.Query().Get("format")
 	if format == "csv" {
 		exportCSV(w, ds.Name, entries)
 	} else {
@@ -109,6 +127,7 @@ func handleExportLogs(w http.ResponseWriter, r *http.Request) {
 type exportEntry struct {
 	RecvTime time.Time       `json:"recv_time"`
 	Data     json.RawMessage `json:"data"`
+	Redacted []string        `json:"redacted,omitempty"`
 }
 
 func exportJSON(w http.ResponseWriter, name string, entries []LogEntry) {
@@ -118,6 +137,7 @@ func exportJSON(w http.ResponseWriter, name string, entries []LogEntry) {
 	out := make([]exportEntry, len(entries))
 	for i, e := range entries {
 		out[i].RecvTime = e.RecvTime
+		out[i].Redacted = e.Redacted
 		if json.Valid([]byte(e.Data)) {
 			out[i].Data = json.RawMessage(e.Data)
 		} else {
@@ -157,26 +177,64 @@ func exportCSV(w http.ResponseWriter, name string, entries []LogEntry) {
 	cw.Write(header)
 
 	for i, e := range entries {
-		row := []string{e.RecvTime.Format(time.RFC3339)}
-		for _, col := range cols {
-			v, ok := parsed[i][col]
-			if !ok {
-				row = append(row, "")
-				continue
-			}
-			switch val := v.(type) {
-			case string:
-				row = append(row, val)
-			case float64:
-				row = append(row, strconv.FormatFloat(val, 'f', -1, 64))
-			case bool:
-				row = append(row, strconv.FormatBool(val))
-			default:
-				b, _ := json.Marshal(val)
-				row = append(row, string(b))
+		cw.Write(csvRow(e.RecvTime, parsed[i], cols))
+	}
+	cw.Flush()
+}
+
+// streamCSV writes a CSV export a batch at a time, with the columns known
+// up front from the field catalog. Entries that fail to load partway
+// through end the file early; the header is already sent.
+func streamCSV(w http.ResponseWriter, name string, userID gocql.UUID, logID string, cols []string) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, name))
+
+	cw := csv.NewWriter(w)
+	cw.Write(append([]string{"time"}, cols...))
+
+	const batch = 1000
+	var before *time.Time
+	for {
+		entries, err := dbQueryLogs(session, userID, logID, batch, before, nil)
+		if err != nil {
+			log.Printf("csv export %s: %v", logID, err)
+			break
+		}
+		for _, e := range entries {
+			var m map[string]interface{}
+			if err := json.Unmarshal([]byte(e.Data), &m); err != nil {
+				m = map[string]interface{}{"data": e.Data}
 			}
+			cw.Write(csvRow(e.RecvTime, m, cols))
 		}
-		cw.Write(row)
+		cw.Flush()
+		if len(entries) < batch {
+			break
+		}
+		before = &entries[len(entries)-1].RecvTime
 	}
-	cw.Flush()
+}
+
+// csvRow formats one entry's top-level values as a CSV row.
+func csvRow(t time.Time, m map[string]interface{}, cols []string) []string {
+	row := []string{t.Format(time.RFC3339)}
+	for _, col := range cols {
+		v, ok := m[col]
+		if !ok {
+			row = append(row, "")
+			continue
+		}
+		switch val := v.(type) {
+		case string:
+			row = append(row, val)
+		case float64:
+			row = append(row, strconv.FormatFloat(val, 'f', -1, 64))
+		case bool:
+			row = append(row, strconv.FormatBool(val))
+		default:
+			b, _ := json.Marshal(val)
+			row = append(row, string(b))
+		}
+	}
+	return row
 }
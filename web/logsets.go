@@ -15,6 +15,10 @@ func handleListLogsets(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, "failed to list logsets")
 		return
 	}
+	if err := attachHeartbeats(userID, logsets); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list logsets")
+		return
+	}
 	if logsets == nil {
 		logsets = []Logset{}
 	}
@@ -24,8 +28,14 @@ func handleListLogsets(w http.ResponseWriter, r *http.Request) {
 func handleCreateLogset(w http.ResponseWriter, r *http.Request) {
 	userID := getUserID(r)
 	var req struct {
-		Name        string `json:"name"`
-		Description string `json:"description"`
+		Name        string            `json:"name"`
+		Description string            `json:"description"`
+		Kind        *string           `json:"kind"`
+		Heartbeat   *heartbeatRequest `json:"heartbeat"`
+		Encrypted   bool              `json:"encrypted"`
+		schemaRequest
+		pipelineRequest
+		redactionRequest
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeError(w, http.StatusBadRequest, "invalid json")
@@ -35,19 +45,47 @@ func handleCreateLogset(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "name required")
 		return
 	}
+	heartbeat, msg := heartbeatSettings(req.Kind, req.Heartbeat, nil)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	settings := LogsetSettings{Encrypted: req.Encrypted}
+	if msg := req.schemaRequest.apply(&settings); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if msg := req.pipelineRequest.apply(&settings); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if msg := req.redactionRequest.apply(&settings); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if msg := settings.plaintextConflict(); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
 
 	logID := gocql.TimeUUID().String()
-	if err := dbCreateLogset(session, userID, logID, req.Name, req.Description); err != nil {
+	if err := dbCreateLogset(session, userID, logID, req.Name, req.Description, settings); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create logset")
+		return
+	}
+	created := Logset{
+		LogID:          logID,
+		UserID:         userID.String(),
+		Name:           req.Name,
+		Description:    req.Description,
+		LogsetSettings: settings,
+	}
+	if err := saveHeartbeat(userID, &created, heartbeat, nil); err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to create logset")
 		return
 	}
 
-	writeJSON(w, http.StatusCreated, Logset{
-		LogID:       logID,
-		UserID:      userID.String(),
-		Name:        req.Name,
-		Description: req.Description,
-	})
+	writeJSON(w, http.StatusCreated, created)
 }
 
 func handleGetLogset(w http.ResponseWriter, r *http.Request) {
@@ -59,8 +97,13 @@ func handleGetLogset(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusNotFound, "logset not found")
 		return
 	}
+	logsets := []Logset{logset}
+	if err := attachHeartbeats(userID, logsets); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to get logset")
+		return
+	}
 
-	writeJSON(w, http.StatusOK, logset)
+	writeJSON(w, http.StatusOK, logsets[0])
 }
 
 func handleUpdateLogset(w http.ResponseWriter, r *http.Request) {
@@ -73,14 +116,53 @@ func handleUpdateLogset(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	existingList := []Logset{existing}
+	if err := attachHeartbeats(userID, existingList); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update logset")
+		return
+	}
+	existing = existingList[0]
+
 	var req struct {
-		Name        *string `json:"name"`
-		Description *string `json:"description"`
+		Name        *string           `json:"name"`
+		Description *string           `json:"description"`
+		Kind        *string           `json:"kind"`
+		Heartbeat   *heartbeatRequest `json:"heartbeat"`
+		Encrypted   *bool             `json:"encrypted"`
+		schemaRequest
+		pipelineRequest
+		redactionRequest
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeError(w, http.StatusBadRequest, "invalid json")
 		return
 	}
+	heartbeat, msg := heartbeatSettings(req.Kind, req.Heartbeat, existing.Heartbeat)
+	if msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if req.Encrypted != nil && *req.Encrypted != existing.Encrypted {
+		writeError(w, http.StatusBadRequest, "encrypted can only be set when creating a logset")
+		return
+	}
+	settings := existing.LogsetSettings
+	if msg := req.schemaRequest.apply(&settings); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if msg := req.pipelineRequest.apply(&settings); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if msg := req.redactionRequest.apply(&settings); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if msg := settings.plaintextConflict(); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
 
 	name := existing.Name
 	description := existing.Description
@@ -91,24 +173,32 @@ func handleUpdateLogset(w http.ResponseWriter, r *http.Request) {
 		description = *req.Description
 	}
 
-	if err := dbUpdateLogset(session, userID, logID, name, description); err != nil {
+	if err := dbUpdateLogset(session, userID, logID, name, description, settings); err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to update logset")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, Logset{
-		LogID:       logID,
-		UserID:      userID.String(),
-		Name:        name,
-		Description: description,
-	})
+	updated := Logset{
+		LogID:          logID,
+		UserID:         userID.String(),
+		Name:           name,
+		Description:    description,
+		LogsetSettings: settings,
+	}
+	if err := saveHeartbeat(userID, &updated, heartbeat, existing.Heartbeat); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update logset")
+		return
+	}
+	notifyLogsetChange(userID, updated, "updated")
+	writeJSON(w, http.StatusOK, updated)
 }
 
 func handleDeleteLogset(w http.ResponseWriter, r *http.Request) {
 	userID := getUserID(r)
 	logID := r.PathValue("id")
 
-	if _, err := dbGetLogset(session, userID, logID); err != nil {
+	existing, err := dbGetLogset(session, userID, logID)
+	if err != nil {
 		writeError(w, http.StatusNotFound, "logset not found")
 		return
 	}
@@ -117,6 +207,7 @@ func handleDeleteLogset(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, "failed to delete logset")
 		return
 	}
+	notifyLogsetChange(userID, existing, "deleted")
 
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
@@ -0,0 +1,144 @@
+// AI-assisted code
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Email notices go through an SMTP relay configured with the SMTP_*
+// variables. Addresses are notification targets only: they're attached to
+// an account after a confirmation code reaches them, and never identify
+// anyone. The ingester has a copy of the sender for alert emails.
+
+const smtpTimeout = 30 * time.Second
+
+type smtpConfig struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+	startTLS bool
+}
+
+// smtpConfigFromEnv returns nil when email is not configured.
+func smtpConfigFromEnv() *smtpConfig {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return nil
+	}
+	c := &smtpConfig{
+		host:     host,
+		port:     os.Getenv("SMTP_PORT"),
+		username: os.Getenv("SMTP_USERNAME"),
+		password: os.Getenv("SMTP_PASSWORD"),
+		from:     os.Getenv("SMTP_FROM"),
+		startTLS: os.Getenv("SMTP_STARTTLS") != "false",
+	}
+	if c.port == "" {
+		c.port = "587"
+	}
+	if c.from == "" {
+		c.from = "librelog@" + host
+	}
+	return c
+}
+
+// headerSafe keeps user-chosen names from breaking out of a header.
+func headerSafe(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+func (c *smtpConfig) send(to []string, subject, body string) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(c.host, c.port), smtpTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+	client, err := smtp.NewClient(conn, c.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if c.startTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("smtp server doesn't offer STARTTLS")
+		}
+		if err := client.StartTLS(&tls.Config{ServerName: c.host}); err != nil {
+			return err
+		}
+	}
+	if c.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.username, c.password, c.host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(c.from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := client.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", c.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", headerSafe(subject)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	if _, err := w.Write([]byte(msg.String())); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// notifyEmail mails a notice to the user's confirmed targets that take
+// event, in the background. Failures are only logged.
+func notifyEmail(userID gocql.UUID, event, subject, body string) {
+	cfg := smtpConfigFromEnv()
+	if cfg == nil {
+		return
+	}
+	go func() {
+		targets, err := dbListNotificationTargets(session, userID)
+		if err != nil {
+			log.Println("email targets:", err)
+			return
+		}
+		var to []string
+		for _, t := range targets {
+			if t.Verified && t.takes(event) {
+				to = append(to, t.Address)
+			}
+		}
+		if len(to) == 0 {
+			return
+		}
+		if err := cfg.send(to, subject, body); err != nil {
+			log.Println("email:", err)
+		}
+	}()
+}
@@ -0,0 +1,169 @@
+// AI-assisted code
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeSMTP is a bare SMTP server that takes one message per connection
+// and keeps what it was sent. It never offers STARTTLS.
+type fakeSMTP struct {
+	ln net.Listener
+
+	mu   sync.Mutex
+	auth string // decoded AUTH PLAIN credentials
+	from string
+	rcpt []string
+	data string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) config() *smtpConfig {
+	host, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	return &smtpConfig{host: host, port: port, username: "user", password: "pass", from: "librelog@example.com"}
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 fake ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		s.mu.Lock()
+		switch verb {
+		case "EHLO":
+			reply("250-fake")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			creds, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "AUTH PLAIN "))
+			s.auth = string(creds)
+			reply("235 ok")
+		case "MAIL":
+			s.from = line
+			reply("250 ok")
+		case "RCPT":
+			s.rcpt = append(s.rcpt, line)
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var b strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil || l == ".\r\n" {
+					break
+				}
+				b.WriteString(l)
+			}
+			s.data = b.String()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			s.mu.Unlock()
+			return
+		default:
+			reply("502 not implemented")
+		}
+		s.mu.Unlock()
+	}
+}
+
+func TestSMTPSend(t *testing.T) {
+	srv := newFakeSMTP(t)
+	cfg := srv.config()
+
+	err := cfg.send([]string{"a@example.com", "b@example.com"}, "Alert: errors\r\nBcc: x@evil", "line one\nline two\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.auth != "\x00user\x00pass" {
+		t.Errorf("auth = %q", srv.auth)
+	}
+	if !strings.HasPrefix(srv.from, "MAIL FROM:<librelog@example.com>") {
+		t.Errorf("from = %q", srv.from)
+	}
+	if len(srv.rcpt) != 2 || !strings.Contains(srv.rcpt[1], "b@example.com") {
+		t.Errorf("rcpt = %q", srv.rcpt)
+	}
+	for _, want := range []string{
+		"From: librelog@example.com\r\n",
+		"To: a@example.com, b@example.com\r\n",
+		"Content-Type: text/plain; charset=utf-8\r\n",
+		"\r\n\r\nline one\r\nline two\r\n",
+	} {
+		if !strings.Contains(srv.data, want) {
+			t.Errorf("message missing %q:\n%s", want, srv.data)
+		}
+	}
+	if strings.Contains(srv.data, "\r\nBcc:") {
+		t.Errorf("subject broke out of its header:\n%s", srv.data)
+	}
+}
+
+func TestSMTPRequiresSTARTTLS(t *testing.T) {
+	srv := newFakeSMTP(t)
+	cfg := srv.config()
+	cfg.startTLS = true
+
+	err := cfg.send([]string{"a@example.com"}, "hi", "body")
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("err = %v, want STARTTLS refusal", err)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.auth != "" || srv.data != "" {
+		t.Error("credentials or message sent without TLS")
+	}
+}
+
+func TestSMTPConfigFromEnv(t *testing.T) {
+	t.Setenv("SMTP_HOST", "")
+	if smtpConfigFromEnv() != nil {
+		t.Fatal("config without SMTP_HOST")
+	}
+
+	t.Setenv("SMTP_HOST", "mail.example.com")
+	cfg := smtpConfigFromEnv()
+	if cfg.port != "587" || cfg.from != "librelog@mail.example.com" || !cfg.startTLS {
+		t.Errorf("defaults = %+v", cfg)
+	}
+
+	t.Setenv("SMTP_PORT", "1025")
+	t.Setenv("SMTP_STARTTLS", "false")
+	cfg = smtpConfigFromEnv()
+	if cfg.port != "1025" || cfg.startTLS {
+		t.Errorf("overrides = %+v", cfg)
+	}
+}
@@ -11,6 +11,8 @@ import (
 	"strings"
 
 	"github.com/gocql/gocql"
+
+	"shared/atrest"
 )
 
 //go:embed frontend/dist/*
@@ -41,14 +43,41 @@ func main() {
 	}
 	defer session.Close()
 
+	masterKeys, err := atrest.FromEnv()
+	if err != nil {
+		log.Fatal("MASTER_KEY: ", err)
+	}
+	keyStore = atrest.NewStore(session, masterKeys)
+
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		if err := runAdminCLI(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	bootstrapAdmins()
+
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("GET /api/info", func(w http.ResponseWriter, r *http.Request) {
-		writeJSON(w, http.StatusOK, map[string]bool{"registration": registrationOpen()})
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"registration": registrationMode(),
+			"oidc":         oidcEnabled(),
+			"email":        smtpConfigFromEnv() != nil,
+		})
 	})
 	mux.HandleFunc("POST /api/signup", handleSignup)
 	mux.HandleFunc("POST /api/login", handleLogin)
 	mux.HandleFunc("POST /api/logout", requireAuth(handleLogout))
+	mux.HandleFunc("POST /api/session/refresh", requireAuth(handleRefreshSession))
+	mux.HandleFunc("GET /api/sessions", requireAuth(handleListSessions))
+	mux.HandleFunc("DELETE /api/sessions/{id}", requireAuth(handleDeleteSession))
+
+	mux.HandleFunc("GET /api/oidc/login", handleOIDCLogin)
+	mux.HandleFunc("GET /api/oidc/callback", handleOIDCCallback)
+	mux.HandleFunc("POST /api/oidc/link", requireAuth(handleOIDCLink))
+	mux.HandleFunc("DELETE /api/oidc/link", requireAuth(handleOIDCUnlink))
 
 	mux.HandleFunc("GET /api/logsets", requireAuth(handleListLogsets))
 	mux.HandleFunc("POST /api/logsets", requireAuth(handleCreateLogset))
@@ -58,11 +87,48 @@ func main() {
 
 	mux.HandleFunc("GET /api/logsets/{id}/logs", requireAuth(handleQueryLogs))
 	mux.HandleFunc("GET /api/logsets/{id}/export", requireAuth(handleExportLogs))
+	mux.HandleFunc("GET /api/logsets/{id}/fields", requireAuth(handleListFields))
+	mux.HandleFunc("POST /api/logsets/{id}/pipeline/test", requireAuth(handleTestPipeline))
+
+	mux.HandleFunc("GET /api/logsets/{id}/hooks", requireAuth(handleListHooks))
+	mux.HandleFunc("POST /api/logsets/{id}/hooks", requireAuth(handleCreateHook))
+	mux.HandleFunc("PUT /api/logsets/{id}/hooks/{hook_id}", requireAuth(handleUpdateHook))
+	mux.HandleFunc("DELETE /api/logsets/{id}/hooks/{hook_id}", requireAuth(handleDeleteHook))
+
+	mux.HandleFunc("GET /api/logsets/{id}/alerts", requireAuth(handleListAlerts))
+	mux.HandleFunc("POST /api/logsets/{id}/alerts", requireAuth(handleCreateAlert))
+	mux.HandleFunc("GET /api/logsets/{id}/alerts/history", requireAuth(handleAlertHistory))
+	mux.HandleFunc("PUT /api/logsets/{id}/alerts/{alert_id}", requireAuth(handleUpdateAlert))
+	mux.HandleFunc("DELETE /api/logsets/{id}/alerts/{alert_id}", requireAuth(handleDeleteAlert))
+
+	mux.HandleFunc("GET /api/webhooks", requireAuth(handleListWebhooks))
+	mux.HandleFunc("POST /api/webhooks", requireAuth(handleCreateWebhook))
+	mux.HandleFunc("PUT /api/webhooks/{id}", requireAuth(handleUpdateWebhook))
+	mux.HandleFunc("DELETE /api/webhooks/{id}", requireAuth(handleDeleteWebhook))
+	mux.HandleFunc("GET /api/webhooks/{id}/deliveries", requireAuth(handleListWebhookDeliveries))
+	mux.HandleFunc("POST /api/webhooks/{id}/deliveries/{delivery_id}/retry", requireAuth(handleRetryWebhookDelivery))
+
+	mux.HandleFunc("GET /api/notifications/targets", requireAuth(handleListNotificationTargets))
+	mux.HandleFunc("POST /api/notifications/targets", requireAuth(handleAddNotificationTarget))
+	mux.HandleFunc("PUT /api/notifications/targets/{address}", requireAuth(handleUpdateNotificationTarget))
+	mux.HandleFunc("DELETE /api/notifications/targets/{address}", requireAuth(handleDeleteNotificationTarget))
+	mux.HandleFunc("POST /api/notifications/targets/{address}/verify", requireAuth(handleVerifyNotificationTarget))
+	mux.HandleFunc("POST /api/notifications/targets/{address}/test", requireAuth(handleTestNotificationTarget))
 
 	mux.HandleFunc("GET /api/tokens", requireAuth(handleListTokens))
 	mux.HandleFunc("POST /api/tokens", requireAuth(handleCreateToken))
 	mux.HandleFunc("DELETE /api/tokens/{hash}", requireAuth(handleDeleteToken))
 
+	mux.HandleFunc("GET /api/invites", requireAuth(handleListInvites))
+	mux.HandleFunc("POST /api/invites", requireAuth(handleCreateInvite))
+	mux.HandleFunc("DELETE /api/invites/{hash}", requireAuth(handleDeleteInvite))
+
+	mux.HandleFunc("GET /api/admin/users", requireAdmin(handleAdminListUsers))
+	mux.HandleFunc("POST /api/admin/users", requireAdmin(handleAdminCreateUser))
+	mux.HandleFunc("PUT /api/admin/users/{id}", requireAdmin(handleAdminUpdateUser))
+	mux.HandleFunc("PUT /api/admin/users/{id}/password", requireAdmin(handleAdminResetPassword))
+	mux.HandleFunc("DELETE /api/admin/users/{id}/tokens", requireAdmin(handleAdminRevokeTokens))
+
 	dist, _ := fs.Sub(frontendFS, "frontend/dist")
 	fileServer := http.FileServer(http.FS(dist))
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,268 @@
+// AI-assisted code
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+const maxNotificationTargets = 10
+
+// Confirmation emails go to addresses nobody has vouched for yet, so they
+// are limited: one per address per confirmationCooldown, and
+// maxConfirmationsPerDay per account.
+const (
+	confirmationCooldown   = 10 * time.Minute
+	maxConfirmationsPerDay = 10
+)
+
+var notificationEvents = map[string]bool{"alert": true, "api_key": true, "login": true, "heartbeat": true}
+
+func defaultNotificationEvents() []string {
+	return []string{"alert", "api_key", "login", "heartbeat"}
+}
+
+func validNotificationEvents(events []string) bool {
+	for _, e := range events {
+		if !notificationEvents[e] {
+			return false
+		}
+	}
+	return true
+}
+
+func handleListNotificationTargets(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	targets, err := dbListNotificationTargets(session, userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list targets")
+		return
+	}
+	if targets == nil {
+		targets = []NotificationTarget{}
+	}
+	writeJSON(w, http.StatusOK, targets)
+}
+
+// handleAddNotificationTarget adds an address and mails it a confirmation
+// code. Adding an unconfirmed address again sends a new code, once the
+// cooldown since the last one has passed.
+func handleAddNotificationTarget(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	cfg := smtpConfigFromEnv()
+	if cfg == nil {
+		writeError(w, http.StatusServiceUnavailable, "email is not configured on this server")
+		return
+	}
+
+	var req struct {
+		Address string   `json:"address"`
+		Events  []string `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	addr, err := mail.ParseAddress(req.Address)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid address")
+		return
+	}
+	address := strings.ToLower(addr.Address)
+	if req.Events == nil {
+		req.Events = defaultNotificationEvents()
+	}
+	if !validNotificationEvents(req.Events) {
+		writeError(w, http.StatusBadRequest, "events must be alert, api_key, login or heartbeat")
+		return
+	}
+
+	targets, err := dbListNotificationTargets(session, userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to add target")
+		return
+	}
+	for _, t := range targets {
+		if t.Address != address {
+			continue
+		}
+		if t.Verified {
+			writeError(w, http.StatusConflict, "address already added")
+			return
+		}
+		if time.Since(t.CreatedAt) < confirmationCooldown {
+			writeError(w, http.StatusTooManyRequests, "a code was sent to this address recently; try again later")
+			return
+		}
+	}
+	if len(targets) >= maxNotificationTargets {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("at most %d addresses", maxNotificationTargets))
+		return
+	}
+	sent, err := dbCountConfirmationSends(session, userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to add target")
+		return
+	}
+	if sent >= maxConfirmationsPerDay {
+		writeError(w, http.StatusTooManyRequests, "too many confirmation emails today; try again tomorrow")
+		return
+	}
+
+	codeBytes := make([]byte, 8)
+	if _, err := rand.Read(codeBytes); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to generate code")
+		return
+	}
+	code := hex.EncodeToString(codeBytes)
+	t := NotificationTarget{
+		Address:   address,
+		Events:    req.Events,
+		CreatedAt: time.Now(),
+		codeHash:  hashSHA256(code),
+	}
+	if err := dbPutNotificationTarget(session, userID, t); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to add target")
+		return
+	}
+	// Count the send before trying it, so failing sends use up the limit
+	// too.
+	if err := dbRecordConfirmationSend(session, userID); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to add target")
+		return
+	}
+
+	body := "Someone asked LibreLog to send notices for their account to this address.\n\n" +
+		"Confirmation code: " + code + "\n\n" +
+		"If that wasn't you, ignore this email and nothing more will be sent.\n"
+	if err := cfg.send([]string{address}, "Confirm your LibreLog notification address", body); err != nil {
+		writeError(w, http.StatusBadGateway, "failed to send confirmation email")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, t)
+}
+
+func handleVerifyNotificationTarget(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	address := strings.ToLower(r.PathValue("address"))
+
+	t, err := dbGetNotificationTarget(session, userID, address)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "target not found")
+		return
+	}
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if t.Verified {
+		writeJSON(w, http.StatusOK, t)
+		return
+	}
+	if t.codeHash == "" || subtle.ConstantTimeCompare([]byte(hashSHA256(req.Code)), []byte(t.codeHash)) != 1 {
+		writeError(w, http.StatusBadRequest, "invalid code")
+		return
+	}
+
+	t.Verified = true
+	t.codeHash = ""
+	if err := dbPutNotificationTarget(session, userID, t); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to verify target")
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+func handleUpdateNotificationTarget(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	address := strings.ToLower(r.PathValue("address"))
+
+	t, err := dbGetNotificationTarget(session, userID, address)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "target not found")
+		return
+	}
+	var req struct {
+		Events []string `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if req.Events == nil || !validNotificationEvents(req.Events) {
+		writeError(w, http.StatusBadRequest, "events must be alert, api_key, login or heartbeat")
+		return
+	}
+
+	t.Events = req.Events
+	if err := dbPutNotificationTarget(session, userID, t); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update target")
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+func handleDeleteNotificationTarget(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	address := strings.ToLower(r.PathValue("address"))
+
+	if err := dbDeleteNotificationTarget(session, userID, address); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to delete target")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleTestNotificationTarget sends a test email right away, reporting
+// the relay's error if it fails.
+func handleTestNotificationTarget(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	address := strings.ToLower(r.PathValue("address"))
+	cfg := smtpConfigFromEnv()
+	if cfg == nil {
+		writeError(w, http.StatusServiceUnavailable, "email is not configured on this server")
+		return
+	}
+
+	t, err := dbGetNotificationTarget(session, userID, address)
+	if err != nil || !t.Verified {
+		writeError(w, http.StatusNotFound, "target not found or not confirmed")
+		return
+	}
+	if err := cfg.send([]string{address}, "LibreLog test email", "Notices for your LibreLog account will arrive here.\n"); err != nil {
+		writeError(w, http.StatusBadGateway, "failed to send: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// loginFromNewIP reports whether none of the user's current sessions came
+// from ip. Sessions expire, so "new" means not seen lately.
+func loginFromNewIP(userID gocql.UUID, ip string) bool {
+	if smtpConfigFromEnv() == nil {
+		return false
+	}
+	sessions, err := dbListSessions(session, userID)
+	if err != nil {
+		return false
+	}
+	for _, s := range sessions {
+		if s.IP == ip {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,300 @@
+// AI-assisted code
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gocql/gocql"
+	"golang.org/x/oauth2"
+)
+
+// OIDC login is optional and only enabled when OIDC_ISSUER is set.
+// Account numbers stay the default way in.
+
+type oidcClient struct {
+	issuer   string
+	config   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+var (
+	oidcMu     sync.Mutex
+	oidcCached *oidcClient
+)
+
+func oidcEnabled() bool {
+	return os.Getenv("OIDC_ISSUER") != ""
+}
+
+// getOIDC runs provider discovery on first use rather than at startup, so
+// an unreachable identity provider doesn't keep the web service down.
+func getOIDC() (*oidcClient, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	if oidcCached != nil {
+		return oidcCached, nil
+	}
+
+	client, err := newOIDCClient(context.Background(), os.Getenv("OIDC_ISSUER"),
+		os.Getenv("OIDC_CLIENT_ID"), os.Getenv("OIDC_CLIENT_SECRET"), os.Getenv("OIDC_REDIRECT_URL"))
+	if err != nil {
+		return nil, err
+	}
+	oidcCached = client
+	return oidcCached, nil
+}
+
+func newOIDCClient(ctx context.Context, issuer, clientID, clientSecret, redirectURL string) (*oidcClient, error) {
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	return &oidcClient{
+		issuer: issuer,
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: clientID}),
+	}, nil
+}
+
+// exchange trades an authorization code for the subject of its verified ID
+// token, checking the nonce stored with the login attempt.
+func (c *oidcClient) exchange(ctx context.Context, code string, st OIDCState) (string, error) {
+	oauthToken, err := c.config.Exchange(ctx, code, oauth2.VerifierOption(st.Verifier))
+	if err != nil {
+		return "", err
+	}
+	rawIDToken, ok := oauthToken.Extra("id_token").(string)
+	if !ok {
+		return "", errors.New("missing id_token")
+	}
+	idToken, err := c.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return "", err
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(st.Nonce)) != 1 {
+		return "", errors.New("nonce mismatch")
+	}
+	return idToken.Subject, nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// oidcAuthURL stores a pending state and returns the provider URL to send
+// the browser to, along with the state. A non-nil userID links the identity
+// instead of logging in.
+func oidcAuthURL(userID *gocql.UUID) (string, string, error) {
+	client, err := getOIDC()
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomHex(16)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomHex(16)
+	if err != nil {
+		return "", "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	st := OIDCState{Nonce: nonce, Verifier: verifier, UserID: userID}
+	if err := dbCreateOIDCState(session, state, st); err != nil {
+		return "", "", err
+	}
+
+	return client.config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), state, nil
+}
+
+// The state is also kept in a cookie so the callback only completes in the
+// browser that started the flow. Otherwise someone could start a link or
+// login themselves and get a victim to finish it.
+const oidcStateCookie = "oidc_state"
+
+// oidcStateTTL matches the oidc_states table's default TTL.
+const oidcStateTTL = 600
+
+func setOIDCStateCookie(w http.ResponseWriter, r *http.Request, state string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/oidc/callback",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		// Lax still sends the cookie on the provider's top-level redirect back.
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func oidcStateMatches(r *http.Request, state string) bool {
+	c, err := r.Cookie(oidcStateCookie)
+	return err == nil && state != "" && subtle.ConstantTimeCompare([]byte(c.Value), []byte(state)) == 1
+}
+
+func handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() {
+		writeError(w, http.StatusNotFound, "oidc not configured")
+		return
+	}
+	authURL, state, err := oidcAuthURL(nil)
+	if err != nil {
+		log.Println("oidc:", err)
+		writeError(w, http.StatusBadGateway, "identity provider unavailable")
+		return
+	}
+	setOIDCStateCookie(w, r, state, oidcStateTTL)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func handleOIDCLink(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() {
+		writeError(w, http.StatusNotFound, "oidc not configured")
+		return
+	}
+	userID := getUserID(r)
+	authURL, state, err := oidcAuthURL(&userID)
+	if err != nil {
+		log.Println("oidc:", err)
+		writeError(w, http.StatusBadGateway, "identity provider unavailable")
+		return
+	}
+	setOIDCStateCookie(w, r, state, oidcStateTTL)
+	writeJSON(w, http.StatusOK, map[string]string{"url": authURL})
+}
+
+func handleOIDCUnlink(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() {
+		writeError(w, http.StatusNotFound, "oidc not configured")
+		return
+	}
+	userID := getUserID(r)
+	if err := dbUnlinkIdentity(session, userID, os.Getenv("OIDC_ISSUER")); err != nil {
+		if errors.Is(err, gocql.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "no linked identity")
+		} else {
+			writeError(w, http.StatusInternalServerError, "failed to unlink identity")
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// oidcRedirect sends the browser back to the frontend with either a session
+// token or an error in the fragment, which never reaches server logs.
+func oidcRedirect(w http.ResponseWriter, r *http.Request, key, value string) {
+	http.Redirect(w, r, "/#/?"+key+"="+url.QueryEscape(value), http.StatusFound)
+}
+
+func handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() {
+		writeError(w, http.StatusNotFound, "oidc not configured")
+		return
+	}
+	client, err := getOIDC()
+	if err != nil {
+		log.Println("oidc:", err)
+		writeError(w, http.StatusBadGateway, "identity provider unavailable")
+		return
+	}
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		oidcRedirect(w, r, "error", e)
+		return
+	}
+	state := q.Get("state")
+	if !oidcStateMatches(r, state) {
+		oidcRedirect(w, r, "error", "login was started in another browser")
+		return
+	}
+	setOIDCStateCookie(w, r, "", -1)
+	st, err := dbTakeOIDCState(session, state)
+	if err != nil {
+		oidcRedirect(w, r, "error", "invalid or expired login attempt")
+		return
+	}
+
+	subject, err := client.exchange(r.Context(), q.Get("code"), st)
+	if err != nil {
+		log.Println("oidc exchange:", err)
+		oidcRedirect(w, r, "error", "failed to verify identity")
+		return
+	}
+
+	if st.UserID != nil {
+		if _, err := dbGetUserByIdentity(session, client.issuer, subject); err == nil {
+			oidcRedirect(w, r, "error", "identity already linked to an account")
+			return
+		}
+		if err := dbLinkIdentity(session, *st.UserID, client.issuer, subject); err != nil {
+			oidcRedirect(w, r, "error", "failed to link identity")
+			return
+		}
+		http.Redirect(w, r, "/#/app", http.StatusFound)
+		return
+	}
+
+	userID, err := dbGetUserByIdentity(session, client.issuer, subject)
+	if errors.Is(err, gocql.ErrNotFound) && os.Getenv("OIDC_AUTO_SIGNUP") == "true" {
+		userID, err = oidcSignup(client.issuer, subject)
+	}
+	if err != nil {
+		oidcRedirect(w, r, "error", "no account linked to this identity")
+		return
+	}
+
+	user, err := dbGetUser(session, userID)
+	if err != nil {
+		oidcRedirect(w, r, "error", "no account linked to this identity")
+		return
+	}
+	if user.Disabled {
+		oidcRedirect(w, r, "error", "account disabled")
+		return
+	}
+
+	token, err := issueSessionToken(r, userID)
+	if err != nil {
+		oidcRedirect(w, r, "error", "failed to create token")
+		return
+	}
+	oidcRedirect(w, r, "token", token)
+}
+
+// oidcSignup creates an account for a first-time identity. It gets a random
+// password, so the identity provider is the only way in until an admin
+// resets it.
+func oidcSignup(issuer, subject string) (gocql.UUID, error) {
+	password, err := randomHex(32)
+	if err != nil {
+		return gocql.UUID{}, err
+	}
+	_, userID, err := createAccount(password, "", false)
+	if err != nil {
+		return gocql.UUID{}, err
+	}
+	return userID, dbLinkIdentity(session, userID, issuer, subject)
+}
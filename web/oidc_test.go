@@ -0,0 +1,238 @@
+// AI-assisted code
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-jose/go-jose/v4"
+	"golang.org/x/oauth2"
+)
+
+// mockProvider is a minimal OIDC provider: discovery, JWKS, an authorize
+// endpoint that approves immediately and a token endpoint that checks PKCE.
+type mockProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	subject  string
+	nonce    string // overrides the nonce from the authorize request
+	codes    map[string]mockGrant
+	clientID string
+}
+
+type mockGrant struct {
+	nonce     string
+	challenge string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProvider{key: key, subject: "alice", clientID: "librelog", codes: map[string]mockGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &p.key.PublicKey, KeyID: "k1", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("GET /authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		code := "code-" + q.Get("state")
+		p.codes[code] = mockGrant{nonce: q.Get("nonce"), challenge: q.Get("code_challenge")}
+		redirect, _ := url.Parse(q.Get("redirect_uri"))
+		rq := redirect.Query()
+		rq.Set("code", code)
+		rq.Set("state", q.Get("state"))
+		redirect.RawQuery = rq.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		grant, ok := p.codes[r.Form.Get("code")]
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		nonce := grant.nonce
+		if p.nonce != "" {
+			nonce = p.nonce
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "at",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     p.idToken(t, nonce),
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *mockProvider) idToken(t *testing.T, nonce string) string {
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: p.key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "k1"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   p.URL,
+		"sub":   p.subject,
+		"aud":   p.clientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": nonce,
+	})
+	sig, err := signer.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := sig.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// authorize runs the browser side of the flow and returns the code the
+// provider sends back to the callback.
+func (p *mockProvider) authorize(t *testing.T, client *oidcClient, state string, st OIDCState) string {
+	t.Helper()
+	authURL := client.config.AuthCodeURL(state, oidc.Nonce(st.Nonce), oauth2.S256ChallengeOption(st.Verifier))
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := noFollow.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.Query().Get("state") != state {
+		t.Fatalf("state = %q, want %q", loc.Query().Get("state"), state)
+	}
+	return loc.Query().Get("code")
+}
+
+func newTestOIDCClient(t *testing.T, p *mockProvider) *oidcClient {
+	t.Helper()
+	client, err := newOIDCClient(context.Background(), p.URL, p.clientID, "secret", "http://librelog.test/api/oidc/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestOIDCLogin(t *testing.T) {
+	p := newMockProvider(t)
+	client := newTestOIDCClient(t, p)
+
+	st := OIDCState{Nonce: "n-123", Verifier: oauth2.GenerateVerifier()}
+	code := p.authorize(t, client, "s-123", st)
+
+	subject, err := client.exchange(context.Background(), code, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "alice" {
+		t.Fatalf("subject = %q, want alice", subject)
+	}
+
+	// A code is useless without the PKCE verifier it was issued for.
+	other := st
+	other.Verifier = oauth2.GenerateVerifier()
+	if _, err := client.exchange(context.Background(), code, other); err == nil {
+		t.Fatal("exchange succeeded with the wrong verifier")
+	}
+}
+
+func TestOIDCNonceMismatch(t *testing.T) {
+	p := newMockProvider(t)
+	p.nonce = "replayed"
+	client := newTestOIDCClient(t, p)
+
+	st := OIDCState{Nonce: "n-456", Verifier: oauth2.GenerateVerifier()}
+	code := p.authorize(t, client, "s-456", st)
+
+	if _, err := client.exchange(context.Background(), code, st); err == nil {
+		t.Fatal("exchange accepted an ID token with the wrong nonce")
+	}
+}
+
+// TestOIDCLinkStateCookie covers link CSRF: a callback URL started by
+// someone else must not complete in a browser without the matching state
+// cookie.
+func TestOIDCLinkStateCookie(t *testing.T) {
+	p := newMockProvider(t)
+	t.Setenv("OIDC_ISSUER", p.URL)
+	t.Setenv("OIDC_CLIENT_ID", p.clientID)
+	oidcMu.Lock()
+	oidcCached = nil
+	oidcMu.Unlock()
+	t.Cleanup(func() { oidcCached = nil })
+
+	rec := httptest.NewRecorder()
+	setOIDCStateCookie(rec, httptest.NewRequest("POST", "/api/oidc/link", nil), "s-789", oidcStateTTL)
+	cookie := rec.Result().Cookies()[0]
+	if !cookie.HttpOnly || cookie.Path != "/api/oidc/callback" || cookie.Value != "s-789" {
+		t.Fatalf("unexpected state cookie %+v", cookie)
+	}
+
+	for name, c := range map[string]*http.Cookie{
+		"no cookie":    nil,
+		"other cookie": {Name: oidcStateCookie, Value: "s-000"},
+	} {
+		req := httptest.NewRequest("GET", "/api/oidc/callback?state=s-789&code=code-s-789", nil)
+		if c != nil {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		handleOIDCCallback(rec, req)
+		loc := rec.Header().Get("Location")
+		if rec.Code != http.StatusFound || !strings.HasPrefix(loc, "/#/?error=") {
+			t.Errorf("%s: got %d %q, want an error redirect", name, rec.Code, loc)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/api/oidc/callback?state=s-789", nil)
+	req.AddCookie(cookie)
+	if !oidcStateMatches(req, "s-789") {
+		t.Fatal("matching cookie rejected")
+	}
+}
@@ -0,0 +1,88 @@
+// AI-assisted code
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"shared/pipeline"
+)
+
+const maxPipelineSize = 64 << 10
+
+type pipelineRequest struct {
+	Pipeline json.RawMessage `json:"pipeline"`
+}
+
+// apply merges a create or update request into s, returning a validation
+// error message or "". A null or empty pipeline removes it.
+func (req pipelineRequest) apply(s *LogsetSettings) string {
+	if req.Pipeline == nil {
+		return ""
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, req.Pipeline); err != nil {
+		return "invalid pipeline"
+	}
+	if c := compact.String(); c == "null" || c == "[]" {
+		s.Pipeline = nil
+		return ""
+	}
+	if compact.Len() > maxPipelineSize {
+		return fmt.Sprintf("pipeline must be at most %d bytes", maxPipelineSize)
+	}
+	if _, err := pipeline.Compile(compact.Bytes()); err != nil {
+		return "invalid pipeline: " + err.Error()
+	}
+	s.Pipeline = compact.Bytes()
+	return ""
+}
+
+// handleTestPipeline runs a sample entry through a pipeline without storing
+// it: the one in the request, or else the logset's own. Sample processors
+// keep every entry in a dry run.
+func handleTestPipeline(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	logID := r.PathValue("id")
+
+	logset, err := dbGetLogset(session, userID, logID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "logset not found")
+		return
+	}
+	if refuseEncrypted(w, logset, "a pipeline") {
+		return
+	}
+
+	var req struct {
+		Pipeline json.RawMessage `json:"pipeline"`
+		Data     json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if req.Data == nil {
+		writeError(w, http.StatusBadRequest, "data required")
+		return
+	}
+	raw := req.Pipeline
+	if raw == nil {
+		raw = logset.Pipeline
+	}
+	if raw == nil {
+		writeError(w, http.StatusBadRequest, "logset has no pipeline")
+		return
+	}
+	p, err := pipeline.Compile(raw)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid pipeline: "+err.Error())
+		return
+	}
+	p.Random = func() float64 { return 0 }
+
+	out, _ := p.Run(req.Data)
+	writeJSON(w, http.StatusOK, map[string]json.RawMessage{"data": out})
+}
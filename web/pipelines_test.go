@@ -0,0 +1,43 @@
+// AI-assisted code
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPipelineRequestApply(t *testing.T) {
+	existing := LogsetSettings{Pipeline: json.RawMessage(`[{"type":"drop","fields":["debug"]}]`)}
+
+	tests := []struct {
+		name string
+		req  string
+		want string
+		msg  string
+	}{
+		{name: "unchanged", req: `{}`, want: `[{"type":"drop","fields":["debug"]}]`},
+		{name: "replace", req: `{"pipeline": [ {"type": "enrich", "fields": {"site": "home"}} ]}`,
+			want: `[{"type":"enrich","fields":{"site":"home"}}]`},
+		{name: "remove", req: `{"pipeline": null}`, want: ``},
+		{name: "empty", req: `{"pipeline": []}`, want: ``},
+		{name: "invalid", req: `{"pipeline": [{"type": "sample"}]}`, msg: "invalid pipeline: processor 0 (sample): rate must be"},
+	}
+	for _, tt := range tests {
+		var req pipelineRequest
+		if err := json.Unmarshal([]byte(tt.req), &req); err != nil {
+			t.Fatal(err)
+		}
+		got := existing
+		msg := req.apply(&got)
+		if tt.msg != "" {
+			if !strings.HasPrefix(msg, tt.msg) {
+				t.Errorf("%s: msg %q, want %q", tt.name, msg, tt.msg)
+			}
+			continue
+		}
+		if msg != "" || string(got.Pipeline) != tt.want {
+			t.Errorf("%s: got %s %q, want %s", tt.name, got.Pipeline, msg, tt.want)
+		}
+	}
+}
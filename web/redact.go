@@ -0,0 +1,38 @@
+// AI-assisted code
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"shared/redact"
+)
+
+const maxRedactionSize = 64 << 10
+
+type redactionRequest struct {
+	Redaction json.RawMessage `json:"redaction"`
+}
+
+// apply merges a create or update request into s, returning a validation
+// error message or "". A null or empty list removes the rules. Rules are
+// stored with their default names filled in.
+func (req redactionRequest) apply(s *LogsetSettings) string {
+	if req.Redaction == nil {
+		return ""
+	}
+	if len(req.Redaction) > maxRedactionSize {
+		return fmt.Sprintf("redaction must be at most %d bytes", maxRedactionSize)
+	}
+	if c := strings.TrimSpace(string(req.Redaction)); c == "null" || c == "[]" {
+		s.Redaction = nil
+		return ""
+	}
+	r, err := redact.Compile(req.Redaction)
+	if err != nil {
+		return "invalid redaction: " + err.Error()
+	}
+	s.Redaction, _ = json.Marshal(r.Rules)
+	return ""
+}
@@ -0,0 +1,43 @@
+// AI-assisted code
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRedactionRequestApply(t *testing.T) {
+	existing := LogsetSettings{Redaction: json.RawMessage(`[{"name":"email","detector":"email","action":"mask"}]`)}
+
+	tests := []struct {
+		name string
+		req  string
+		want string
+		msg  string
+	}{
+		{name: "unchanged", req: `{}`, want: `[{"name":"email","detector":"email","action":"mask"}]`},
+		{name: "names filled in", req: `{"redaction": [{"detector": "field", "fields": ["ssn"], "action": "drop"}]}`,
+			want: `[{"name":"field","detector":"field","fields":["ssn"],"action":"drop"}]`},
+		{name: "remove", req: `{"redaction": null}`, want: ``},
+		{name: "empty", req: `{"redaction": []}`, want: ``},
+		{name: "invalid", req: `{"redaction": [{"detector": "regex", "pattern": "(", "action": "mask"}]}`, msg: "invalid redaction: rule 0 (regex): "},
+	}
+	for _, tt := range tests {
+		var req redactionRequest
+		if err := json.Unmarshal([]byte(tt.req), &req); err != nil {
+			t.Fatal(err)
+		}
+		got := existing
+		msg := req.apply(&got)
+		if tt.msg != "" {
+			if !strings.HasPrefix(msg, tt.msg) {
+				t.Errorf("%s: msg %q, want %q", tt.name, msg, tt.msg)
+			}
+			continue
+		}
+		if msg != "" || string(got.Redaction) != tt.want {
+			t.Errorf("%s: got %s %q, want %s", tt.name, got.Redaction, msg, tt.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// AI-assisted code
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// Logsets can carry a JSON Schema that the ingesters check entries
+// against. It's only compiled here to refuse broken schemas up front; the
+// ingester has the same compileSchema.
+
+const maxSchemaSize = 64 << 10
+
+// compileSchema compiles a logset schema. Schemas without $schema are
+// draft 2020-12. Nothing is loaded from outside the schema itself, so $ref
+// only works within it. Formats are asserted, not just annotations.
+func compileSchema(raw []byte) (*jsonschema.Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	c := jsonschema.NewCompiler()
+	c.DefaultDraft(jsonschema.Draft2020)
+	c.UseLoader(jsonschema.SchemeURLLoader{})
+	c.AssertFormat()
+	if err := c.AddResource("logset.json", doc); err != nil {
+		return nil, err
+	}
+	return c.Compile("logset.json")
+}
+
+type schemaRequest struct {
+	Schema     json.RawMessage `json:"schema"`
+	SchemaMode *string         `json:"schema_mode"`
+}
+
+// apply merges a create or update request into s, returning a validation
+// error message or "". A null schema removes it.
+func (req schemaRequest) apply(s *LogsetSettings) string {
+	switch {
+	case string(req.Schema) == "null":
+		s.Schema, s.SchemaMode = nil, ""
+	case req.Schema != nil:
+		if len(req.Schema) > maxSchemaSize {
+			return fmt.Sprintf("schema must be at most %d bytes", maxSchemaSize)
+		}
+		if _, err := compileSchema(req.Schema); err != nil {
+			return "invalid schema: " + err.Error()
+		}
+		var compact bytes.Buffer
+		json.Compact(&compact, req.Schema)
+		s.Schema = compact.Bytes()
+		if s.SchemaMode == "" {
+			s.SchemaMode = "reject"
+		}
+	}
+	if req.SchemaMode != nil {
+		if *req.SchemaMode != "reject" && *req.SchemaMode != "warn" {
+			return "schema_mode must be reject or warn"
+		}
+		if s.Schema == nil {
+			return "schema_mode needs a schema"
+		}
+		s.SchemaMode = *req.SchemaMode
+	}
+	return ""
+}
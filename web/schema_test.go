@@ -0,0 +1,63 @@
+// AI-assisted code
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSchemaRequestApply(t *testing.T) {
+	mode := func(m string) *string { return &m }
+	schema := json.RawMessage(`{"type": "object", "required": ["miles"]}`)
+	existing := LogsetSettings{Schema: json.RawMessage(`{"type":"object"}`), SchemaMode: "warn"}
+
+	tests := []struct {
+		name     string
+		req      schemaRequest
+		existing LogsetSettings
+		want     LogsetSettings
+		msg      string
+	}{
+		{name: "nothing", want: LogsetSettings{}},
+		{name: "set defaults to reject", req: schemaRequest{Schema: schema},
+			want: LogsetSettings{Schema: json.RawMessage(`{"type":"object","required":["miles"]}`), SchemaMode: "reject"}},
+		{name: "replace keeps mode", req: schemaRequest{Schema: schema}, existing: existing,
+			want: LogsetSettings{Schema: json.RawMessage(`{"type":"object","required":["miles"]}`), SchemaMode: "warn"}},
+		{name: "mode only", req: schemaRequest{SchemaMode: mode("reject")}, existing: existing,
+			want: LogsetSettings{Schema: existing.Schema, SchemaMode: "reject"}},
+		{name: "remove", req: schemaRequest{Schema: json.RawMessage("null")}, existing: existing, want: LogsetSettings{}},
+		{name: "mode without schema", req: schemaRequest{SchemaMode: mode("warn")}, msg: "schema_mode needs a schema"},
+		{name: "bad mode", req: schemaRequest{Schema: schema, SchemaMode: mode("drop")}, msg: "schema_mode must be reject or warn"},
+		{name: "bad schema", req: schemaRequest{Schema: json.RawMessage(`{"type": 5}`)}, msg: "invalid schema: "},
+		{name: "external ref", req: schemaRequest{Schema: json.RawMessage(`{"$ref": "file:///etc/passwd"}`)}, msg: "invalid schema: "},
+	}
+	for _, tt := range tests {
+		got := tt.existing
+		msg := tt.req.apply(&got)
+		if tt.msg != "" {
+			if !strings.HasPrefix(msg, tt.msg) {
+				t.Errorf("%s: msg %q, want %q", tt.name, msg, tt.msg)
+			}
+			continue
+		}
+		if msg != "" {
+			t.Errorf("%s: unexpected msg %q", tt.name, msg)
+			continue
+		}
+		if string(got.Schema) != string(tt.want.Schema) || got.SchemaMode != tt.want.SchemaMode {
+			t.Errorf("%s: got %s %q, want %s %q", tt.name, got.Schema, got.SchemaMode, tt.want.Schema, tt.want.SchemaMode)
+		}
+	}
+}
+
+func TestLogsetSettingsRoundTrip(t *testing.T) {
+	if encodeLogsetSettings(LogsetSettings{}) != "" {
+		t.Error("empty settings encoded")
+	}
+	s := LogsetSettings{Schema: json.RawMessage(`{"type":"object"}`), SchemaMode: "warn"}
+	got := decodeLogsetSettings(encodeLogsetSettings(s))
+	if string(got.Schema) != string(s.Schema) || got.SchemaMode != "warn" {
+		t.Errorf("got %+v", got)
+	}
+}
@@ -0,0 +1,186 @@
+// AI-assisted code
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Sessions slide: every use pushes expiry out to the idle timeout, but never
+// past the absolute max age measured from login.
+const (
+	defaultSessionIdle   = 30 * 24 * time.Hour
+	defaultSessionMaxAge = 90 * 24 * time.Hour
+)
+
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("%s: invalid duration %q, using %s", name, v, def)
+		return def
+	}
+	return d
+}
+
+func sessionIdleTimeout() time.Duration {
+	return envDuration("SESSION_IDLE_TIMEOUT", defaultSessionIdle)
+}
+
+func sessionMaxAge() time.Duration {
+	return envDuration("SESSION_MAX_AGE", defaultSessionMaxAge)
+}
+
+// sessionExpiry is when a session created at createdAt and last used at
+// lastUsed runs out.
+func sessionExpiry(createdAt, lastUsed time.Time) time.Time {
+	idle := lastUsed.Add(sessionIdleTimeout())
+	hard := createdAt.Add(sessionMaxAge())
+	if idle.Before(hard) {
+		return idle
+	}
+	return hard
+}
+
+var errSessionExpired = errors.New("session expired")
+
+// renewSession rewrites a session's token and sessions row with a fresh TTL.
+func renewSession(tok TokenInfo) (time.Time, error) {
+	s, err := dbGetSession(session, tok.UserID, *tok.SessionID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	expiresAt := sessionExpiry(tok.CreatedAt, time.Now())
+	ttl := time.Until(expiresAt)
+	if ttl < time.Second {
+		return time.Time{}, errSessionExpired
+	}
+	applied, err := dbRenewSession(session, tok, s, ttl)
+	if err == nil && !applied {
+		err = errSessionExpired
+	}
+	return expiresAt, err
+}
+
+// Renewals are throttled so an active session costs at most one write per
+// interval instead of one per request.
+const touchInterval = time.Minute
+
+var (
+	touchMu   sync.Mutex
+	lastTouch = map[gocql.UUID]time.Time{}
+)
+
+func touchSession(tok TokenInfo) {
+	now := time.Now()
+	touchMu.Lock()
+	if now.Sub(lastTouch[*tok.SessionID]) < touchInterval {
+		touchMu.Unlock()
+		return
+	}
+	lastTouch[*tok.SessionID] = now
+	for id, t := range lastTouch {
+		if now.Sub(t) > touchInterval {
+			delete(lastTouch, id)
+		}
+	}
+	touchMu.Unlock()
+
+	if _, err := renewSession(tok); err != nil && !errors.Is(err, errSessionExpired) {
+		log.Println("session renew error:", err)
+	}
+}
+
+// clientIP returns the caller's address. X-Forwarded-For is only honored
+// with TRUST_PROXY=true, since anyone can send it. Even then only the last
+// entry is used: that's the one our proxy appended, while everything before
+// it came from the client.
+func clientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY") == "true" {
+		if fwd := r.Header.Values("X-Forwarded-For"); len(fwd) > 0 {
+			hops := strings.Split(fwd[len(fwd)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func handleListSessions(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	sessions, err := dbListSessions(session, userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list sessions")
+		return
+	}
+	if sessions == nil {
+		sessions = []Session{}
+	}
+	current := getSessionID(r)
+	for i := range sessions {
+		sessions[i].ExpiresAt = sessionExpiry(sessions[i].CreatedAt, sessions[i].LastUsed)
+		sessions[i].Current = current != nil && sessions[i].SessionID == current.String()
+	}
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+func handleRefreshSession(w http.ResponseWriter, r *http.Request) {
+	tok := getToken(r)
+	if tok.SessionID == nil {
+		writeError(w, http.StatusBadRequest, "not a session token")
+		return
+	}
+
+	expiresAt, err := renewSession(tok)
+	if errors.Is(err, errSessionExpired) {
+		writeError(w, http.StatusUnauthorized, "session expired")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to refresh session")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":     "ok",
+		"expires_at": expiresAt,
+	})
+}
+
+func handleDeleteSession(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	sessionID, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "session not found")
+		return
+	}
+
+	s, err := dbGetSession(session, userID, sessionID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "session not found")
+		return
+	}
+
+	if err := dbDeleteSession(session, userID, sessionID, s.tokenHash); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to delete session")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"net/http"
+	"time"
 )
 
 func handleListTokens(w http.ResponseWriter, r *http.Request) {
@@ -49,6 +50,13 @@ func handleCreateToken(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, "failed to create token")
 		return
 	}
+	notifyEmail(userID, "api_key", "New LibreLog API key: "+req.Name,
+		"An API key was created for your LibreLog account.\n\n"+
+			"Name: "+req.Name+"\n"+
+			"Prefix: "+prefix+"\n"+
+			"IP address: "+clientIP(r)+"\n"+
+			"Time: "+time.Now().UTC().Format(time.RFC1123)+"\n\n"+
+			"If you didn't create it, delete it from the API keys list.\n")
 
 	writeJSON(w, http.StatusCreated, map[string]string{
 		"token":  token,
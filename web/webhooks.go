@@ -0,0 +1,311 @@
+// AI-assisted code
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Outbound webhooks are stored here and delivered by the ingesters, which
+// also queue the entry and alert events. Logset changes happen here, so
+// their events are queued by notifyLogsetChange.
+
+var webhookEvents = map[string]bool{"entry": true, "alert": true, "logset": true, "heartbeat": true}
+
+type webhookRequest struct {
+	LogID  *string         `json:"log_id"`
+	Name   *string         `json:"name"`
+	URL    *string         `json:"url"`
+	Events []string        `json:"events"`
+	Filter *AlertCondition `json:"filter"`
+}
+
+// apply merges a create or update request into h, returning a validation
+// error message or "".
+func (req webhookRequest) apply(h *Webhook) string {
+	if req.Name != nil {
+		h.Name = *req.Name
+	}
+	if req.URL != nil {
+		h.URL = *req.URL
+	}
+	if req.Events != nil {
+		h.Events = req.Events
+	}
+	if req.Filter != nil {
+		h.Filter = req.Filter
+		if req.Filter.Field == "" && req.Filter.Op == "" {
+			h.Filter = nil
+		}
+	}
+
+	if h.Name == "" {
+		return "name required"
+	}
+	u, err := url.Parse(h.URL)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return "url must be an http or https URL"
+	}
+	if len(h.Events) == 0 {
+		return "events required"
+	}
+	for _, e := range h.Events {
+		if !webhookEvents[e] {
+			return "events must be entry, alert, logset or heartbeat"
+		}
+	}
+	if h.Filter != nil {
+		if msg := validateCondition(h.Filter); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+// notifyLogsetChange queues a logset event for the logset's webhooks.
+// Failures are only logged; the change itself has already happened.
+func notifyLogsetChange(userID gocql.UUID, logset Logset, action string) {
+	hooks, err := dbListWebhooks(session, userID)
+	if err != nil {
+		log.Println("webhooks:", err)
+		return
+	}
+	for _, h := range hooks {
+		subscribed := false
+		for _, e := range h.Events {
+			subscribed = subscribed || e == "logset"
+		}
+		if h.LogID != logset.LogID || !subscribed {
+			continue
+		}
+
+		deliveryID := gocql.TimeUUID()
+		payload, err := json.Marshal(map[string]interface{}{
+			"event":       "logset",
+			"webhook_id":  h.WebhookID,
+			"delivery_id": deliveryID.String(),
+			"log_id":      logset.LogID,
+			"time":        time.Now(),
+			"action":      action,
+			"logset":      logset,
+		})
+		if err != nil {
+			log.Println("webhooks:", err)
+			continue
+		}
+		webhookID, _ := gocql.ParseUUID(h.WebhookID)
+		if err := dbEnqueueWebhook(session, userID, webhookID, deliveryID, "logset", payload); err != nil {
+			log.Println("webhooks:", err)
+		}
+	}
+}
+
+func handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	hooks, err := dbListWebhooks(session, userID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list webhooks")
+		return
+	}
+
+	logID := r.URL.Query().Get("log_id")
+	list := []Webhook{}
+	for _, h := range hooks {
+		if logID == "" || h.LogID == logID {
+			list = append(list, h)
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if req.LogID == nil {
+		writeError(w, http.StatusBadRequest, "log_id required")
+		return
+	}
+	logset, err := dbGetLogset(session, userID, *req.LogID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "logset not found")
+		return
+	}
+
+	h := Webhook{LogID: *req.LogID, CreatedAt: time.Now()}
+	if msg := req.apply(&h); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if h.Filter != nil && refuseEncrypted(w, logset, "a webhook filter") {
+		return
+	}
+
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to generate secret")
+		return
+	}
+	h.secret = hex.EncodeToString(secretBytes)
+
+	webhookID := gocql.TimeUUID()
+	h.WebhookID = webhookID.String()
+	if err := dbPutWebhook(session, userID, webhookID, h); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create webhook")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"webhook": h,
+		"secret":  h.secret,
+	})
+}
+
+func handleUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+
+	webhookID, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+	h, err := dbGetWebhook(session, userID, webhookID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if req.LogID != nil && *req.LogID != h.LogID {
+		writeError(w, http.StatusBadRequest, "log_id can't be changed")
+		return
+	}
+	if msg := req.apply(&h); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	if h.Filter != nil {
+		logset, err := dbGetLogset(session, userID, h.LogID)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "logset not found")
+			return
+		}
+		if refuseEncrypted(w, logset, "a webhook filter") {
+			return
+		}
+	}
+
+	if err := dbPutWebhook(session, userID, webhookID, h); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update webhook")
+		return
+	}
+	writeJSON(w, http.StatusOK, h)
+}
+
+func handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+
+	webhookID, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+	if _, err := dbGetWebhook(session, userID, webhookID); err != nil {
+		writeError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+	if err := dbDeleteWebhook(session, userID, webhookID); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to delete webhook")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func handleListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+
+	webhookID, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+	if _, err := dbGetWebhook(session, userID, webhookID); err != nil {
+		writeError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed < 1 || parsed > 1000 {
+			writeError(w, http.StatusBadRequest, "limit must be 1-1000")
+			return
+		}
+		limit = parsed
+	}
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", "pending", "delivered", "dead":
+	default:
+		writeError(w, http.StatusBadRequest, "status must be pending, delivered or dead")
+		return
+	}
+
+	deliveries, err := dbListWebhookDeliveries(session, webhookID, status, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list deliveries")
+		return
+	}
+	if deliveries == nil {
+		deliveries = []WebhookDelivery{}
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+func handleRetryWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+
+	webhookID, err := gocql.ParseUUID(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+	deliveryID, err := gocql.ParseUUID(r.PathValue("delivery_id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "delivery not found")
+		return
+	}
+	if _, err := dbGetWebhook(session, userID, webhookID); err != nil {
+		writeError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+
+	applied, err := dbRetryWebhookDelivery(session, webhookID, deliveryID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to retry delivery")
+		return
+	}
+	if !applied {
+		writeError(w, http.StatusConflict, "only dead deliveries can be retried")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}